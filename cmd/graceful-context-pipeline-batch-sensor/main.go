@@ -146,6 +146,11 @@ func main() {
 		ThenBatch(batchSummarize, pipeline.BatchPolicy{Size: 10}).
 		To(sink)
 
-	res, err := runnable.Run(ctx)
+	// Ctrl+C drains in-flight readings; a second Ctrl+C aborts. SIGHUP flushes
+	// the partial batch early.
+	res, err := pipeline.RunWithSignals(ctx, runnable, pipeline.SignalPolicy{
+		DrainTimeout:  5 * time.Second,
+		FlushOnHangup: true,
+	})
 	fmt.Printf("result=%s err=%v batches=%d\n", res.State(), err, batchCount)
 }
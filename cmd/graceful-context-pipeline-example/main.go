@@ -37,10 +37,11 @@ func main() {
 		return fmt.Sprintf("value=%d", n*2), nil
 	}
 
-	res, err := pipeline.New("example", src).
+	runnable := pipeline.New("example", src).
 		Then(thn).
-		To(sink).
-		Run(ctx)
+		To(sink)
+
+	res, err := pipeline.RunWithSignals(ctx, runnable, pipeline.SignalPolicy{DrainTimeout: time.Second})
 
 	fmt.Printf("result=%s err=%v out=%v\n", res.State(), err, out)
 }
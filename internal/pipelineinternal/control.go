@@ -0,0 +1,72 @@
+package pipelineinternal
+
+import "sync"
+
+// broadcast wakes every current waiter each time it is triggered. Waiters must
+// fetch a fresh channel with wait after every wake-up.
+type broadcast struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+func newBroadcast() *broadcast {
+	return &broadcast{ch: make(chan struct{})}
+}
+
+func (b *broadcast) wait() <-chan struct{} {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.ch
+}
+
+func (b *broadcast) trigger() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	close(b.ch)
+	b.ch = make(chan struct{})
+}
+
+// gate holds back intake while paused or held. Every transition triggers
+// changed. Holds are counted separately so they never undo a Pause.
+type gate struct {
+	mu      sync.Mutex
+	paused  bool
+	held    int
+	changed *broadcast
+}
+
+func newGate() *gate {
+	return &gate{changed: newBroadcast()}
+}
+
+// state reports whether the gate is paused and a channel that is closed on the
+// next transition.
+func (g *gate) state() (paused bool, changed <-chan struct{}) {
+	if g == nil {
+		return false, nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.paused || g.held > 0, g.changed.wait()
+}
+
+// hold adds (delta 1) or removes (delta -1) a hold.
+func (g *gate) hold(delta int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.held += delta
+	g.changed.trigger()
+}
+
+func (g *gate) set(paused bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.paused == paused {
+		return
+	}
+	g.paused = paused
+	g.changed.trigger()
+}
@@ -0,0 +1,222 @@
+package pipelineinternal
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrStopped is the intake cancellation cause of a graceful stop.
+	ErrStopped = errors.New("pipeline: stopped")
+	// ErrDrainTimeout is the cancellation cause when a graceful stop did not
+	// finish draining before its deadline.
+	ErrDrainTimeout = errors.New("pipeline: drain timeout exceeded")
+	// ErrStageNotFound is returned for a stage index outside the pipeline.
+	ErrStageNotFound = errors.New("pipeline: stage not found")
+	// ErrNotScalable is returned when resizing a stage without a worker pool,
+	// such as a batch stage.
+	ErrNotScalable = errors.New("pipeline: stage concurrency cannot be changed")
+	// ErrDropped is the outcome of a tracked item discarded by an overflow
+	// policy.
+	ErrDropped = errors.New("pipeline: item dropped")
+	// ErrAbandoned is the outcome of a tracked item that did not reach the
+	// sink because the run was cancelled or had already failed.
+	ErrAbandoned = errors.New("pipeline: item abandoned")
+)
+
+// Phase is the lifecycle phase of an Execution.
+type Phase int32
+
+const (
+	PhaseStarting Phase = iota
+	PhaseRunning
+	PhasePaused
+	PhaseDraining
+	PhaseStopped
+)
+
+// Execution is a started pipeline run.
+type Execution struct {
+	done   chan struct{}
+	state  RunState
+	cause  error
+	stats  *Stats
+	onDone func()
+	flush  *broadcast
+	intake *gate
+	// pools holds the worker pool of each single-item stage (nil otherwise).
+	pools []*workerPool
+
+	// ctl serializes Pause, Resume and Stop so phase and gate stay in step.
+	ctl      sync.Mutex
+	phase    atomic.Int32
+	stopping atomic.Bool
+	stopOnce sync.Once
+	clock    Clock
+
+	cancelRun    context.CancelCauseFunc
+	cancelSource context.CancelCauseFunc
+}
+
+func newExecution(cancelRun, cancelSource context.CancelCauseFunc, stats *Stats, onDone func(), clock Clock) *Execution {
+	return &Execution{
+		done:         make(chan struct{}),
+		clock:        clock,
+		stats:        stats,
+		onDone:       onDone,
+		flush:        newBroadcast(),
+		intake:       newGate(),
+		cancelRun:    cancelRun,
+		cancelSource: cancelSource,
+	}
+}
+
+// Done is closed once every pipeline goroutine has exited.
+func (e *Execution) Done() <-chan struct{} {
+	return e.done
+}
+
+// Wait blocks until the run finishes and returns its outcome.
+func (e *Execution) Wait() (RunState, error) {
+	<-e.done
+	return e.state, e.cause
+}
+
+// Phase reports the current lifecycle phase.
+func (e *Execution) Phase() Phase {
+	return Phase(e.phase.Load())
+}
+
+// Stop stops intake and lets in-flight items drain to the sink, cancelling
+// the run with ErrDrainTimeout after drainTimeout (if positive).
+func (e *Execution) Stop(drainTimeout time.Duration) {
+	e.stopOnce.Do(func() {
+		e.ctl.Lock()
+		defer e.ctl.Unlock()
+		e.stats.sunkAtStop.Store(e.stats.Sink.Out.Load())
+		e.stopping.Store(true)
+		e.phase.CompareAndSwap(int32(PhaseStarting), int32(PhaseDraining))
+		e.phase.CompareAndSwap(int32(PhaseRunning), int32(PhaseDraining))
+		e.phase.CompareAndSwap(int32(PhasePaused), int32(PhaseDraining))
+		// Suspended batch timers must run again so the drain can finish.
+		e.intake.set(false)
+		e.cancelSource(ErrStopped)
+		if drainTimeout > 0 {
+			t := e.clock.NewTimer(drainTimeout)
+			go func() {
+				defer t.Stop()
+				select {
+				case <-t.C():
+					e.cancelRun(ErrDrainTimeout)
+				case <-e.done:
+				}
+			}()
+		}
+	})
+}
+
+// Pause stops admitting items from the source until Resume. Stages keep
+// running and keep their batch buffers. It only has an effect while running.
+func (e *Execution) Pause() {
+	e.ctl.Lock()
+	defer e.ctl.Unlock()
+	if e.phase.CompareAndSwap(int32(PhaseRunning), int32(PhasePaused)) {
+		e.intake.set(true)
+	}
+}
+
+// Resume undoes Pause.
+func (e *Execution) Resume() {
+	e.ctl.Lock()
+	defer e.ctl.Unlock()
+	if e.phase.CompareAndSwap(int32(PhasePaused), int32(PhaseRunning)) {
+		e.intake.set(false)
+	}
+}
+
+// Hold stops admitting items from the source, like Pause but independently
+// of it and without changing the phase, until Unhold.
+func (e *Execution) Hold() {
+	e.intake.hold(1)
+}
+
+// Unhold undoes one Hold.
+func (e *Execution) Unhold() {
+	e.intake.hold(-1)
+}
+
+// Flush asks every batch stage to flush its partial batch now.
+func (e *Execution) Flush() {
+	e.flush.trigger()
+}
+
+// SetConcurrency changes the worker count of a single-item stage while it runs.
+// Autoscaled stages are clamped to their bounds and keep autoscaling from the
+// new size. It returns the resulting worker count.
+func (e *Execution) SetConcurrency(stage, n int) (int, error) {
+	if stage < 0 || stage >= len(e.pools) {
+		return 0, ErrStageNotFound
+	}
+	p := e.pools[stage]
+	if p == nil {
+		return 0, ErrNotScalable
+	}
+	return p.resize(n), nil
+}
+
+// Drained reports the items written by the sink since Stop was called and the
+// items abandoned because of cancellation.
+func (e *Execution) Drained() (completed int64, abandoned int64) {
+	return e.stats.Drained()
+}
+
+// Cancel hard-cancels the run with the given cause (context.Canceled if nil).
+func (e *Execution) Cancel(cause error) {
+	e.cancelRun(cause)
+}
+
+func (e *Execution) running() {
+	e.phase.CompareAndSwap(int32(PhaseStarting), int32(PhaseRunning))
+}
+
+func (e *Execution) outcome(rootCtx, runCtx context.Context, policy *errorPolicy) (RunState, error) {
+	// Cancellation wins.
+	if rootCtx.Err() != nil {
+		return StateCancelled, context.Cause(rootCtx)
+	}
+	if runCtx.Err() != nil {
+		cause := context.Cause(runCtx)
+		if errors.Is(cause, ErrDrainTimeout) {
+			return StateStopped, cause
+		}
+		return StateCancelled, cause
+	}
+
+	if cause := policy.get(); cause != nil {
+		// If the source context was canceled with a cause, normalize to the cause.
+		if errors.Is(cause, context.Canceled) || errors.Is(cause, context.DeadlineExceeded) {
+			return StateCancelled, cause
+		}
+		return StateFailed, cause
+	}
+
+	if e.stopping.Load() {
+		return StateStopped, nil
+	}
+	return StateSucceeded, nil
+}
+
+func (e *Execution) finish(state RunState, cause error) {
+	e.cancelSource(nil)
+	e.cancelRun(nil)
+
+	e.state, e.cause = state, cause
+	e.phase.Store(int32(PhaseStopped))
+	if e.onDone != nil {
+		e.onDone()
+	}
+	close(e.done)
+}
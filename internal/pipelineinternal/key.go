@@ -0,0 +1,49 @@
+package pipelineinternal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+)
+
+type keyCtx struct{}
+
+// withKey attaches an idempotency key to the context of a sink call.
+func withKey(ctx context.Context, key string) context.Context {
+	if key == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, keyCtx{}, key)
+}
+
+// ItemKey returns the idempotency key of the item a sink is writing.
+func ItemKey(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(keyCtx{}).(string)
+	return key, ok
+}
+
+// batchKey digests the input keys of a batch in order, so the keys derived
+// from it are stable as long as the batch is formed the same way. It is empty
+// when no input has a key.
+func batchKey(inputs []feed) string {
+	h := sha256.New()
+	keyed := false
+	for _, f := range inputs {
+		keyed = keyed || f.key != ""
+		h.Write([]byte(f.key))
+		h.Write([]byte{0})
+	}
+	if !keyed {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// outputKey is the key of output i of a batch with the given batchKey.
+func outputKey(batch string, i int) string {
+	if batch == "" {
+		return ""
+	}
+	return batch + "/" + strconv.Itoa(i)
+}
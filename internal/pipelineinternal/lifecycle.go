@@ -0,0 +1,100 @@
+package pipelineinternal
+
+import (
+	"context"
+	"fmt"
+)
+
+// Lifecycle opens a resource before a stage, or each of its workers, handles
+// its first item and closes it once the stage or worker is done, on every
+// exit path. Handler calls find the resource with Resource.
+type Lifecycle struct {
+	// PerWorker opens a resource for each worker of a single-item stage,
+	// including workers added by scaling, instead of one for the stage.
+	PerWorker bool
+	Open      func(ctx context.Context) (any, error)
+	// Close, if set, is called with a context that is not cancelled with the
+	// run, so it can flush after cancellation.
+	Close func(ctx context.Context, resource any) error
+}
+
+type resourceCtx struct{}
+
+// Resource returns the resource that the Lifecycle of the calling stage
+// opened for it.
+func Resource(ctx context.Context) any {
+	return ctx.Value(resourceCtx{})
+}
+
+// opener opens the Lifecycle resources of one stage or of the sink.
+type opener struct {
+	life   *Lifecycle
+	name   string
+	policy *errorPolicy
+}
+
+func (o opener) perWorker() bool {
+	return o.life != nil && o.life.PerWorker
+}
+
+// open opens a resource; it is nil without a Lifecycle. A failed Open fails
+// the run and yields a resource whose bind returns the error.
+func (o opener) open(ctx context.Context) *resource {
+	if o.life == nil {
+		return nil
+	}
+	r := &resource{o: o}
+	r.value, r.err = o.call(func() (any, error) { return o.life.Open(ctx) })
+	if r.err != nil {
+		r.err = fmt.Errorf("pipeline: open%s: %w", formatStage(o.name), r.err)
+		o.policy.set(r.err)
+	}
+	return r
+}
+
+// call runs fn, turning a panic into an error.
+func (o opener) call(fn func() (any, error)) (v any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn()
+}
+
+// resource is an opened Lifecycle resource.
+type resource struct {
+	o     opener
+	value any
+	err   error
+}
+
+// bind returns ctx carrying the resource, or the error of its Open.
+func (r *resource) bind(ctx context.Context) (context.Context, error) {
+	if r == nil {
+		return ctx, nil
+	}
+	if r.err != nil {
+		return ctx, r.err
+	}
+	return context.WithValue(ctx, resourceCtx{}, r.value), nil
+}
+
+// close closes a successfully opened resource; a failed Close fails the run.
+func (r *resource) close(ctx context.Context) {
+	if r == nil || r.err != nil || r.o.life.Close == nil {
+		return
+	}
+	_, err := r.o.call(func() (any, error) { return nil, r.o.life.Close(context.WithoutCancel(ctx), r.value) })
+	if err != nil {
+		r.o.policy.set(fmt.Errorf("pipeline: close%s: %w", formatStage(r.o.name), err))
+	}
+}
+
+// failInput fails every item of in with err, so upstream never blocks on a
+// stage or sink that could not open.
+func failInput(in <-chan feed, err error) {
+	for f := range in {
+		f.org.fail(err)
+	}
+}
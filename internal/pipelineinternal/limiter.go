@@ -0,0 +1,170 @@
+package pipelineinternal
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LimitAlgorithm selects how an adaptive limiter reacts to samples.
+type LimitAlgorithm int
+
+const (
+	// LimitAIMD grows the limit by one while it is in use and cuts it by
+	// limitBackoff on a drop (an overload report or a slow call).
+	LimitAIMD LimitAlgorithm = iota
+	// LimitGradient scales the limit by the ratio of the long-term to the
+	// current latency, growing while latency stays flat and shrinking as
+	// queueing at the backend makes it rise.
+	LimitGradient
+)
+
+// LimiterConfig configures adaptive limiting of in-flight handler calls.
+type LimiterConfig struct {
+	Algorithm LimitAlgorithm
+	Initial   int
+	Min       int
+	Max       int
+	// Timeout marks a call slower than this as a drop. Zero disables it.
+	Timeout time.Duration
+}
+
+const (
+	limitBackoff    = 0.9
+	gradientSmooth  = 0.2
+	gradientWindow  = 100
+	gradientMinTune = 0.5
+)
+
+// limiter bounds the number of concurrent handler calls of one stage and
+// adapts that bound from the outcome of each call.
+type limiter struct {
+	cfg LimiterConfig
+
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+	released *broadcast
+
+	// Gradient state: exponentially smoothed long-term latency.
+	longRTT float64
+
+	// Published for stats.
+	current atomic.Int64
+	active  atomic.Int64
+}
+
+func newLimiter(cfg LimiterConfig) *limiter {
+	if cfg.Min < 1 {
+		cfg.Min = 1
+	}
+	if cfg.Max < cfg.Min {
+		cfg.Max = cfg.Min
+	}
+	if cfg.Initial < cfg.Min || cfg.Initial > cfg.Max {
+		cfg.Initial = cfg.Min
+	}
+	l := &limiter{cfg: cfg, limit: float64(cfg.Initial), released: newBroadcast()}
+	l.current.Store(int64(cfg.Initial))
+	return l
+}
+
+// acquire blocks until a call may start or ctx is done.
+func (l *limiter) acquire(ctx context.Context) bool {
+	for {
+		l.mu.Lock()
+		if l.inflight < int(l.limit) {
+			l.inflight++
+			l.active.Store(int64(l.inflight))
+			l.mu.Unlock()
+			return true
+		}
+		wake := l.released.wait()
+		l.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-wake:
+		}
+	}
+}
+
+// release ends a call that took rtt and adapts the limit. dropped reports a
+// call the backend pushed back on; errors fail the run and are not drops.
+func (l *limiter) release(rtt time.Duration, dropped bool) {
+	if l.cfg.Timeout > 0 && rtt > l.cfg.Timeout {
+		dropped = true
+	}
+
+	l.mu.Lock()
+	inflight := l.inflight
+	l.inflight--
+	l.active.Store(int64(l.inflight))
+
+	switch l.cfg.Algorithm {
+	case LimitGradient:
+		l.gradient(rtt, inflight, dropped)
+	default:
+		l.aimd(inflight, dropped)
+	}
+	l.limit = math.Min(math.Max(l.limit, float64(l.cfg.Min)), float64(l.cfg.Max))
+	l.current.Store(int64(l.limit))
+	l.mu.Unlock()
+
+	l.released.trigger()
+}
+
+func (l *limiter) aimd(inflight int, dropped bool) {
+	switch {
+	case dropped:
+		l.limit *= limitBackoff
+	case float64(inflight)*2 >= l.limit:
+		// Only grow a limit that is actually being used.
+		l.limit++
+	}
+}
+
+func (l *limiter) gradient(rtt time.Duration, inflight int, dropped bool) {
+	short := float64(rtt)
+	if short <= 0 {
+		short = 1
+	}
+	if l.longRTT == 0 {
+		l.longRTT = short
+	}
+	l.longRTT += (short - l.longRTT) * 2 / (gradientWindow + 1)
+	// Let the baseline recover quickly after a sustained latency rise.
+	if l.longRTT > short*2 {
+		l.longRTT *= 0.95
+	}
+
+	if !dropped && float64(inflight)*2 < l.limit {
+		return
+	}
+	grad := math.Max(gradientMinTune, math.Min(1, l.longRTT/short))
+	if dropped {
+		grad = gradientMinTune
+	}
+	target := l.limit*grad + math.Sqrt(l.limit)
+	l.limit = l.limit*(1-gradientSmooth) + target*gradientSmooth
+}
+
+type overloadKey struct{}
+
+// withOverload returns a handler context on which ReportOverload records a
+// drop for the current call.
+func withOverload(ctx context.Context) (context.Context, *atomic.Bool) {
+	flag := new(atomic.Bool)
+	return context.WithValue(ctx, overloadKey{}, flag), flag
+}
+
+// ReportOverload marks the current handler call as pushed back by its backend
+// so an adaptive limiter backs off. It does nothing outside a limited stage.
+func ReportOverload(ctx context.Context) {
+	if flag, ok := ctx.Value(overloadKey{}).(*atomic.Bool); ok {
+		flag.Store(true)
+	}
+}
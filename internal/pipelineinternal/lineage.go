@@ -0,0 +1,178 @@
+package pipelineinternal
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// lineage reference-counts the feeds derived from an admitted source item and
+// runs done with the first failure, or nil, once the last one leaves. Batch
+// outputs share a lineage whose parents are their inputs'. A nil *lineage
+// does nothing.
+type lineage struct {
+	refs    atomic.Int32
+	parents []*lineage
+	done    func(error)
+	err     atomic.Pointer[error]
+}
+
+func newLineage(done func(error)) *lineage {
+	l := &lineage{done: done}
+	l.refs.Store(1)
+	return l
+}
+
+// group returns a lineage for n outputs derived from the given inputs, taking
+// over their references. With no outputs the inputs are released at once.
+func group(inputs []feed, n int) *lineage {
+	var parents []*lineage
+	for _, f := range inputs {
+		if f.org != nil {
+			parents = append(parents, f.org)
+		}
+	}
+	if len(parents) == 0 {
+		return nil
+	}
+	g := &lineage{parents: parents}
+	if n == 0 {
+		g.finish()
+		return nil
+	}
+	g.refs.Store(int32(n))
+	return g
+}
+
+// release drops one reference of a feed that was delivered.
+func (l *lineage) release() {
+	if l != nil && l.refs.Add(-1) == 0 {
+		l.finish()
+	}
+}
+
+// fail drops one reference of a feed that left the pipeline without being
+// delivered, recording err unless an earlier failure was recorded.
+func (l *lineage) fail(err error) {
+	if l != nil {
+		l.err.CompareAndSwap(nil, &err)
+		l.release()
+	}
+}
+
+func (l *lineage) finish() {
+	var err error
+	if p := l.err.Load(); p != nil {
+		err = *p
+	}
+	for _, p := range l.parents {
+		if err != nil {
+			p.fail(err)
+		} else {
+			p.release()
+		}
+	}
+	if l.done != nil {
+		l.done(err)
+	}
+}
+
+// failAll fails the lineages of inputs that produce no outputs because of err.
+func failAll(inputs []feed, err error) {
+	for _, f := range inputs {
+		f.org.fail(err)
+	}
+}
+
+// budget bounds the items and bytes admitted but not yet out of the pipeline.
+// A zero limit is unbounded.
+type budget struct {
+	maxItems int64
+	maxBytes int64
+	sizer    func(any) (int64, error)
+
+	mu      sync.Mutex
+	items   int64
+	bytes   int64
+	changed *broadcast
+}
+
+func newBudget(maxItems, maxBytes int64, sizer func(any) (int64, error)) *budget {
+	if maxItems <= 0 && (maxBytes <= 0 || sizer == nil) {
+		return nil
+	}
+	if sizer == nil {
+		maxBytes = 0
+	}
+	return &budget{maxItems: maxItems, maxBytes: maxBytes, sizer: sizer, changed: newBroadcast()}
+}
+
+// admit waits until v fits in the budget and returns its lineage, which
+// reports the item's outcome to done (if set). An item larger than the byte
+// limit is admitted once nothing else is in flight. It fails if ctx is done
+// or the sizer fails.
+func (b *budget) admit(ctx context.Context, v any, done func(error)) (*lineage, error) {
+	if b == nil {
+		if done == nil {
+			return nil, nil
+		}
+		return newLineage(done), nil
+	}
+	var size int64
+	if b.maxBytes > 0 {
+		var err error
+		if size, err = b.sizer(v); err != nil {
+			return nil, err
+		}
+	}
+
+	b.mu.Lock()
+	for !b.fits(size) {
+		wake := b.changed.wait()
+		b.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-wake:
+		}
+		b.mu.Lock()
+	}
+	b.items++
+	b.bytes += size
+	b.mu.Unlock()
+
+	return newLineage(func(err error) {
+		if done != nil {
+			done(err)
+		}
+		b.release(size)
+	}), nil
+}
+
+func (b *budget) fits(size int64) bool {
+	if b.items == 0 {
+		return true
+	}
+	if b.maxItems > 0 && b.items >= b.maxItems {
+		return false
+	}
+	return b.maxBytes <= 0 || b.bytes+size <= b.maxBytes
+}
+
+func (b *budget) release(size int64) {
+	b.mu.Lock()
+	b.items--
+	b.bytes -= size
+	b.changed.trigger()
+	b.mu.Unlock()
+}
+
+// inFlight reports the admitted items and bytes that have not left yet.
+func (b *budget) inFlight() (items, bytes int64) {
+	if b == nil {
+		return 0, 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.items, b.bytes
+}
@@ -3,23 +3,28 @@ package pipelineinternal
 import (
 	"errors"
 	"sync"
+	"sync/atomic"
 )
 
 var ErrInvalidConfig = errors.New("pipeline: invalid configuration")
 
-// errorPolicy captures the first failure cause.
+// errorPolicy captures the first failure cause. It is read by the sink and
+// workers while other workers may be setting it.
 type errorPolicy struct {
 	once  sync.Once
-	cause error
+	cause atomic.Pointer[error]
 }
 
 func (p *errorPolicy) set(err error) {
 	if err == nil {
 		return
 	}
-	p.once.Do(func() { p.cause = err })
+	p.once.Do(func() { p.cause.Store(&err) })
 }
 
 func (p *errorPolicy) get() error {
-	return p.cause
+	if c := p.cause.Load(); c != nil {
+		return *c
+	}
+	return nil
 }
@@ -0,0 +1,211 @@
+package pipelineinternal
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// PriorityConfig turns every queue of a run into a set of priority lanes.
+type PriorityConfig struct {
+	// Of returns the priority of a source item; higher values go first. Items
+	// derived from it keep its priority, and batch outputs take the highest
+	// priority of their inputs.
+	Of func(any) int
+	// Aging raises the priority of a waiting item by one for every Aging it
+	// has waited, so lower lanes are not starved. Zero means one second.
+	Aging time.Duration
+}
+
+const defaultPriorityAging = time.Second
+
+// lanes replaces the FIFO buffer of a queue with one FIFO lane per priority,
+// from which a dispatcher hands out the highest item after aging.
+type lanes struct {
+	capacity int
+	aging    time.Duration
+	clock    Clock
+
+	mu     sync.Mutex
+	byPrio map[int][]laneItem
+	// n counts queued items plus the one held by the dispatcher.
+	n       int
+	closed  bool
+	changed *broadcast
+}
+
+type laneItem struct {
+	f  feed
+	at time.Time
+}
+
+// prioritize makes q a priority queue and starts its dispatcher, which closes
+// ch once q is closed and drained.
+func (q *queue) prioritize(p *PriorityConfig, capacity int, clock Clock) {
+	aging := p.Aging
+	if aging <= 0 {
+		aging = defaultPriorityAging
+	}
+	q.lanes = &lanes{
+		capacity: max(1, capacity),
+		aging:    aging,
+		clock:    clock,
+		byPrio:   make(map[int][]laneItem),
+		changed:  newBroadcast(),
+	}
+	q.ch = make(chan feed)
+	go q.dispatch()
+}
+
+// pushLane adds f to its lane according to the overflow policy.
+func (q *queue) pushLane(ctx context.Context, f feed) pushResult {
+	l := q.lanes
+	sampled := false
+	l.mu.Lock()
+	for l.n >= l.capacity {
+		switch q.overflow.Kind {
+		case OverflowDropNewest:
+			l.mu.Unlock()
+			q.drop(f)
+			return pushDropped
+		case OverflowDropOldest:
+			if old, ok := l.evict(); ok {
+				l.mu.Unlock()
+				q.drop(old)
+				l.mu.Lock()
+				continue
+			}
+			l.mu.Unlock()
+			q.drop(f)
+			return pushDropped
+		case OverflowSample:
+			if !sampled {
+				if (q.seen.Add(1)-1)%uint64(q.overflow.Every) != 0 {
+					l.mu.Unlock()
+					q.drop(f)
+					return pushDropped
+				}
+				sampled = true
+			}
+		}
+		wake := l.changed.wait()
+		l.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return pushCancelled
+		case <-wake:
+		}
+		l.mu.Lock()
+	}
+	if ctx.Err() != nil {
+		l.mu.Unlock()
+		return pushCancelled
+	}
+	l.byPrio[f.prio] = append(l.byPrio[f.prio], laneItem{f: f, at: l.clock.Now()})
+	l.n++
+	l.changed.trigger()
+	l.mu.Unlock()
+	return pushed
+}
+
+// evict removes the oldest item of the lowest lane. The item held by the
+// dispatcher is not in a lane, so there may be nothing to evict; the newest
+// item is dropped then.
+func (l *lanes) evict() (feed, bool) {
+	low, found := 0, false
+	for p := range l.byPrio {
+		if !found || p < low {
+			low, found = p, true
+		}
+	}
+	if !found {
+		return feed{}, false
+	}
+	it := l.pop(low)
+	l.n--
+	return it.f, true
+}
+
+func (l *lanes) pop(p int) laneItem {
+	lane := l.byPrio[p]
+	it := lane[0]
+	if len(lane) == 1 {
+		delete(l.byPrio, p)
+	} else {
+		lane[0] = laneItem{}
+		l.byPrio[p] = lane[1:]
+	}
+	return it
+}
+
+// next removes the item to deliver next together with a channel that fires
+// when another item arrives. It reports false once closed and empty.
+func (l *lanes) next() (laneItem, <-chan struct{}, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for len(l.byPrio) == 0 {
+		if l.closed {
+			return laneItem{}, nil, false
+		}
+		wake := l.changed.wait()
+		l.mu.Unlock()
+		<-wake
+		l.mu.Lock()
+	}
+
+	now := l.clock.Now()
+	best, bestScore, found := 0, 0, false
+	for p, lane := range l.byPrio {
+		score := p + int(now.Sub(lane[0].at)/l.aging)
+		if !found || score > bestScore || (score == bestScore && p > best) {
+			best, bestScore, found = p, score, true
+		}
+	}
+	return l.pop(best), l.changed.wait(), true
+}
+
+// unpop puts back an item taken by next that was not delivered.
+func (l *lanes) unpop(it laneItem) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.byPrio[it.f.prio] = append([]laneItem{it}, l.byPrio[it.f.prio]...)
+}
+
+func (l *lanes) delivered() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.n--
+	l.changed.trigger()
+}
+
+func (l *lanes) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	l.changed.trigger()
+}
+
+func (l *lanes) queued() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.n
+}
+
+// dispatch offers the best item to the consumers until another item arrives,
+// then reconsiders, so a late high-priority item overtakes a waiting one.
+func (q *queue) dispatch() {
+	defer close(q.ch)
+	l := q.lanes
+	for {
+		it, arrived, ok := l.next()
+		if !ok {
+			return
+		}
+		select {
+		case q.ch <- it.f:
+			l.delivered()
+		case <-arrived:
+			l.unpop(it)
+		}
+	}
+}
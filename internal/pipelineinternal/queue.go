@@ -0,0 +1,185 @@
+package pipelineinternal
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// OverflowKind selects what a producer does when its output queue is full.
+type OverflowKind int
+
+const (
+	// OverflowBlock waits for space (backpressure).
+	OverflowBlock OverflowKind = iota
+	// OverflowDropNewest discards the item being pushed.
+	OverflowDropNewest
+	// OverflowDropOldest evicts the oldest queued item to make room. On an
+	// unbuffered queue there is nothing to evict and it drops the newest.
+	OverflowDropOldest
+	// OverflowSample keeps one in Every items that arrive while the queue is
+	// full, waiting for space for that one, and discards the rest.
+	OverflowSample
+)
+
+// Overflow is the overflow policy of a queue.
+type Overflow struct {
+	Kind  OverflowKind
+	Every int
+}
+
+type pushResult int
+
+const (
+	pushed pushResult = iota
+	pushDropped
+	pushCancelled
+	// pushFailed means the item was lost to an error already reported.
+	pushFailed
+)
+
+// queue is the channel between two stages together with the overflow policy
+// of its producer. Consumers read ch directly; producers go through push and
+// the producer closes ch when done.
+type queue struct {
+	ch       chan feed
+	overflow Overflow
+	seen     atomic.Uint64
+
+	// Drop accounting: stage is the producer's label and stats its counters
+	// (nil for the source).
+	stage  string
+	stats  *StageStats
+	run    *Stats
+	onDrop func(stage string, item any)
+
+	// spill, if set, takes items that do not fit in ch. mu orders direct
+	// sends against appends so items keep their order.
+	spill    *spillLog
+	mu       sync.Mutex
+	consumer *StageStats
+	fail     func(error)
+
+	// lanes, if set, holds the queued items in priority order and ch is
+	// unbuffered; see prioritize.
+	lanes *lanes
+}
+
+func newQueue(capacity int, overflow Overflow) *queue {
+	if overflow.Kind == OverflowSample && overflow.Every < 1 {
+		overflow.Every = 1
+	}
+	if overflow.Kind == OverflowDropOldest && capacity == 0 {
+		overflow.Kind = OverflowDropNewest
+	}
+	return &queue{ch: make(chan feed, capacity), overflow: overflow}
+}
+
+// push hands f to the consumer according to the overflow policy.
+func (q *queue) push(ctx context.Context, f feed) pushResult {
+	if q.spill != nil {
+		return q.pushSpill(ctx, f)
+	}
+	if q.lanes != nil {
+		return q.pushLane(ctx, f)
+	}
+	if q.overflow.Kind != OverflowBlock {
+		select {
+		case <-ctx.Done():
+			return pushCancelled
+		case q.ch <- f:
+			return pushed
+		default:
+		}
+
+		switch q.overflow.Kind {
+		case OverflowDropNewest:
+			q.drop(f)
+			return pushDropped
+		case OverflowDropOldest:
+			for {
+				select {
+				case old := <-q.ch:
+					q.drop(old)
+				default:
+				}
+				select {
+				case <-ctx.Done():
+					return pushCancelled
+				case q.ch <- f:
+					return pushed
+				default:
+				}
+			}
+		case OverflowSample:
+			if (q.seen.Add(1)-1)%uint64(q.overflow.Every) != 0 {
+				q.drop(f)
+				return pushDropped
+			}
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		return pushCancelled
+	case q.ch <- f:
+		return pushed
+	}
+}
+
+func (q *queue) drop(f feed) {
+	f.org.fail(ErrDropped)
+	q.stats.dropped()
+	q.run.dropped()
+	if q.onDrop != nil {
+		q.onDrop(q.stage, f.Data)
+	}
+}
+
+// pushSpill sends f directly while nothing is on disk and the channel has
+// room, and appends it to the spill log otherwise.
+func (q *queue) pushSpill(ctx context.Context, f feed) pushResult {
+	if ctx.Err() != nil {
+		return pushCancelled
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.spill.pending() == 0 {
+		select {
+		case q.ch <- f:
+			return pushed
+		default:
+		}
+	}
+	if err := q.spill.append(ctx, f); err != nil {
+		if ctx.Err() != nil || err == errSpillAbandoned {
+			return pushCancelled
+		}
+		q.fail(err)
+		return pushFailed
+	}
+	q.consumer.spilled()
+	return pushed
+}
+
+// close is called by the producer once it has pushed its last item.
+func (q *queue) close() {
+	if q.spill != nil {
+		q.spill.finish()
+		return
+	}
+	if q.lanes != nil {
+		q.lanes.close()
+		return
+	}
+	close(q.ch)
+}
+
+// depth reports the number of queued items and the queue capacity. Items on
+// disk are not included.
+func (q *queue) depth() (n int, capacity int) {
+	if q.lanes != nil {
+		return q.lanes.queued(), q.lanes.capacity
+	}
+	return len(q.ch), cap(q.ch)
+}
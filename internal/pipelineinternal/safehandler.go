@@ -3,6 +3,7 @@ package pipelineinternal
 import (
 	"context"
 	"fmt"
+	"time"
 )
 
 func safeSingle(name string, h SingleHandler, policy *errorPolicy) SingleHandler {
@@ -39,6 +40,76 @@ func safeBatch(name string, h BatchHandler, policy *errorPolicy) BatchHandler {
 	}
 }
 
+// safeTimers wraps the Fire hook of t like safeSingle wraps a handler.
+func safeTimers(name string, t *KeyedTimers, policy *errorPolicy) *KeyedTimers {
+	if t == nil {
+		return nil
+	}
+	fire := t.Fire
+	safe := *t
+	safe.Fire = func(ctx context.Context, partition int, now time.Time) (outs []Tracked, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("pipeline: panic in timer%s: %v", formatStage(name), r)
+				policy.set(err)
+			}
+		}()
+
+		outs, err = fire(ctx, partition, now)
+		if err != nil {
+			policy.set(err)
+		}
+		return outs, err
+	}
+	return &safe
+}
+
+// safeItemFunc turns a panic of fn, a user function applied to items such
+// as a key function, into an error that fails the run. what names fn.
+func safeItemFunc[T any](what string, fn func(any) T, policy *errorPolicy) func(any) (T, error) {
+	return func(input any) (out T, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("pipeline: panic in %s: %v", what, r)
+				policy.set(err)
+			}
+		}()
+		return fn(input), nil
+	}
+}
+
+// safeSink turns a panic of sink into an error; the sink consumer records
+// errors itself.
+func safeSink(sink Sink) Sink {
+	return func(ctx context.Context, input any) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("pipeline: panic in sink: %v", r)
+			}
+		}()
+		return sink(ctx, input)
+	}
+}
+
+// safeTx wraps every call of tx like safeSink.
+func safeTx(tx TxSink) *TxSink {
+	call := func(name string, fn func(context.Context) error) func(context.Context) error {
+		return func(ctx context.Context) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("pipeline: panic in sink %s: %v", name, r)
+				}
+			}()
+			return fn(ctx)
+		}
+	}
+	tx.Begin = call("begin", tx.Begin)
+	tx.Write = safeSink(tx.Write)
+	tx.Commit = call("commit", tx.Commit)
+	tx.Abort = call("abort", tx.Abort)
+	return &tx
+}
+
 func formatStage(name string) string {
 	if name == "" {
 		return ""
@@ -0,0 +1,90 @@
+package pipelineinternal
+
+import (
+	"context"
+	"errors"
+)
+
+// Step is a handler call waiting for a Scheduler.
+type Step struct {
+	// Stage is the position of the stage; the sink comes after the last one.
+	Stage int
+	Name  string
+	// Value is the item, or the batch of a batch stage.
+	Value any
+	// Seq tells apart items whose values are equal: the admission order of a
+	// source item, or the emission order of a batch or timer output. A batch
+	// has the Seq of its first item.
+	Seq uint64
+}
+
+// Scheduler decides when handler and sink calls run. Simulations install one
+// to run the calls of a run in an order they choose. Timers of keyed stages
+// are not scheduled; they follow the clock.
+type Scheduler interface {
+	// Wait blocks until step may run. It returns an error only when ctx is
+	// done.
+	Wait(ctx context.Context, step Step) error
+}
+
+// errUnscheduled is returned by a scheduled call whose context ended before
+// the scheduler let it run. Workers count its items as abandoned.
+var errUnscheduled = errors.New("pipeline: call cancelled before it was scheduled")
+
+func scheduledSingle(s Scheduler, stage int, name string, h SingleHandler) SingleHandler {
+	if s == nil {
+		return h
+	}
+	return func(ctx context.Context, input any) (any, error) {
+		if s.Wait(ctx, Step{Stage: stage, Name: name, Value: input, Seq: seqOf(ctx)}) != nil {
+			return nil, errUnscheduled
+		}
+		return h(ctx, input)
+	}
+}
+
+func scheduledBatch(s Scheduler, stage int, name string, h BatchHandler) BatchHandler {
+	if s == nil {
+		return h
+	}
+	return func(ctx context.Context, inputs []any) ([]any, error) {
+		if s.Wait(ctx, Step{Stage: stage, Name: name, Value: inputs, Seq: seqOf(ctx)}) != nil {
+			return nil, errUnscheduled
+		}
+		return h(ctx, inputs)
+	}
+}
+
+func scheduledSink(s Scheduler, stage int, sink Sink) Sink {
+	if s == nil {
+		return sink
+	}
+	return func(ctx context.Context, input any) error {
+		if s.Wait(ctx, Step{Stage: stage, Name: "sink", Value: input, Seq: seqOf(ctx)}) != nil {
+			return errUnscheduled
+		}
+		return sink(ctx, input)
+	}
+}
+
+type seqCtx struct{}
+
+// withSeq attaches the Seq of an item to the context of its call when a
+// scheduler is to see it.
+func (e stageEnv) withSeq(ctx context.Context, seq uint64) context.Context {
+	if !e.sequenced {
+		return ctx
+	}
+	return context.WithValue(ctx, seqCtx{}, seq)
+}
+
+func seqOf(ctx context.Context) uint64 {
+	seq, _ := ctx.Value(seqCtx{}).(uint64)
+	return seq
+}
+
+// timerSeq is the Seq of the n-th output of the timers of a partition
+// worker; it stays clear of the Seqs of source items.
+func timerSeq(worker int, n uint64) uint64 {
+	return uint64(worker+1)<<48 | n
+}
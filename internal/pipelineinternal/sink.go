@@ -2,18 +2,35 @@ package pipelineinternal
 
 import "context"
 
-func sinkConsume(ctx context.Context, in <-chan feed, sink Sink, policy *errorPolicy, logger Logger) {
+func sinkConsume(ctx context.Context, in <-chan feed, sink Sink, policy *errorPolicy, env stageEnv) {
 	for f := range in {
 		// Always drain to avoid blocking upstream, even after failure.
 		if ctx.Err() != nil {
+			env.abandoned(1)
+			f.org.fail(ErrAbandoned)
 			continue
 		}
 		if policy.get() != nil {
+			f.org.fail(ErrAbandoned)
 			continue
 		}
-		if err := sink(ctx, f.Data); err != nil {
+		env.stats.received()
+		start := env.trace.dequeued(f)
+		err := sink(env.withSeq(withKey(ctx, f.key), f.seq), f.Data)
+		env.trace.handled(f, 0, start, err)
+		if err == errUnscheduled {
+			env.abandoned(1)
+			f.org.fail(ErrAbandoned)
+			continue
+		}
+		if err != nil {
+			f.org.fail(err)
+			env.stats.failed()
 			policy.set(err)
-			logger.Error("pipeline sink error", "error", err)
+			env.logger.Error("pipeline sink error", "error", err)
+			continue
 		}
+		f.org.release()
+		env.stats.emitted(1)
 	}
 }
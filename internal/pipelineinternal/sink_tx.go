@@ -0,0 +1,135 @@
+package pipelineinternal
+
+import (
+	"context"
+	"time"
+)
+
+// TxSink is a sink that writes items inside transactions, committed after
+// Size items, after MaxWait and at the end of the input.
+type TxSink struct {
+	Begin  func(ctx context.Context) error
+	Write  Sink
+	Commit func(ctx context.Context) error
+	Abort  func(ctx context.Context) error
+
+	Size    int
+	MaxWait time.Duration
+}
+
+// txConsume drives a TxSink. A failed Begin, Write or Commit aborts the open
+// transaction, fails every item in it and stops the run like a sink error;
+// cancellation aborts it as well. A flush signal commits early.
+func txConsume(ctx context.Context, in <-chan feed, tx *TxSink, policy *errorPolicy, env stageEnv) {
+	var (
+		open    bool
+		pending []feed
+		timer   = &batchTimer{clock: env.clock, wait: tx.MaxWait}
+	)
+	defer timer.stop()
+
+	// abort rolls back the open transaction; its items fail with cause.
+	abort := func(cause error) {
+		if open {
+			if err := tx.Abort(context.WithoutCancel(ctx)); err != nil {
+				env.logger.Error("pipeline sink abort error", "error", err)
+			}
+			open = false
+		}
+		for _, f := range pending {
+			f.org.fail(cause)
+		}
+		pending = pending[:0]
+		timer.stop()
+	}
+	fail := func(err error) {
+		env.stats.failed()
+		policy.set(err)
+		env.logger.Error("pipeline sink error", "error", err)
+		abort(err)
+	}
+	commit := func() {
+		timer.stop()
+		if !open {
+			return
+		}
+		if err := tx.Commit(ctx); err != nil {
+			open = false // a failed commit is not aborted
+			fail(err)
+			return
+		}
+		open = false
+		for _, f := range pending {
+			f.org.release()
+		}
+		env.stats.emitted(len(pending))
+		pending = pending[:0]
+	}
+
+	for {
+		select {
+		case <-timer.C():
+			timer.fired()
+			if ctx.Err() == nil && policy.get() == nil {
+				commit()
+			}
+		case <-env.flush.wait():
+			if ctx.Err() == nil && policy.get() == nil {
+				commit()
+			}
+		case f, ok := <-in:
+			if !ok {
+				switch {
+				case ctx.Err() != nil:
+					env.abandoned(len(pending))
+					abort(ErrAbandoned)
+				case policy.get() != nil:
+					abort(ErrAbandoned)
+				default:
+					commit()
+				}
+				return
+			}
+			// Always drain to avoid blocking upstream, even after failure.
+			if ctx.Err() != nil {
+				env.abandoned(1 + len(pending))
+				abort(ErrAbandoned)
+				f.org.fail(ErrAbandoned)
+				continue
+			}
+			if policy.get() != nil {
+				abort(ErrAbandoned)
+				f.org.fail(ErrAbandoned)
+				continue
+			}
+			env.stats.received()
+			start := env.trace.dequeued(f)
+			if !open {
+				if err := tx.Begin(ctx); err != nil {
+					f.org.fail(err)
+					fail(err)
+					continue
+				}
+				open = true
+				timer.reset()
+			}
+			err := tx.Write(env.withSeq(withKey(ctx, f.key), f.seq), f.Data)
+			env.trace.handled(f, 0, start, err)
+			if err == errUnscheduled {
+				env.abandoned(1 + len(pending))
+				abort(ErrAbandoned)
+				f.org.fail(ErrAbandoned)
+				continue
+			}
+			if err != nil {
+				f.org.fail(err)
+				fail(err)
+				continue
+			}
+			pending = append(pending, f)
+			if tx.Size > 0 && len(pending) >= tx.Size {
+				commit()
+			}
+		}
+	}
+}
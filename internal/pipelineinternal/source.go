@@ -2,20 +2,86 @@ package pipelineinternal
 
 import "context"
 
-func sourcePump(rootCtx context.Context, sourceCtx context.Context, src <-chan any, out chan<- feed, pipelineName string) {
+// Tracked is a source item that wants to learn its outcome. Admit is called
+// on admission and returns the function told the outcome exactly once.
+type Tracked struct {
+	Value any
+	Admit func() (done func(error))
+	// Key, if set, is the item's idempotency key.
+	Key string
+}
+
+// sourcePump admits items from src until sourceCtx is done or the gate is
+// paused. An item already taken is only abandoned if rootCtx is cancelled.
+func sourcePump(rootCtx context.Context, sourceCtx context.Context, src <-chan any, out *queue, pipelineName string, tr *traceRun, stats *Stats, intake *gate, budget *budget, prioOf func(any) (int, error), keyOf func(any) (string, error)) {
+	var seq uint64
 	for {
+		paused, changed := intake.state()
+		if paused {
+			select {
+			case <-sourceCtx.Done():
+				return
+			case <-changed:
+			}
+			continue
+		}
+
 		select {
 		case <-sourceCtx.Done():
 			return
+		case <-changed:
+			// Re-check the gate before reading another item.
 		case v, ok := <-src:
 			if !ok {
 				return
 			}
-			f := feed{RootCtx: rootCtx, PipelineName: pipelineName, Data: v}
-			select {
-			case <-sourceCtx.Done():
+			var (
+				done func(error)
+				key  string
+			)
+			if t, ok := v.(Tracked); ok {
+				v, key = t.Value, t.Key
+				if t.Admit != nil {
+					done = t.Admit()
+				}
+			}
+			org, err := budget.admit(rootCtx, v, done)
+			if err != nil {
+				if rootCtx.Err() != nil {
+					stats.abandoned(1)
+					err = ErrAbandoned
+				}
+				if done != nil {
+					done(err)
+				}
+				return
+			}
+			seq++
+			f := feed{RootCtx: rootCtx, PipelineName: pipelineName, Data: v, org: org, seq: seq}
+			if prioOf != nil {
+				if f.prio, err = prioOf(v); err != nil {
+					org.fail(err)
+					return
+				}
+			}
+			if keyOf != nil {
+				if key, err = keyOf(v); err != nil {
+					org.fail(err)
+					return
+				}
+			}
+			f.key = key
+			tr.admit(&f)
+			switch out.push(rootCtx, f) {
+			case pushed:
+				stats.admitted()
+			case pushCancelled:
+				stats.abandoned(1)
+				org.fail(ErrAbandoned)
+				return
+			case pushFailed:
+				org.fail(ErrAbandoned)
 				return
-			case out <- f:
 			}
 		}
 	}
@@ -0,0 +1,325 @@
+package pipelineinternal
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// SpillConfig backs a queue with an on-disk segment log. Items that do not fit
+// in the channel are encoded and appended to the log, and a forwarder replays
+// them into the channel in order as the consumer catches up.
+type SpillConfig struct {
+	// Dir is the parent directory; each run spills into a fresh subdirectory.
+	Dir string
+	// SegmentBytes is the size after which a new segment file is started.
+	SegmentBytes int64
+	// MaxBytes caps the bytes on disk; producers block at the cap. Zero means
+	// no cap.
+	MaxBytes int64
+
+	Encode func(any) ([]byte, error)
+	Decode func([]byte) (any, error)
+}
+
+const defaultSegmentBytes = 16 << 20
+
+// errSpillAbandoned is returned by append once the forwarder has given up.
+var errSpillAbandoned = errors.New("spill log abandoned")
+
+// spillRecord is the in-memory part of a spilled item: everything but the
+// payload, plus where the payload ended on disk.
+type spillRecord struct {
+	meta feed
+	size int64
+}
+
+type spillSegment struct {
+	path string
+	// size is the bytes written and unread the bytes not yet delivered.
+	size   int64
+	unread int64
+}
+
+// spillLog is an append-only log of segment files read from the front. Fully
+// read segments are deleted.
+type spillLog struct {
+	cfg SpillConfig
+	dir string
+
+	mu       sync.Mutex
+	records  []spillRecord
+	segments []*spillSegment
+	w        *os.File
+	onDisk   int64
+	closed   bool
+	dead     bool
+	changed  *broadcast
+
+	// The forwarder's read handle on the oldest segment. Reads are unbuffered
+	// because the segment may still be growing.
+	rf  *os.File
+	buf []byte
+}
+
+func newSpillLog(cfg SpillConfig, pipelineName string) (*spillLog, error) {
+	if cfg.SegmentBytes <= 0 {
+		cfg.SegmentBytes = defaultSegmentBytes
+	}
+	if cfg.Encode == nil || cfg.Decode == nil {
+		return nil, ErrInvalidConfig
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp(cfg.Dir, pipelineName+"-spill-")
+	if err != nil {
+		return nil, err
+	}
+	return &spillLog{cfg: cfg, dir: dir, changed: newBroadcast()}, nil
+}
+
+// append encodes f and writes it to the log, blocking while the log is at its
+// size cap.
+func (l *spillLog) append(ctx context.Context, f feed) error {
+	payload, err := l.cfg.Encode(f.Data)
+	if err != nil {
+		return err
+	}
+	size := int64(4 + len(payload))
+
+	l.mu.Lock()
+	for l.cfg.MaxBytes > 0 && l.onDisk > 0 && l.onDisk+size > l.cfg.MaxBytes {
+		wake := l.changed.wait()
+		l.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wake:
+		}
+		l.mu.Lock()
+	}
+	defer l.mu.Unlock()
+	if l.dead {
+		return errSpillAbandoned
+	}
+
+	if l.w == nil || l.segments[len(l.segments)-1].size >= l.cfg.SegmentBytes {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(payload)))
+	if _, err := l.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	if _, err := l.w.Write(payload); err != nil {
+		return err
+	}
+
+	f.Data = nil
+	l.records = append(l.records, spillRecord{meta: f, size: size})
+	seg := l.segments[len(l.segments)-1]
+	seg.size += size
+	seg.unread += size
+	l.onDisk += size
+	l.changed.trigger()
+	return nil
+}
+
+func (l *spillLog) rotate() error {
+	if l.w != nil {
+		if err := l.w.Close(); err != nil {
+			return err
+		}
+	}
+	path := filepath.Join(l.dir, fmt.Sprintf("%08d.seg", len(l.segments)))
+	w, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	l.w = w
+	l.segments = append(l.segments, &spillSegment{path: path})
+	return nil
+}
+
+// pending reports the number of items on disk.
+func (l *spillLog) pending() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.records)
+}
+
+// next blocks until an item is available and returns it without removing it.
+// It reports false once the log is closed and empty.
+func (l *spillLog) next(ctx context.Context) (feed, bool, error) {
+	for {
+		l.mu.Lock()
+		if len(l.records) > 0 {
+			rec := l.records[0]
+			l.mu.Unlock()
+			f, err := l.read(rec)
+			return f, err == nil, err
+		}
+		if l.closed {
+			l.mu.Unlock()
+			return feed{}, false, nil
+		}
+		wake := l.changed.wait()
+		l.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return feed{}, false, ctx.Err()
+		case <-wake:
+		}
+	}
+}
+
+// read decodes the payload of rec, the oldest record. Only the forwarder reads.
+func (l *spillLog) read(rec spillRecord) (feed, error) {
+	// Retire fully delivered segments; nothing more is written to them once
+	// a later segment exists.
+	l.mu.Lock()
+	for len(l.segments) > 1 && l.segments[0].unread == 0 {
+		if l.rf != nil {
+			err := l.rf.Close()
+			l.rf = nil
+			if err != nil {
+				l.mu.Unlock()
+				return feed{}, err
+			}
+		}
+		if err := os.Remove(l.segments[0].path); err != nil {
+			l.mu.Unlock()
+			return feed{}, err
+		}
+		l.segments = l.segments[1:]
+	}
+	path := l.segments[0].path
+	l.mu.Unlock()
+
+	if l.rf == nil {
+		rf, err := os.Open(path)
+		if err != nil {
+			return feed{}, err
+		}
+		l.rf = rf
+	}
+	n := int(rec.size - 4)
+	if cap(l.buf) < int(rec.size) {
+		l.buf = make([]byte, rec.size)
+	}
+	b := l.buf[:rec.size]
+	if _, err := io.ReadFull(l.rf, b); err != nil {
+		return feed{}, err
+	}
+	if int(binary.BigEndian.Uint32(b[:4])) != n {
+		return feed{}, errors.New("corrupt spill record")
+	}
+	v, err := l.cfg.Decode(b[4:])
+	if err != nil {
+		return feed{}, err
+	}
+	f := rec.meta
+	f.Data = v
+	return f, nil
+}
+
+// pop removes the oldest record once it has been delivered.
+func (l *spillLog) pop() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rec := l.records[0]
+	l.records[0] = spillRecord{}
+	l.records = l.records[1:]
+	l.onDisk -= rec.size
+	l.segments[0].unread -= rec.size
+	l.changed.trigger()
+}
+
+// finish marks the producer side done; next reports false once drained.
+func (l *spillLog) finish() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	l.changed.trigger()
+}
+
+// abandon stops the log after cancellation or an error and reports the items
+// left on disk. Files are closed and deleted by remove.
+func (l *spillLog) abandon() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.dead = true
+	n := len(l.records)
+	for _, rec := range l.records {
+		rec.meta.org.fail(ErrAbandoned)
+	}
+	l.records = nil
+	l.changed.trigger()
+	return n
+}
+
+func (l *spillLog) closeFiles() error {
+	var errs []error
+	if l.w != nil {
+		errs = append(errs, l.w.Close())
+		l.w = nil
+	}
+	if l.rf != nil {
+		errs = append(errs, l.rf.Close())
+		l.rf = nil
+	}
+	return errors.Join(errs...)
+}
+
+// remove deletes the log directory and everything in it.
+func (l *spillLog) remove() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return errors.Join(l.closeFiles(), os.RemoveAll(l.dir))
+}
+
+// forward replays spilled items into q.ch in order, closing q.ch once the
+// producer has finished, and removes the log from disk however it exits.
+func (q *queue) forward(ctx context.Context) {
+	defer close(q.ch)
+	for {
+		f, ok, err := q.spill.next(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				q.fail(err)
+			}
+			q.discard()
+			return
+		}
+		if !ok {
+			if err := q.spill.remove(); err != nil {
+				q.fail(err)
+			}
+			return
+		}
+		select {
+		case <-ctx.Done():
+			q.discard()
+			return
+		case q.ch <- f:
+			q.spill.pop()
+		}
+	}
+}
+
+// discard abandons the items of the spill log and removes it.
+func (q *queue) discard() {
+	q.run.abandoned(q.spill.abandon())
+	if err := q.spill.remove(); err != nil {
+		q.fail(err)
+	}
+}
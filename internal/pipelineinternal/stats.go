@@ -0,0 +1,190 @@
+package pipelineinternal
+
+import "sync/atomic"
+
+// Stats holds live counters for a single run. Counters are updated by the
+// pipeline goroutines and may be read concurrently at any time.
+type Stats struct {
+	Admitted atomic.Int64
+	// Abandoned counts items discarded at any point because the run was
+	// cancelled before they reached the sink.
+	Abandoned atomic.Int64
+	// Dropped counts items discarded by overflow policies of full queues.
+	Dropped atomic.Int64
+	Stages  []*StageStats
+	Sink    *StageStats
+
+	// sunkAtStop is the sink's Out counter when a graceful stop began.
+	sunkAtStop atomic.Int64
+	budget     atomic.Pointer[budget]
+}
+
+// StageStats counts the items seen by one stage (or the sink).
+type StageStats struct {
+	In     atomic.Int64
+	Out    atomic.Int64
+	Errors atomic.Int64
+	// Dropped counts emitted items discarded because the next queue was full.
+	Dropped atomic.Int64
+	// Spilled counts items that reached this stage through its input queue's
+	// spill log.
+	Spilled atomic.Int64
+
+	queue atomic.Pointer[queue]
+	pool  atomic.Pointer[workerPool]
+	limit atomic.Pointer[limiter]
+	spill atomic.Pointer[spillLog]
+}
+
+// Drained reports the items written by the sink since a graceful stop began
+// and the items abandoned during the run.
+func (s *Stats) Drained() (completed int64, abandoned int64) {
+	return s.Sink.Out.Load() - s.sunkAtStop.Load(), s.Abandoned.Load()
+}
+
+// InFlight reports the source items, and their bytes, that are held by an
+// in-flight budget. Both are zero without one.
+func (s *Stats) InFlight() (items int64, bytes int64) {
+	return s.budget.Load().inFlight()
+}
+
+// NewStats allocates counters for a pipeline with the given number of stages.
+func NewStats(stages int) *Stats {
+	s := &Stats{Stages: make([]*StageStats, stages), Sink: &StageStats{}}
+	for i := range s.Stages {
+		s.Stages[i] = &StageStats{}
+	}
+	return s
+}
+
+// Queued reports the number of items waiting in the stage's input queue and
+// the queue capacity.
+func (s *StageStats) Queued() (n int, capacity int) {
+	if s == nil {
+		return 0, 0
+	}
+	q := s.queue.Load()
+	if q == nil {
+		return 0, 0
+	}
+	return q.depth()
+}
+
+// Workers reports the current worker count of a single-item stage and how many
+// of those workers are running the handler. Both are zero for other stages.
+func (s *StageStats) Workers() (workers int, busy int) {
+	if s == nil {
+		return 0, 0
+	}
+	p := s.pool.Load()
+	if p == nil {
+		return 0, 0
+	}
+	return p.size(), int(p.busy.Load())
+}
+
+// OnDisk reports the number of items waiting in the stage's input spill log.
+func (s *StageStats) OnDisk() int {
+	if s == nil {
+		return 0
+	}
+	if l := s.spill.Load(); l != nil {
+		return l.pending()
+	}
+	return 0
+}
+
+// Limit reports the current adaptive limit of a stage and the handler calls
+// it admitted that are still running. Both are zero without a limiter.
+func (s *StageStats) Limit() (limit int, inflight int) {
+	if s == nil {
+		return 0, 0
+	}
+	l := s.limit.Load()
+	if l == nil {
+		return 0, 0
+	}
+	return int(l.current.Load()), int(l.active.Load())
+}
+
+func (s *Stats) stage(i int) *StageStats {
+	if s == nil {
+		return nil
+	}
+	if i >= len(s.Stages) {
+		return s.Sink
+	}
+	return s.Stages[i]
+}
+
+func (s *Stats) admitted() {
+	if s != nil {
+		s.Admitted.Add(1)
+	}
+}
+
+func (s *Stats) abandoned(n int) {
+	if s != nil {
+		s.Abandoned.Add(int64(n))
+	}
+}
+
+func (s *Stats) dropped() {
+	if s != nil {
+		s.Dropped.Add(1)
+	}
+}
+
+func (s *StageStats) dropped() {
+	if s != nil {
+		s.Dropped.Add(1)
+	}
+}
+
+func (s *StageStats) attach(in *queue) {
+	if s != nil {
+		s.queue.Store(in)
+	}
+}
+
+func (s *StageStats) attachPool(p *workerPool) {
+	if s != nil {
+		s.pool.Store(p)
+	}
+}
+
+func (s *StageStats) attachLimiter(l *limiter) {
+	if s != nil {
+		s.limit.Store(l)
+	}
+}
+
+func (s *StageStats) attachSpill(l *spillLog) {
+	if s != nil {
+		s.spill.Store(l)
+	}
+}
+
+func (s *StageStats) spilled() {
+	if s != nil {
+		s.Spilled.Add(1)
+	}
+}
+
+func (s *StageStats) received() {
+	if s != nil {
+		s.In.Add(1)
+	}
+}
+
+func (s *StageStats) emitted(n int) {
+	if s != nil {
+		s.Out.Add(int64(n))
+	}
+}
+
+func (s *StageStats) failed() {
+	if s != nil {
+		s.Errors.Add(1)
+	}
+}
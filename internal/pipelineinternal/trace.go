@@ -0,0 +1,271 @@
+package pipelineinternal
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Tracer accumulates sampled per-item spans in the Chrome Trace Event JSON
+// format: one process per run and one thread per worker. It keeps the latest
+// limit spans; process and thread names are always kept.
+type Tracer struct {
+	epoch time.Time
+	runs  atomic.Int64
+	limit int
+
+	mu     sync.Mutex
+	meta   []traceEvent
+	events []traceEvent
+	// next is the slot of the oldest event once events is full.
+	next int
+}
+
+type traceEvent struct {
+	Name string         `json:"name"`
+	Cat  string         `json:"cat,omitempty"`
+	Ph   string         `json:"ph"`
+	Ts   int64          `json:"ts"`
+	Dur  *int64         `json:"dur,omitempty"`
+	Pid  int64          `json:"pid"`
+	Tid  int            `json:"tid"`
+	ID   string         `json:"id,omitempty"`
+	S    string         `json:"s,omitempty"`
+	Args map[string]any `json:"args,omitempty"`
+}
+
+// NewTracer creates a tracer that keeps the latest limit events (at least one).
+func NewTracer(limit int) *Tracer {
+	return &Tracer{epoch: time.Now(), limit: max(1, limit)}
+}
+
+// WriteJSON writes the retained events as a Chrome Trace Event JSON object.
+func (t *Tracer) WriteJSON(w io.Writer) (int64, error) {
+	t.mu.Lock()
+	events := make([]traceEvent, 0, len(t.meta)+len(t.events))
+	events = append(events, t.meta...)
+	events = append(events, t.events[t.next:]...)
+	events = append(events, t.events[:t.next]...)
+	t.mu.Unlock()
+
+	b, err := json.Marshal(struct {
+		TraceEvents     []traceEvent `json:"traceEvents"`
+		DisplayTimeUnit string       `json:"displayTimeUnit"`
+	}{TraceEvents: events, DisplayTimeUnit: "ms"})
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+func (t *Tracer) ts(at time.Time) int64 {
+	return at.Sub(t.epoch).Microseconds()
+}
+
+func (t *Tracer) add(ev traceEvent) {
+	t.mu.Lock()
+	t.addLocked(ev)
+	t.mu.Unlock()
+}
+
+// addLocked records ev, overwriting the oldest event once the limit is
+// reached.
+func (t *Tracer) addLocked(ev traceEvent) {
+	switch {
+	case ev.Ph == "M":
+		t.meta = append(t.meta, ev)
+	case len(t.events) < t.limit:
+		t.events = append(t.events, ev)
+	default:
+		t.events[t.next] = ev
+		t.next = (t.next + 1) % t.limit
+	}
+}
+
+// Track ids: the source is 0, worker w of stage i is (i+1)*tidStride+w and the
+// sink follows the last stage.
+const tidStride = 1000
+
+// traceRun scopes events of a single run. A nil *traceRun records nothing.
+type traceRun struct {
+	t    *Tracer
+	pid  int64
+	rate float64
+	ids  atomic.Uint64
+}
+
+func (t *Tracer) begin(pipelineName string, rate float64, stages []Stage) *traceRun {
+	if t == nil || rate <= 0 {
+		return nil
+	}
+	if rate > 1 {
+		rate = 1
+	}
+	r := &traceRun{t: t, pid: t.runs.Add(1), rate: rate}
+
+	r.meta("process_name", 0, pipelineName)
+	r.meta("thread_name", 0, "source")
+	r.meta("thread_name", stageTid(len(stages), 0), "sink")
+	return r
+}
+
+func (r *traceRun) meta(name string, tid int, value string) {
+	r.t.add(traceEvent{Name: name, Ph: "M", Pid: r.pid, Tid: tid, Args: map[string]any{"name": value}})
+}
+
+// admit assigns an id to a new item and decides whether it is sampled. Sampling
+// is deterministic: exactly rate*n of the first n items are traced, evenly spaced.
+func (r *traceRun) admit(f *feed) {
+	if r == nil {
+		return
+	}
+	id := r.ids.Add(1)
+	f.id = id
+	if uint64(float64(id)*r.rate) > uint64(float64(id-1)*r.rate) {
+		f.traced = true
+		f.enq = time.Now()
+	}
+}
+
+// stage returns the recorder of a stage; numbered labels its workers with
+// their index.
+func (r *traceRun) stage(index int, name string, numbered bool) *stageTrace {
+	if r == nil {
+		return nil
+	}
+	return &stageTrace{run: r, index: index, label: stageLabel(index, name), numbered: numbered, named: map[int]bool{}}
+}
+
+func (r *traceRun) sink(index int) *stageTrace {
+	if r == nil {
+		return nil
+	}
+	return &stageTrace{run: r, index: index, label: "sink"}
+}
+
+// stageTrace records the events of one stage. A nil *stageTrace records nothing.
+type stageTrace struct {
+	run      *traceRun
+	index    int
+	label    string
+	numbered bool
+
+	mu    sync.Mutex
+	named map[int]bool
+}
+
+// started names the track of a worker when it first starts, including
+// workers added while the stage runs.
+func (s *stageTrace) started(worker int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.named[worker] {
+		return
+	}
+	s.named[worker] = true
+	label := s.label
+	if s.numbered || worker > 0 {
+		label += " #" + strconv.Itoa(worker)
+	}
+	s.run.meta("thread_name", stageTid(s.index, worker), label)
+}
+
+// dequeued closes the queue-wait span of an item picked up by this stage.
+func (s *stageTrace) dequeued(f feed) time.Time {
+	if s == nil || !f.traced {
+		return time.Time{}
+	}
+	now := time.Now()
+	s.async("queue "+s.label, "queue", f.id, f.enq, now)
+	return now
+}
+
+// handled records a handler span for a single item.
+func (s *stageTrace) handled(f feed, worker int, start time.Time, err error) {
+	if s == nil || !f.traced {
+		return
+	}
+	args := map[string]any{"item": f.id}
+	if err != nil {
+		args["error"] = err.Error()
+	}
+	s.span(s.label, "handler", worker, start, time.Now(), args)
+}
+
+// batched records the wait between an item joining a batch and the flush.
+func (s *stageTrace) batched(f feed, joined, flushed time.Time) {
+	if s == nil || !f.traced {
+		return
+	}
+	s.async("batch "+s.label, "batch", f.id, joined, flushed)
+}
+
+// flushed records a batch flush: an instant marker and the handler span.
+func (s *stageTrace) flushed(size int, reason string, start time.Time, err error) {
+	if s == nil {
+		return
+	}
+	args := map[string]any{"size": size, "reason": reason}
+	s.run.t.add(traceEvent{
+		Name: "flush", Cat: "batch", Ph: "i", S: "t",
+		Ts: s.run.t.ts(start), Pid: s.run.pid, Tid: stageTid(s.index, 0), Args: args,
+	})
+	if err != nil {
+		args = map[string]any{"size": size, "error": err.Error()}
+	}
+	s.span(s.label, "handler", 0, start, time.Now(), args)
+}
+
+// derived marks f as a new item produced from traced inputs (a batch output).
+func (s *stageTrace) derived(f *feed) {
+	if s == nil {
+		return
+	}
+	f.id = s.run.ids.Add(1)
+	f.traced = true
+	f.enq = time.Now()
+}
+
+// emitted stamps an outgoing item so the next stage can measure its queue wait.
+func (s *stageTrace) emitted(f *feed) {
+	if s == nil || !f.traced {
+		return
+	}
+	f.enq = time.Now()
+}
+
+func (s *stageTrace) span(name, cat string, worker int, start, end time.Time, args map[string]any) {
+	t := s.run.t
+	dur := end.Sub(start).Microseconds()
+	t.add(traceEvent{
+		Name: name, Cat: cat, Ph: "X",
+		Ts: t.ts(start), Dur: &dur, Pid: s.run.pid, Tid: stageTid(s.index, worker), Args: args,
+	})
+}
+
+func (s *stageTrace) async(name, cat string, id uint64, start, end time.Time) {
+	t := s.run.t
+	sid := strconv.FormatUint(id, 10)
+	t.mu.Lock()
+	t.addLocked(traceEvent{Name: name, Cat: cat, Ph: "b", Ts: t.ts(start), Pid: s.run.pid, Tid: stageTid(s.index, 0), ID: sid})
+	t.addLocked(traceEvent{Name: name, Cat: cat, Ph: "e", Ts: t.ts(end), Pid: s.run.pid, Tid: stageTid(s.index, 0), ID: sid})
+	t.mu.Unlock()
+}
+
+func stageTid(index, worker int) int {
+	return (index+1)*tidStride + worker
+}
+
+func stageLabel(index int, name string) string {
+	if name != "" {
+		return name
+	}
+	return "stage " + strconv.Itoa(index)
+}
@@ -3,6 +3,7 @@ package pipelineinternal
 import (
 	"context"
 	"errors"
+	"fmt"
 	"sync"
 	"time"
 )
@@ -13,6 +14,7 @@ const (
 	StateSucceeded RunState = iota
 	StateCancelled
 	StateFailed
+	StateStopped
 )
 
 type Logger interface {
@@ -32,6 +34,39 @@ func (nopLogger) Error(string, ...any) {}
 type Config struct {
 	DefaultBuffer int
 	Logger        Logger
+	Tracer        *Tracer
+	TraceRate     float64
+	Stats         *Stats
+	// OnDone, if set, is called once the run has finished, before Wait returns.
+	OnDone func()
+	// SuspendBatchTimers stops MaxWait timers of batch stages while paused.
+	SuspendBatchTimers bool
+	// Overflow is the policy of the queue between the source and the first stage.
+	Overflow Overflow
+	// OnDrop, if set, receives every item discarded by an overflow policy
+	// together with the label of the stage that produced it.
+	OnDrop func(stage string, item any)
+	// MaxInFlight and MaxInFlightBytes bound the source items admitted but not
+	// yet out of the pipeline; Sizer measures an item for the byte bound.
+	// Zero means unbounded.
+	MaxInFlight      int64
+	MaxInFlightBytes int64
+	Sizer            func(any) int64
+	// Priority, if set, replaces the FIFO order of every queue except spilled
+	// ones with priority lanes.
+	Priority *PriorityConfig
+	// Tx, if set, replaces the sink with a transactional one.
+	Tx *TxSink
+	// Key, if set, derives the idempotency key of a source item, replacing
+	// the key of a Tracked item.
+	Key func(any) string
+	// Clock is the run's source of time; nil means RealClock.
+	Clock Clock
+	// Scheduler, if set, gates every handler and sink call.
+	Scheduler Scheduler
+	// SinkLifecycle, if set, opens a resource for the sink, or the
+	// transactional sink.
+	SinkLifecycle *Lifecycle
 }
 
 type StageKind int
@@ -39,12 +74,30 @@ type StageKind int
 const (
 	StageSingle StageKind = iota
 	StageBatch
+	// StageKeyed is a single-item stage whose items are routed to workers by
+	// Partition, so each partition is handled by one worker, in order.
+	StageKeyed
 )
 
 type StageConfig struct {
 	Buffer      int
 	Concurrency int
 	Name        string
+	// MinConcurrency and MaxConcurrency bound autoscaling of a single-item
+	// stage. Autoscaling is off when MaxConcurrency is zero.
+	MinConcurrency int
+	MaxConcurrency int
+	// Limiter, if set, adaptively bounds concurrent handler calls of a
+	// single-item stage below its worker count.
+	Limiter *LimiterConfig
+	// Rate, if set, delays each handler call until the bucket grants a token.
+	// Several stages may share one RateLimiter.
+	Rate *RateLimiter
+	// Overflow is the policy of the queue to the next stage.
+	Overflow Overflow
+	// Spill, if set, backs the queue to the next stage with a disk log and
+	// replaces Overflow.
+	Spill *SpillConfig
 }
 
 type BatchPolicy struct {
@@ -62,55 +115,176 @@ type Stage struct {
 	Single      SingleHandler
 	Batch       BatchHandler
 	BatchPolicy BatchPolicy
+	// Partition returns the partition of an item of a keyed stage, in
+	// [0, Config.Concurrency).
+	Partition func(any) int
+	// Timers, if set, are the timers of a keyed stage.
+	Timers *KeyedTimers
+	// Lifecycle, if set, opens a resource for a single-item or batch stage,
+	// or for each of its workers.
+	Lifecycle *Lifecycle
 }
 
 type Source func(ctx context.Context) (<-chan any, error)
 
 type Sink func(ctx context.Context, input any) error
 
+// stageEnv bundles the per-stage collaborators shared by a stage's workers.
+type stageEnv struct {
+	logger Logger
+	trace  *stageTrace
+	stats  *StageStats
+	run    *Stats
+	flush  *broadcast
+	limit  *limiter
+	rate   *RateLimiter
+	clock  Clock
+	open   opener
+	// sequenced attaches item Seqs to calls for the scheduler.
+	sequenced bool
+	// pause is set when batch timers must be suspended while paused.
+	pause *gate
+}
+
+func (e stageEnv) abandoned(n int) {
+	e.run.abandoned(n)
+}
+
 type feed struct {
 	RootCtx      context.Context
 	PipelineName string
 	Data         any
+
+	// org tracks the source items this feed derives from (nil without an
+	// in-flight budget).
+	org *lineage
+	// prio is the priority of the source items this feed derives from.
+	prio int
+	// key is the idempotency key of the feed; empty if it has none.
+	key string
+	// seq tells apart feeds of equal values for a scheduler (see Step.Seq).
+	seq uint64
+
+	// Tracing metadata; only meaningful when traced is set.
+	id     uint64
+	traced bool
+	enq    time.Time
 }
 
 // Run executes the pipeline and blocks until all internal goroutines exit.
 func Run(rootCtx context.Context, pipelineName string, source Source, stages []Stage, sink Sink, cfg Config) (RunState, error) {
+	e, err := Start(rootCtx, pipelineName, source, stages, sink, cfg)
+	if err != nil {
+		return StateFailed, err
+	}
+	return e.Wait()
+}
+
+// Start wires and launches the pipeline without waiting for it to finish. The
+// source is started synchronously so that its error is returned from Start.
+func Start(rootCtx context.Context, pipelineName string, source Source, stages []Stage, sink Sink, cfg Config) (*Execution, error) {
 	if rootCtx == nil {
 		rootCtx = context.Background()
 	}
-	if source == nil || sink == nil {
-		return StateFailed, ErrInvalidConfig
+	if source == nil || (sink == nil && cfg.Tx == nil) {
+		return nil, ErrInvalidConfig
+	}
+	if cfg.Tx != nil {
+		tx := safeTx(*cfg.Tx)
+		tx.Write = scheduledSink(cfg.Scheduler, len(stages), tx.Write)
+		cfg.Tx = tx
+	} else {
+		sink = scheduledSink(cfg.Scheduler, len(stages), safeSink(sink))
+	}
+	for _, st := range stages {
+		if (st.Kind == StageBatch && st.Batch == nil) || (st.Kind != StageBatch && st.Single == nil) || (st.Kind == StageKeyed && st.Partition == nil) {
+			return nil, ErrInvalidConfig
+		}
 	}
 	logger := cfg.Logger
 	if logger == nil {
 		logger = nopLogger{}
 	}
-
-	sourceCtx, cancelSource := context.WithCancelCause(rootCtx)
-	defer cancelSource(nil)
-
+	if cfg.Clock == nil {
+		cfg.Clock = RealClock{}
+	}
+	if cfg.Stats == nil {
+		cfg.Stats = NewStats(len(stages))
+	}
 	policy := &errorPolicy{}
+	var sizer func(any) (int64, error)
+	if cfg.Sizer != nil {
+		sizer = safeItemFunc("in-flight sizer", cfg.Sizer, policy)
+	}
+	budget := newBudget(cfg.MaxInFlight, cfg.MaxInFlightBytes, sizer)
+	cfg.Stats.budget.Store(budget)
+
+	// runCtx bounds all work (hard cancellation); sourceCtx only bounds intake
+	// so the source can be stopped while in-flight items drain.
+	runCtx, cancelRun := context.WithCancelCause(rootCtx)
+	sourceCtx, cancelSource := context.WithCancelCause(runCtx)
+	e := newExecution(cancelRun, cancelSource, cfg.Stats, cfg.OnDone, cfg.Clock)
+	e.pools = make([]*workerPool, len(stages))
+
+	tr := cfg.Tracer.begin(pipelineName, cfg.TraceRate, stages)
+
+	// Create spill logs before anything runs so their errors fail Start.
+	spills := make([]*spillLog, len(stages))
+	// abort undoes Start after err, adding the errors of removing spill logs.
+	abort := func(err error) (*Execution, error) {
+		for _, l := range spills {
+			if l == nil {
+				continue
+			}
+			if rerr := l.remove(); rerr != nil {
+				err = errors.Join(err, rerr)
+			}
+		}
+		cancelSource(nil)
+		cancelRun(nil)
+		return nil, err
+	}
+	for i, st := range stages {
+		if st.Config.Spill == nil {
+			continue
+		}
+		l, err := newSpillLog(*st.Config.Spill, pipelineName)
+		if err != nil {
+			return abort(err)
+		}
+		spills[i] = l
+	}
 
 	// Start source.
 	srcCh, err := source(sourceCtx)
 	if err != nil {
-		return StateFailed, err
+		return abort(err)
 	}
 
 	// Pump source into first stage as feed.
-	in0 := make(chan feed, max(0, cfg.DefaultBuffer))
+	in0 := newQueue(max(0, cfg.DefaultBuffer), cfg.Overflow)
+	in0.stage, in0.run, in0.onDrop = "source", cfg.Stats, cfg.OnDrop
+	var prioOf func(any) (int, error)
+	if cfg.Priority != nil {
+		in0.prioritize(cfg.Priority, cfg.DefaultBuffer, cfg.Clock)
+		prioOf = safeItemFunc("priority function", cfg.Priority.Of, policy)
+	}
+	cfg.Stats.stage(0).attach(in0)
+	var keyOf func(any) (string, error)
+	if cfg.Key != nil {
+		keyOf = safeItemFunc("idempotency key function", cfg.Key, policy)
+	}
 	var wg sync.WaitGroup
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		defer close(in0)
-		sourcePump(rootCtx, sourceCtx, srcCh, in0, pipelineName)
+		defer in0.close()
+		sourcePump(runCtx, sourceCtx, srcCh, in0, pipelineName, tr, cfg.Stats, e.intake, budget, prioOf, keyOf)
 	}()
 
 	// Wire stages.
-	current := (<-chan feed)(in0)
+	current := (<-chan feed)(in0.ch)
 	for i := range stages {
 		st := stages[i]
 		if st.Config.Concurrency < 1 {
@@ -121,56 +295,79 @@ func Run(rootCtx context.Context, pipelineName string, source Source, stages []S
 			buf = cfg.DefaultBuffer
 		}
 
-		out := make(chan feed, max(0, buf))
+		out := newQueue(max(0, buf), st.Config.Overflow)
+		out.stage, out.stats, out.run, out.onDrop = stageLabel(i, st.Config.Name), cfg.Stats.stage(i), cfg.Stats, cfg.OnDrop
+		if l := spills[i]; l != nil {
+			name := st.Config.Name
+			out.spill, out.consumer = l, cfg.Stats.stage(i+1)
+			out.fail = func(err error) { policy.set(fmt.Errorf("pipeline: spill%s: %w", formatStage(name), err)) }
+			out.consumer.attachSpill(l)
+			go out.forward(runCtx)
+		} else if cfg.Priority != nil {
+			out.prioritize(cfg.Priority, buf, cfg.Clock)
+		}
+		cfg.Stats.stage(i + 1).attach(out)
+		env := stageEnv{logger: logger, trace: tr.stage(i, st.Config.Name, st.Kind != StageBatch && max(st.Config.Concurrency, st.Config.MaxConcurrency) > 1), stats: cfg.Stats.stage(i), run: cfg.Stats, flush: e.flush, rate: st.Config.Rate, clock: cfg.Clock}
+		env.sequenced = cfg.Scheduler != nil
+		if st.Kind != StageKeyed {
+			env.open = opener{life: st.Lifecycle, name: st.Config.Name, policy: policy}
+		}
+		if cfg.SuspendBatchTimers {
+			env.pause = e.intake
+		}
 
+		wg.Add(1)
 		switch st.Kind {
 		case StageBatch:
-			if st.Batch == nil {
-				return StateFailed, ErrInvalidConfig
-			}
-			wg.Add(1)
-			go func(in <-chan feed, out chan<- feed, st Stage) {
+			go func(in <-chan feed, out *queue, st Stage, env stageEnv) {
+				defer wg.Done()
+				workerBatch(runCtx, in, out, scheduledBatch(cfg.Scheduler, i, st.Config.Name, safeBatch(st.Config.Name, st.Batch, policy)), st.BatchPolicy, env)
+			}(current, out, st, env)
+		case StageKeyed:
+			go func(in <-chan feed, out *queue, st Stage, env stageEnv) {
 				defer wg.Done()
-				workerBatch(rootCtx, in, out, safeBatch(st.Config.Name, st.Batch, policy), st.BatchPolicy, logger)
-			}(current, out, st)
+				workerKeyed(runCtx, pipelineName, in, out, scheduledSingle(cfg.Scheduler, i, st.Config.Name, safeSingle(st.Config.Name, st.Single, policy)), safeItemFunc("key function"+formatStage(st.Config.Name), st.Partition, policy), safeTimers(st.Config.Name, st.Timers, policy), st.Config.Concurrency, env)
+			}(current, out, st, env)
 		default:
-			if st.Single == nil {
-				return StateFailed, ErrInvalidConfig
+			pool := newWorkerPool(st.Config)
+			e.pools[i] = pool
+			if st.Config.Limiter != nil {
+				env.limit = newLimiter(*st.Config.Limiter)
+				env.stats.attachLimiter(env.limit)
 			}
-			wg.Add(1)
-			go func(in <-chan feed, out chan<- feed, st Stage) {
+			go func(in <-chan feed, out *queue, st Stage, env stageEnv) {
 				defer wg.Done()
-				workerSingle(rootCtx, in, out, safeSingle(st.Config.Name, st.Single, policy), st.Config.Concurrency, logger)
-			}(current, out, st)
+				workerSingle(runCtx, in, out, scheduledSingle(cfg.Scheduler, i, st.Config.Name, safeSingle(st.Config.Name, st.Single, policy)), pool, st.Config.Concurrency, env)
+			}(current, out, st, env)
 		}
 
-		current = out
-	}
-
-	// Consume sink in the current goroutine to simplify shutdown.
-	sinkConsume(rootCtx, current, sink, policy, logger)
-
-	// Stop feeding the source promptly once sink is done.
-	if cause := policy.get(); cause != nil {
-		cancelSource(cause)
+		current = out.ch
 	}
 
-	wg.Wait()
-
-	// Cancellation wins.
-	if err := rootCtx.Err(); err != nil {
-		return StateCancelled, err
-	}
+	e.running()
+
+	go func(in <-chan feed) {
+		env := stageEnv{logger: logger, trace: tr.sink(len(stages)), stats: cfg.Stats.Sink, run: cfg.Stats, flush: e.flush, clock: cfg.Clock, sequenced: cfg.Scheduler != nil}
+		res := opener{life: cfg.SinkLifecycle, name: "sink", policy: policy}.open(runCtx)
+		if sinkCtx, err := res.bind(runCtx); err != nil {
+			failInput(in, err)
+		} else if cfg.Tx != nil {
+			txConsume(sinkCtx, in, cfg.Tx, policy, env)
+		} else {
+			sinkConsume(sinkCtx, in, sink, policy, env)
+		}
+		res.close(runCtx)
 
-	if cause := policy.get(); cause != nil {
-		// If the source context was canceled with a cause, normalize to the cause.
-		if errors.Is(cause, context.Canceled) || errors.Is(cause, context.DeadlineExceeded) {
-			return StateCancelled, cause
+		// Stop feeding the source promptly once sink is done.
+		if cause := policy.get(); cause != nil {
+			cancelSource(cause)
 		}
-		return StateFailed, cause
-	}
 
-	return StateSucceeded, nil
+		wg.Wait()
+		e.finish(e.outcome(rootCtx, runCtx, policy))
+	}(current)
+
+	return e, nil
 }
 
 func max(a, b int) int {
@@ -5,87 +5,224 @@ import (
 	"time"
 )
 
-func workerBatch(ctx context.Context, in <-chan feed, out chan<- feed, handler BatchHandler, policy BatchPolicy, logger Logger) {
-	defer close(out)
+func workerBatch(ctx context.Context, in <-chan feed, out *queue, handler BatchHandler, policy BatchPolicy, env stageEnv) {
+	defer out.close()
+	env.trace.started(0)
+
+	res := env.open.open(ctx)
+	defer res.close(ctx)
+	ctx, err := res.bind(ctx)
+	if err != nil {
+		failInput(in, err)
+		return
+	}
 
 	if policy.Size < 1 {
 		policy.Size = 1
 	}
 
 	var (
-		buf   = make([]feed, 0, policy.Size)
-		timer *time.Timer
+		buf    = make([]feed, 0, policy.Size)
+		joined = make([]time.Time, 0, policy.Size)
+		timer  = &batchTimer{clock: env.clock, wait: policy.MaxWait}
+		// emitted numbers the outputs (see Step.Seq).
+		emitted uint64
 	)
+	defer timer.stop()
 
-	resetTimer := func() {
-		if policy.MaxWait <= 0 {
-			return
-		}
-		if timer == nil {
-			timer = time.NewTimer(policy.MaxWait)
-			return
-		}
-		if !timer.Stop() {
-			select {
-			case <-timer.C:
-			default:
-			}
-		}
-		timer.Reset(policy.MaxWait)
-	}
-
-	flush := func() {
+	flush := func(reason string) {
 		if len(buf) == 0 {
 			return
 		}
 
 		inputs := make([]any, 0, len(buf))
+		traced := false
+		prio := buf[0].prio
 		for _, f := range buf {
 			inputs = append(inputs, f.Data)
+			traced = traced || f.traced
+			prio = max(prio, f.prio)
+		}
+
+		// One token per handler call, not per item.
+		if err := env.rate.Wait(ctx, env.clock); err != nil {
+			env.abandoned(len(buf))
+			failAll(buf, ErrAbandoned)
+			buf, joined = buf[:0], joined[:0]
+			return
 		}
 
-		outs, err := handler(ctx, inputs)
-		if err == nil {
-			for _, o := range outs {
-				select {
-				case <-ctx.Done():
-					// stop emitting
-					buf = buf[:0]
-					return
-				case out <- feed{RootCtx: ctx, PipelineName: buf[0].PipelineName, Data: o}:
+		start := time.Now()
+		outs, err := handler(env.withSeq(ctx, buf[0].seq), inputs)
+		env.trace.flushed(len(inputs), reason, start, err)
+		for i, f := range buf {
+			env.trace.batched(f, joined[i], start)
+		}
+		if err == errUnscheduled {
+			env.abandoned(len(buf))
+			failAll(buf, ErrAbandoned)
+			buf, joined = buf[:0], joined[:0]
+			return
+		}
+		if err != nil {
+			env.stats.failed()
+			failAll(buf, err)
+			buf, joined = buf[:0], joined[:0]
+			return
+		}
+		// The outputs keep the inputs in flight until they all leave.
+		org := group(buf, len(outs))
+		key := batchKey(buf)
+		for i, o := range outs {
+			emitted++
+			nf := feed{RootCtx: ctx, PipelineName: buf[0].PipelineName, Data: o, org: org, prio: prio, key: outputKey(key, i), seq: emitted}
+			if traced {
+				env.trace.derived(&nf)
+			}
+			switch out.push(ctx, nf) {
+			case pushed:
+				env.stats.emitted(1)
+			case pushFailed:
+				org.fail(ErrAbandoned)
+			case pushCancelled:
+				// stop emitting
+				env.abandoned(len(outs) - i)
+				for range outs[i:] {
+					org.fail(ErrAbandoned)
 				}
+				buf, joined = buf[:0], joined[:0]
+				return
 			}
 		}
 
-		buf = buf[:0]
+		buf, joined = buf[:0], joined[:0]
 	}
 
 	for {
-		var timerC <-chan time.Time
-		if timer != nil {
-			timerC = timer.C
+		paused, pauseChanged := env.pause.state()
+		if paused {
+			timer.suspend()
+		} else {
+			timer.resume()
 		}
 
 		select {
 		case <-ctx.Done():
-			// Best-effort flush of buffered items on cancel.
-			flush()
+			// Best-effort flush of buffered items on cancel, then drain the
+			// input so upstream never blocks; drained items are abandoned.
+			flush("cancel")
+			for f := range in {
+				env.abandoned(1)
+				f.org.fail(ErrAbandoned)
+			}
 			return
-		case <-timerC:
-			flush()
+		case <-timer.C():
+			timer.fired()
+			flush("timer")
+		case <-pauseChanged:
+			// Suspend or resume the timer at the top of the loop.
+		case <-env.flush.wait():
+			flush("signal")
 		case f, ok := <-in:
 			if !ok {
-				flush()
+				flush("close")
 				return
 			}
 			if len(buf) == 0 {
-				resetTimer()
+				timer.reset()
 			}
+			env.stats.received()
 			buf = append(buf, f)
+			joined = append(joined, env.trace.dequeued(f))
 			if len(buf) >= policy.Size {
-				flush()
-				resetTimer()
+				flush("size")
+				timer.reset()
 			}
 		}
 	}
 }
+
+// batchTimer is the MaxWait timer of a batch stage. While suspended it keeps
+// the time that was left and restarts with it on resume.
+type batchTimer struct {
+	clock Clock
+	wait  time.Duration
+	t     Timer
+	armed bool
+	due   time.Time
+
+	suspended bool
+	left      time.Duration
+}
+
+// C returns the timer channel, or nil when the timer is not running.
+func (b *batchTimer) C() <-chan time.Time {
+	if !b.armed {
+		return nil
+	}
+	return b.t.C()
+}
+
+// reset (re)arms the timer for the full MaxWait.
+func (b *batchTimer) reset() {
+	if b.wait <= 0 {
+		return
+	}
+	if b.suspended {
+		b.left = b.wait
+		return
+	}
+	b.start(b.wait)
+}
+
+func (b *batchTimer) fired() {
+	b.armed = false
+}
+
+func (b *batchTimer) suspend() {
+	if b.suspended {
+		return
+	}
+	b.suspended = true
+	b.left = 0
+	if b.armed {
+		// Keep a minimal remainder so an expired timer still fires on resume.
+		b.left = b.due.Sub(b.clock.Now())
+		if b.left <= 0 {
+			b.left = time.Nanosecond
+		}
+		b.stop()
+	}
+}
+
+func (b *batchTimer) resume() {
+	if !b.suspended {
+		return
+	}
+	b.suspended = false
+	if b.left > 0 {
+		b.start(b.left)
+		b.left = 0
+	}
+}
+
+func (b *batchTimer) start(d time.Duration) {
+	b.stop()
+	if b.t == nil {
+		b.t = b.clock.NewTimer(d)
+	} else {
+		b.t.Reset(d)
+	}
+	b.armed = true
+	b.due = b.clock.Now().Add(d)
+}
+
+func (b *batchTimer) stop() {
+	if b.t != nil && !b.t.Stop() {
+		select {
+		case <-b.t.C():
+		default:
+		}
+	}
+	b.armed = false
+}
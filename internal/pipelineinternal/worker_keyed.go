@@ -0,0 +1,164 @@
+package pipelineinternal
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// KeyedTimers are the timers of a keyed stage. Each partition's worker fires
+// its own timers between items, so timers and items of a partition never run
+// concurrently.
+type KeyedTimers struct {
+	// Next returns the earliest processing-time deadline of a partition.
+	Next func(partition int) (time.Time, bool)
+	// Wake is signalled when timers of a partition may have become due for
+	// another reason, such as an event-time watermark moving.
+	Wake func(partition int) <-chan struct{}
+	// Fire runs the due timers of a partition and returns the items they
+	// emit.
+	Fire func(ctx context.Context, partition int, now time.Time) ([]Tracked, error)
+}
+
+// workerKeyed runs a keyed stage with a fixed worker per partition, to which
+// a router hands the items of its keys in order.
+func workerKeyed(ctx context.Context, pipelineName string, in <-chan feed, out *queue, handler SingleHandler, partition func(any) (int, error), timers *KeyedTimers, n int, env stageEnv) {
+	parts := make([]chan feed, n)
+	var wg sync.WaitGroup
+	for i := range parts {
+		parts[i] = make(chan feed)
+		wg.Add(1)
+		go func(worker int, in <-chan feed) {
+			defer wg.Done()
+			env.trace.started(worker)
+			if timers == nil {
+				for f := range in {
+					keyedItem(ctx, f, out, handler, worker, env)
+				}
+				return
+			}
+			keyedTimed(ctx, pipelineName, in, out, handler, timers, worker, env)
+		}(i, parts[i])
+	}
+
+	for f := range in {
+		if ctx.Err() != nil {
+			// Keep draining the input, but stop routing.
+			env.abandoned(1)
+			f.org.fail(ErrAbandoned)
+			continue
+		}
+		p, err := partition(f.Data)
+		if err != nil {
+			env.stats.received()
+			env.stats.failed()
+			f.org.fail(err)
+			continue
+		}
+		parts[p] <- f
+	}
+	for _, p := range parts {
+		close(p)
+	}
+	wg.Wait()
+	out.close()
+	env.logger.Debug("pipeline stage complete")
+}
+
+// keyedTimed is the loop of a partition worker with timers: it handles items
+// and fires due timers until the input is exhausted. Pending timers do not
+// fire after that.
+func keyedTimed(ctx context.Context, pipelineName string, in <-chan feed, out *queue, handler SingleHandler, timers *KeyedTimers, worker int, env stageEnv) {
+	wake := timers.Wake(worker)
+	var emitted uint64
+	for {
+		var (
+			t   Timer
+			due <-chan time.Time
+		)
+		// Timers do not fire once the run is cancelled; only drain the input.
+		if at, ok := timers.Next(worker); ok && ctx.Err() == nil {
+			t = env.clock.NewTimer(at.Sub(env.clock.Now()))
+			due = t.C()
+		}
+		select {
+		case f, ok := <-in:
+			if t != nil {
+				t.Stop()
+			}
+			if !ok {
+				return
+			}
+			keyedItem(ctx, f, out, handler, worker, env)
+		case <-due:
+		case <-wake:
+			if t != nil {
+				t.Stop()
+			}
+		}
+		if ctx.Err() != nil {
+			continue
+		}
+		outs, err := timers.Fire(ctx, worker, env.clock.Now())
+		if err != nil {
+			env.stats.failed()
+			continue
+		}
+		for _, o := range outs {
+			var org *lineage
+			if o.Admit != nil {
+				org = newLineage(o.Admit())
+			}
+			emitted++
+			nf := feed{RootCtx: ctx, PipelineName: pipelineName, Data: o.Value, org: org, key: o.Key, seq: timerSeq(worker, emitted)}
+			switch out.push(ctx, nf) {
+			case pushed:
+				env.stats.emitted(1)
+			case pushFailed:
+				nf.org.fail(ErrAbandoned)
+			case pushCancelled:
+				env.abandoned(1)
+				nf.org.fail(ErrAbandoned)
+			}
+		}
+	}
+}
+
+func keyedItem(ctx context.Context, f feed, out *queue, handler SingleHandler, worker int, env stageEnv) {
+	if ctx.Err() != nil {
+		env.abandoned(1)
+		f.org.fail(ErrAbandoned)
+		return
+	}
+	env.stats.received()
+	if err := env.rate.Wait(ctx, env.clock); err != nil {
+		env.abandoned(1)
+		f.org.fail(ErrAbandoned)
+		return
+	}
+	start := env.trace.dequeued(f)
+	outData, err := handler(env.withSeq(ctx, f.seq), f.Data)
+	env.trace.handled(f, worker, start, err)
+	if err == errUnscheduled {
+		env.abandoned(1)
+		f.org.fail(ErrAbandoned)
+		return
+	}
+	if err != nil {
+		env.stats.failed()
+		f.org.fail(err)
+		return
+	}
+
+	nf := feed{RootCtx: f.RootCtx, PipelineName: f.PipelineName, Data: outData, id: f.id, traced: f.traced, org: f.org, prio: f.prio, key: f.key, seq: f.seq}
+	env.trace.emitted(&nf)
+	switch out.push(ctx, nf) {
+	case pushed:
+		env.stats.emitted(1)
+	case pushFailed:
+		nf.org.fail(ErrAbandoned)
+	case pushCancelled:
+		env.abandoned(1)
+		nf.org.fail(ErrAbandoned)
+	}
+}
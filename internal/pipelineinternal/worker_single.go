@@ -3,45 +3,259 @@ package pipelineinternal
 import (
 	"context"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
-func workerSingle(ctx context.Context, in <-chan feed, out chan<- feed, handler SingleHandler, concurrency int, logger Logger) {
-	if concurrency < 1 {
-		concurrency = 1
+// workerPool is the resizable set of workers of a single-item stage, kept as
+// a stack of quit channels. It is sealed once the input closes.
+type workerPool struct {
+	mu     sync.Mutex
+	quits  []chan struct{}
+	sealed bool
+	wg     sync.WaitGroup
+	busy   atomic.Int64
+
+	// spawn runs one worker until quit is closed or the input is exhausted.
+	// Until it is set, resize only records the requested size in pending.
+	spawn   func(worker int, quit <-chan struct{})
+	pending int
+
+	// Autoscaling bounds; max is zero for a fixed-size stage.
+	min, max int
+}
+
+func newWorkerPool(cfg StageConfig) *workerPool {
+	p := &workerPool{min: cfg.MinConcurrency, max: cfg.MaxConcurrency}
+	if p.max > 0 {
+		if p.min < 1 {
+			p.min = 1
+		}
+		if p.max < p.min {
+			p.max = p.min
+		}
 	}
+	return p
+}
 
-	var wg sync.WaitGroup
-	wg.Add(concurrency)
+// size reports the current number of workers.
+func (p *workerPool) size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.quits)
+}
 
-	for i := 0; i < concurrency; i++ {
-		go func() {
-			defer wg.Done()
-			for f := range in {
-				// Respect cancellation.
-				select {
-				case <-ctx.Done():
-					// Drain input by continuing the range; but stop processing.
-					continue
-				default:
-				}
+// resize sets the number of workers to n, clamped to at least one and to the
+// autoscaling bounds if set. It returns the resulting size.
+func (p *workerPool) resize(n int) int {
+	if n < 1 {
+		n = 1
+	}
+	if p.max > 0 {
+		n = min(max(n, p.min), p.max)
+	}
 
-				outData, err := handler(ctx, f.Data)
-				if err != nil {
-					// Do not emit an output item for this failed input.
-					continue
-				}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.spawn == nil {
+		p.pending = n
+		return n
+	}
+	if p.sealed {
+		return len(p.quits)
+	}
+	for len(p.quits) < n {
+		quit := make(chan struct{})
+		p.quits = append(p.quits, quit)
+		p.wg.Add(1)
+		go func(worker int) {
+			defer p.wg.Done()
+			p.spawn(worker, quit)
+		}(len(p.quits) - 1)
+	}
+	for len(p.quits) > n {
+		last := len(p.quits) - 1
+		close(p.quits[last])
+		p.quits = p.quits[:last]
+	}
+	return len(p.quits)
+}
+
+// start launches n workers, or the size requested before the stage started.
+func (p *workerPool) start(spawn func(worker int, quit <-chan struct{}), n int) {
+	p.mu.Lock()
+	p.spawn = spawn
+	if p.pending > 0 {
+		n = p.pending
+	}
+	p.mu.Unlock()
+	p.resize(n)
+}
+
+func (p *workerPool) seal() {
+	p.mu.Lock()
+	p.sealed = true
+	p.mu.Unlock()
+}
+
+func (p *workerPool) isSealed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sealed
+}
 
-				nf := feed{RootCtx: f.RootCtx, PipelineName: f.PipelineName, Data: outData}
-				select {
-				case <-ctx.Done():
+func workerSingle(ctx context.Context, in <-chan feed, out *queue, handler SingleHandler, pool *workerPool, concurrency int, env stageEnv) {
+	var stageRes *resource
+	if !env.open.perWorker() {
+		stageRes = env.open.open(ctx)
+		defer stageRes.close(ctx)
+	}
+	pool.start(func(worker int, quit <-chan struct{}) {
+		env.trace.started(worker)
+		res := stageRes
+		if env.open.perWorker() {
+			res = env.open.open(ctx)
+			defer res.close(ctx)
+		}
+		ctx, openErr := res.bind(ctx)
+		for {
+			var f feed
+			var ok bool
+			select {
+			case <-quit:
+				return
+			case f, ok = <-in:
+			}
+			if !ok {
+				pool.seal()
+				return
+			}
+			if openErr != nil {
+				f.org.fail(openErr)
+				continue
+			}
+
+			// Respect cancellation.
+			select {
+			case <-ctx.Done():
+				// Keep draining the input, but stop processing.
+				env.abandoned(1)
+				f.org.fail(ErrAbandoned)
+				continue
+			default:
+			}
+
+			env.stats.received()
+			if err := env.rate.Wait(ctx, env.clock); err != nil {
+				env.abandoned(1)
+				f.org.fail(ErrAbandoned)
+				continue
+			}
+			hctx := ctx
+			var overload *atomic.Bool
+			if env.limit != nil {
+				if !env.limit.acquire(ctx) {
+					env.abandoned(1)
+					f.org.fail(ErrAbandoned)
 					continue
-				case out <- nf:
 				}
+				hctx, overload = withOverload(ctx)
+			}
+			pool.busy.Add(1)
+			start := env.trace.dequeued(f)
+			called := env.clock.Now()
+			outData, err := handler(env.withSeq(hctx, f.seq), f.Data)
+			if env.limit != nil {
+				env.limit.release(env.clock.Now().Sub(called), overload.Load())
 			}
-		}()
+			env.trace.handled(f, worker, start, err)
+			pool.busy.Add(-1)
+			if err == errUnscheduled {
+				env.abandoned(1)
+				f.org.fail(ErrAbandoned)
+				continue
+			}
+			if err != nil {
+				// Do not emit an output item for this failed input.
+				env.stats.failed()
+				f.org.fail(err)
+				continue
+			}
+
+			nf := feed{RootCtx: f.RootCtx, PipelineName: f.PipelineName, Data: outData, id: f.id, traced: f.traced, org: f.org, prio: f.prio, key: f.key, seq: f.seq}
+			env.trace.emitted(&nf)
+			switch out.push(ctx, nf) {
+			case pushed:
+				env.stats.emitted(1)
+			case pushFailed:
+				nf.org.fail(ErrAbandoned)
+			case pushCancelled:
+				env.abandoned(1)
+				nf.org.fail(ErrAbandoned)
+			}
+		}
+	}, concurrency)
+	env.stats.attachPool(pool)
+
+	if pool.max > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		go autoscale(env.stats, pool, env.clock, stop)
 	}
 
-	wg.Wait()
-	close(out)
-	logger.Debug("pipeline stage complete")
+	pool.wg.Wait()
+	pool.mu.Lock()
+	pool.quits = nil
+	pool.mu.Unlock()
+	out.close()
+	env.logger.Debug("pipeline stage complete")
+}
+
+// Autoscaling adds a worker after scaleUpAfter saturated samples and removes
+// one after scaleDownAfter idle samples.
+const (
+	autoscaleInterval = 100 * time.Millisecond
+	scaleUpAfter      = 3
+	scaleDownAfter    = 20
+)
+
+func autoscale(in *StageStats, pool *workerPool, clock Clock, stop <-chan struct{}) {
+	ticker := clock.NewTicker(autoscaleInterval)
+	defer ticker.Stop()
+
+	var hot, cold int
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C():
+		}
+		if pool.isSealed() {
+			return
+		}
+
+		workers := pool.size()
+		busy := int(pool.busy.Load())
+		queued, capacity := in.Queued()
+
+		saturated := busy >= workers && (capacity == 0 || queued >= capacity)
+		idle := queued == 0 && busy < workers
+		switch {
+		case saturated:
+			hot, cold = hot+1, 0
+		case idle:
+			hot, cold = 0, cold+1
+		default:
+			hot, cold = 0, 0
+		}
+
+		switch {
+		case hot >= scaleUpAfter && workers < pool.max:
+			pool.resize(workers + 1)
+			hot = 0
+		case cold >= scaleDownAfter && workers > pool.min:
+			pool.resize(workers - 1)
+			cold = 0
+		}
+	}
 }
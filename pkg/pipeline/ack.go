@@ -0,0 +1,62 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+
+	"github.com/jpconstantineau/data-duct/internal/pipelineinternal"
+)
+
+var (
+	// ErrDropped is passed to Nack for an item discarded by an overflow
+	// policy.
+	ErrDropped = pipelineinternal.ErrDropped
+	// ErrAbandoned is passed to Nack for an item that did not reach the sink
+	// because the run was cancelled or had already failed.
+	ErrAbandoned = pipelineinternal.ErrAbandoned
+)
+
+// Message is a source item together with the hooks that report its outcome
+// back to the source. Either hook may be nil.
+type Message[T any] struct {
+	Value T
+	// Ack is called once everything derived from Value has been written.
+	Ack func()
+	// Nack is called instead with the failure, ErrDropped or ErrAbandoned.
+	Nack func(error)
+}
+
+// AckSourceFunc is a source whose items carry acknowledgement hooks.
+type AckSourceFunc[T any] func(ctx context.Context) (<-chan Message[T], error)
+
+// NewAcked creates a pipeline builder for an acknowledging source. Stages see
+// plain T values; exactly one of Ack or Nack runs per admitted message.
+func NewAcked[T any](name string, source AckSourceFunc[T], opts ...Option) *Pipeline {
+	return newPipeline(name, typeOf[T](), func(ctx context.Context) (<-chan any, error) {
+		ch, err := source(ctx)
+		if err != nil {
+			return nil, err
+		}
+		wrap := func(m Message[T]) any {
+			return pipelineinternal.Tracked{Value: m.Value, Admit: func() func(error) { return outcome(m) }}
+		}
+		lost := func(m Message[T]) { outcome(m)(ErrAbandoned) }
+		return relay(ctx, ch, wrap, lost), nil
+	}, nil, opts)
+}
+
+// outcome returns the outcome callback of m, which runs at most once.
+func outcome[T any](m Message[T]) func(error) {
+	var once sync.Once
+	return func(err error) {
+		once.Do(func() {
+			if err == nil {
+				if m.Ack != nil {
+					m.Ack()
+				}
+			} else if m.Nack != nil {
+				m.Nack(err)
+			}
+		})
+	}
+}
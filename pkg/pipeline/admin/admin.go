@@ -0,0 +1,404 @@
+// Package admin provides an http.Handler that serves the stage graph, live
+// stats and lifecycle actions of pipelines running inside a service as JSON.
+//
+// Actions are POST requests with Content-Type application/json, so a
+// cross-site form post cannot trigger them.
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jpconstantineau/data-duct/pkg/pipeline"
+)
+
+var (
+	// ErrDuplicate is returned when registering a second pipeline with the same name.
+	ErrDuplicate = errors.New("admin: pipeline already registered")
+	// ErrCancelled is the cancellation cause for runs cancelled through the handler.
+	ErrCancelled = errors.New("admin: cancelled via admin API")
+)
+
+// Handler serves the admin API. The zero value is not usable; use New.
+type Handler struct {
+	mux      *http.ServeMux
+	readOnly bool
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	nextRun int
+}
+
+type entry struct {
+	r      *pipeline.Runnable
+	runs   map[int]*run
+	result pipeline.Result
+}
+
+type run struct {
+	h      *pipeline.Handle
+	cancel context.CancelCauseFunc
+}
+
+// Option configures a Handler.
+type Option func(*Handler)
+
+// ReadOnly disables all action endpoints; they respond with 403 Forbidden.
+func ReadOnly() Option {
+	return func(h *Handler) { h.readOnly = true }
+}
+
+// New creates an admin handler with no registered pipelines.
+func New(opts ...Option) *Handler {
+	h := &Handler{mux: http.NewServeMux(), entries: map[string]*entry{}}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(h)
+		}
+	}
+
+	h.mux.HandleFunc("GET /{$}", h.list)
+	h.mux.HandleFunc("GET /{name}", h.detail)
+	h.mux.HandleFunc("POST /{name}/{action}", h.action)
+	h.mux.HandleFunc("POST /{name}/stages/{stage}/concurrency", h.concurrency)
+	return h
+}
+
+// Register makes r visible through the handler. Pipelines are keyed by name.
+func (h *Handler) Register(r *pipeline.Runnable) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.entries[r.Name()]; ok {
+		return ErrDuplicate
+	}
+	h.entries[r.Name()] = &entry{r: r, runs: map[int]*run{}}
+	return nil
+}
+
+// Unregister removes the pipeline with the given name, if present.
+func (h *Handler) Unregister(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.entries, name)
+}
+
+// Run registers r (if it is not registered yet) and runs it, allowing it to be
+// controlled through the action endpoints. The pipeline stays registered after
+// Run returns so its final state and counters remain visible.
+func (h *Handler) Run(ctx context.Context, r *pipeline.Runnable) (pipeline.Result, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	handle, err := r.Start(ctx)
+	if err != nil {
+		return pipeline.Failed{Cause: err}, err
+	}
+
+	h.mu.Lock()
+	e, ok := h.entries[r.Name()]
+	if !ok {
+		e = &entry{r: r, runs: map[int]*run{}}
+		h.entries[r.Name()] = e
+	}
+	h.nextRun++
+	id := h.nextRun
+	e.runs[id] = &run{h: handle, cancel: cancel}
+	h.mu.Unlock()
+
+	res, err := handle.Wait()
+
+	h.mu.Lock()
+	delete(e.runs, id)
+	e.result = res
+	h.mu.Unlock()
+	return res, err
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	h.mux.ServeHTTP(w, req)
+}
+
+type summaryView struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+type detailView struct {
+	Name     string `json:"name"`
+	State    string `json:"state"`
+	Error    string `json:"error,omitempty"`
+	Buffer   int    `json:"buffer"`
+	Admitted int64  `json:"admitted"`
+	Dropped  int64  `json:"dropped"`
+	InFlight int64  `json:"inFlight"`
+	// PriorityAging is set when queues are ordered by priority.
+	PriorityAging string      `json:"priorityAging,omitempty"`
+	Stages        []stageView `json:"stages"`
+}
+
+type stageView struct {
+	Index       int        `json:"index"`
+	Name        string     `json:"name"`
+	Kind        string     `json:"kind"`
+	Concurrency int        `json:"concurrency"`
+	Buffer      int        `json:"buffer"`
+	Overflow    string     `json:"overflow"`
+	Batch       *batchView `json:"batch,omitempty"`
+	In          int64      `json:"in"`
+	Out         int64      `json:"out"`
+	Errors      int64      `json:"errors"`
+	Dropped     int64      `json:"dropped,omitempty"`
+	Queued      int        `json:"queued"`
+	QueueCap    int        `json:"queueCap"`
+	OnDisk      int        `json:"onDisk,omitempty"`
+	Spilled     int64      `json:"spilled,omitempty"`
+	Workers     int        `json:"workers,omitempty"`
+	Busy        int        `json:"busy,omitempty"`
+	Limit       int        `json:"limit,omitempty"`
+	InFlight    int        `json:"inFlight,omitempty"`
+	Autoscale   *scaleView `json:"autoscale,omitempty"`
+	RateLimit   *rateView  `json:"rateLimit,omitempty"`
+}
+
+type rateView struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+type scaleView struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+type batchView struct {
+	Size    int    `json:"size"`
+	MaxWait string `json:"maxWait"`
+}
+
+func (h *Handler) list(w http.ResponseWriter, _ *http.Request) {
+	h.mu.Lock()
+	out := make([]summaryView, 0, len(h.entries))
+	for name, e := range h.entries {
+		out = append(out, summaryView{Name: name, State: e.state(e.r.Stats())})
+	}
+	h.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (h *Handler) detail(w http.ResponseWriter, req *http.Request) {
+	h.mu.Lock()
+	e, ok := h.entries[req.PathValue("name")]
+	var (
+		state  string
+		errMsg string
+		stats  pipeline.Stats
+	)
+	if ok {
+		stats = e.r.Stats()
+		state = e.state(stats)
+		if e.result != nil && e.result.Err() != nil && len(e.runs) == 0 {
+			errMsg = e.result.Err().Error()
+		}
+	}
+	h.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "pipeline not found")
+		return
+	}
+
+	desc := e.r.Describe()
+	view := detailView{Name: desc.Name, State: state, Error: errMsg, Buffer: desc.Buffer, Admitted: stats.Admitted, Dropped: stats.Dropped, InFlight: stats.InFlight}
+	if desc.Prioritized {
+		view.PriorityAging = desc.PriorityAging.String()
+	}
+	for _, s := range desc.Stages {
+		sv := stageView{Index: s.Index, Name: s.Name, Kind: string(s.Kind), Concurrency: s.Concurrency, Buffer: s.Buffer, Overflow: s.Overflow.String()}
+		if s.Batch != nil {
+			sv.Batch = &batchView{Size: s.Batch.Size, MaxWait: s.Batch.MaxWait.String()}
+		}
+		if s.RateLimit != nil {
+			sv.RateLimit = &rateView{Rate: s.RateLimit.Rate(), Burst: s.RateLimit.Burst()}
+		}
+		if s.Autoscale != nil {
+			sv.Autoscale = &scaleView{Min: s.Autoscale.Min, Max: s.Autoscale.Max}
+		}
+		var st pipeline.StageStats
+		if s.Kind == pipeline.StageKindSink {
+			st = stats.Sink
+		} else if s.Index < len(stats.Stages) {
+			st = stats.Stages[s.Index]
+		}
+		sv.In, sv.Out, sv.Errors, sv.Queued, sv.QueueCap = st.In, st.Out, st.Errors, st.Queued, st.QueueCap
+		sv.Workers, sv.Busy, sv.Dropped = st.Workers, st.Busy, st.Dropped
+		sv.OnDisk, sv.Spilled = st.OnDisk, st.Spilled
+		sv.Limit, sv.InFlight = st.Limit, st.InFlight
+		view.Stages = append(view.Stages, sv)
+	}
+	writeJSON(w, http.StatusOK, view)
+}
+
+func (h *Handler) action(w http.ResponseWriter, req *http.Request) {
+	if !h.writable(w, req) {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e, ok := h.entries[req.PathValue("name")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "pipeline not found")
+		return
+	}
+
+	action := req.PathValue("action")
+	switch action {
+	case "stop", "cancel", "pause", "resume":
+	default:
+		writeError(w, http.StatusNotFound, "unknown action")
+		return
+	}
+	if len(e.runs) == 0 {
+		writeError(w, http.StatusConflict, "pipeline is not running under admin control")
+		return
+	}
+
+	switch action {
+	case "stop":
+		var drain time.Duration
+		if v := req.URL.Query().Get("drain"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				writeError(w, http.StatusBadRequest, "invalid drain duration")
+				return
+			}
+			drain = d
+		}
+		for _, r := range e.runs {
+			r.h.Stop(drain)
+		}
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "draining"})
+	case "cancel":
+		for _, r := range e.runs {
+			r.cancel(ErrCancelled)
+		}
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "cancelling"})
+	case "pause":
+		for _, r := range e.runs {
+			r.h.Pause()
+		}
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "paused"})
+	case "resume":
+		for _, r := range e.runs {
+			r.h.Resume()
+		}
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "running"})
+	}
+}
+
+func (h *Handler) concurrency(w http.ResponseWriter, req *http.Request) {
+	if !h.writable(w, req) {
+		return
+	}
+	n, err := strconv.Atoi(req.URL.Query().Get("n"))
+	if err != nil || n < 1 {
+		writeError(w, http.StatusBadRequest, "invalid worker count")
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e, ok := h.entries[req.PathValue("name")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "pipeline not found")
+		return
+	}
+	stage, ok := stageIndex(e.r.Describe(), req.PathValue("stage"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "stage not found")
+		return
+	}
+	if len(e.runs) == 0 {
+		writeError(w, http.StatusConflict, "pipeline is not running under admin control")
+		return
+	}
+
+	workers := 0
+	for _, r := range e.runs {
+		workers, err = r.h.SetConcurrency(stage, n)
+		if err != nil {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"workers": workers})
+}
+
+// writable reports whether req may run an action, writing the error response
+// if not. Actions must be sent as application/json, which browsers do not send
+// cross-site without a CORS preflight, so a plain form post cannot trigger one.
+func (h *Handler) writable(w http.ResponseWriter, req *http.Request) bool {
+	if h.readOnly {
+		writeError(w, http.StatusForbidden, "admin handler is read-only")
+		return false
+	}
+	mt, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || mt != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, "actions require Content-Type: application/json")
+		return false
+	}
+	return true
+}
+
+// stageIndex resolves a processing stage given by index or by name.
+func stageIndex(desc pipeline.Description, stage string) (int, bool) {
+	for _, s := range desc.Stages {
+		if s.Kind == pipeline.StageKindSink {
+			continue
+		}
+		if s.Name == stage || strconv.Itoa(s.Index) == stage {
+			return s.Index, true
+		}
+	}
+	return 0, false
+}
+
+// state must be called with h.mu held.
+func (e *entry) state(stats pipeline.Stats) string {
+	for _, r := range e.runs {
+		// Concurrent runs of one pipeline are unusual; report any of them.
+		return string(r.h.State())
+	}
+	switch {
+	case stats.Running:
+		return "running"
+	case e.result != nil:
+		return string(e.result.State())
+	default:
+		return "idle"
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
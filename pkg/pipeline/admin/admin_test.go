@@ -0,0 +1,221 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jpconstantineau/data-duct/pkg/pipeline"
+)
+
+func blockingSource(ctx context.Context) (<-chan int, error) {
+	ch := make(chan int)
+	go func() {
+		defer close(ch)
+		for i := 0; ; i++ {
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- i:
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func getJSON(t *testing.T, srv *httptest.Server, path string, v any) int {
+	t.Helper()
+	resp, err := http.Get(srv.URL + path)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("decode %s: %v", path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func post(t *testing.T, srv *httptest.Server, path string) int {
+	t.Helper()
+	resp, err := http.Post(srv.URL+path, "application/json", nil)
+	if err != nil {
+		t.Fatalf("POST %s: %v", path, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestAdminDescribesAndCancelsRunningPipeline(t *testing.T) {
+	t.Parallel()
+
+	adm := New()
+	srv := httptest.NewServer(adm)
+	defer srv.Close()
+
+	r := pipeline.New("ingest", blockingSource, pipeline.WithBuffer(4)).
+		Then(func(ctx context.Context, n int) (int, error) { return n, nil }, pipeline.WithStageName("enrich"), pipeline.WithStageConcurrency(3)).
+		ThenBatch(func(ctx context.Context, in []int) ([]int, error) { return in, nil }, pipeline.BatchPolicy{Size: 5, MaxWait: time.Second}, pipeline.WithStageName("group")).
+		To(func(ctx context.Context, n int) error { return nil })
+
+	done := make(chan pipeline.Result, 1)
+	go func() {
+		res, _ := adm.Run(context.Background(), r)
+		done <- res
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	var view detailView
+	for {
+		if status := getJSON(t, srv, "/ingest", &view); status != http.StatusOK {
+			t.Fatalf("expected 200, got %d", status)
+		}
+		if view.State == "running" && view.Stages[2].Out > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pipeline never reported progress: %+v", view)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if len(view.Stages) != 3 {
+		t.Fatalf("expected 2 stages plus sink, got %+v", view.Stages)
+	}
+	if s := view.Stages[0]; s.Name != "enrich" || s.Kind != "single" || s.Concurrency != 3 || s.Buffer != 4 {
+		t.Fatalf("unexpected enrich stage: %+v", s)
+	}
+	if s := view.Stages[1]; s.Kind != "batch" || s.Batch == nil || s.Batch.Size != 5 || s.Batch.MaxWait != "1s" {
+		t.Fatalf("unexpected batch stage: %+v", s)
+	}
+
+	if s := view.Stages[0]; s.Workers != 3 {
+		t.Fatalf("expected 3 enrich workers, got %+v", s)
+	}
+	if status := post(t, srv, "/ingest/stages/enrich/concurrency?n=5"); status != http.StatusOK {
+		t.Fatalf("expected 200 from resize, got %d", status)
+	}
+	if getJSON(t, srv, "/ingest", &view); view.Stages[0].Workers != 5 {
+		t.Fatalf("expected 5 enrich workers, got %+v", view.Stages[0])
+	}
+	if status := post(t, srv, "/ingest/stages/1/concurrency?n=2"); status != http.StatusConflict {
+		t.Fatalf("expected 409 resizing a batch stage, got %d", status)
+	}
+	if status := post(t, srv, "/ingest/stages/nope/concurrency?n=2"); status != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown stage, got %d", status)
+	}
+
+	if status := post(t, srv, "/ingest/pause"); status != http.StatusAccepted {
+		t.Fatalf("expected 202 from pause, got %d", status)
+	}
+	if getJSON(t, srv, "/ingest", &view); view.State != "paused" {
+		t.Fatalf("expected paused state, got %s", view.State)
+	}
+	if status := post(t, srv, "/ingest/resume"); status != http.StatusAccepted {
+		t.Fatalf("expected 202 from resume, got %d", status)
+	}
+	if getJSON(t, srv, "/ingest", &view); view.State != "running" {
+		t.Fatalf("expected running state, got %s", view.State)
+	}
+	if status := post(t, srv, "/ingest/cancel"); status != http.StatusAccepted {
+		t.Fatalf("expected 202 from cancel, got %d", status)
+	}
+
+	select {
+	case res := <-done:
+		if res.State() != pipeline.StateCancelled {
+			t.Fatalf("expected cancelled, got %s", res.State())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected Run to return after cancel")
+	}
+
+	var list []summaryView
+	getJSON(t, srv, "/", &list)
+	if len(list) != 1 || list[0].Name != "ingest" || list[0].State != "cancelled" {
+		t.Fatalf("unexpected list: %+v", list)
+	}
+	if status := post(t, srv, "/ingest/cancel"); status != http.StatusConflict {
+		t.Fatalf("expected 409 for idle pipeline, got %d", status)
+	}
+}
+
+func TestAdminRejectsFormPosts(t *testing.T) {
+	t.Parallel()
+
+	adm := New()
+	srv := httptest.NewServer(adm)
+	defer srv.Close()
+
+	r := pipeline.New("form", blockingSource).
+		Then(func(ctx context.Context, n int) (int, error) { return n, nil }).
+		To(func(ctx context.Context, n int) error { return nil })
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = adm.Run(ctx, r)
+	}()
+	var view detailView
+	for getJSON(t, srv, "/form", &view); view.State != "running"; getJSON(t, srv, "/form", &view) {
+		if ctx.Err() != nil {
+			t.Fatalf("pipeline never started: %+v", view)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	for _, path := range []string{"/form/cancel", "/form/stages/0/concurrency?n=2"} {
+		resp, err := http.PostForm(srv.URL+path, url.Values{"x": {"1"}})
+		if err != nil {
+			t.Fatalf("POST %s: %v", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnsupportedMediaType {
+			t.Fatalf("expected 415 for a form post to %s, got %d", path, resp.StatusCode)
+		}
+	}
+	getJSON(t, srv, "/form", &view)
+	if view.State != "running" || view.Stages[0].Workers != 1 {
+		t.Fatalf("expected the run to be untouched, got %q", view.State)
+	}
+	cancel()
+	<-done
+}
+
+func TestAdminReadOnlyAndRouting(t *testing.T) {
+	t.Parallel()
+
+	adm := New(ReadOnly())
+	srv := httptest.NewServer(adm)
+	defer srv.Close()
+
+	r := pipeline.New("ro", blockingSource).To(func(ctx context.Context, n int) error { return nil })
+	if err := adm.Register(r); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if err := adm.Register(r); err != ErrDuplicate {
+		t.Fatalf("expected ErrDuplicate, got %v", err)
+	}
+
+	var list []summaryView
+	getJSON(t, srv, "/", &list)
+	if len(list) != 1 || list[0].State != "idle" {
+		t.Fatalf("unexpected list: %+v", list)
+	}
+	if status := getJSON(t, srv, "/missing", nil); status != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", status)
+	}
+	if status := post(t, srv, "/ro/cancel"); status != http.StatusForbidden {
+		t.Fatalf("expected 403 in read-only mode, got %d", status)
+	}
+	if status := getJSON(t, srv, "/ro/cancel", nil); status != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405 for GET on an action, got %d", status)
+	}
+}
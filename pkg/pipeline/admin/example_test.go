@@ -0,0 +1,34 @@
+package admin_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/jpconstantineau/data-duct/pkg/pipeline"
+	"github.com/jpconstantineau/data-duct/pkg/pipeline/admin"
+)
+
+func Example() {
+	adm := admin.New() // admin.New(admin.ReadOnly()) disables the actions
+	mux := http.NewServeMux()
+	mux.Handle("/admin/pipelines/", http.StripPrefix("/admin/pipelines", adm))
+
+	src := func(ctx context.Context) (<-chan int, error) {
+		ch := make(chan int, 2)
+		ch <- 1
+		ch <- 2
+		close(ch)
+		return ch, nil
+	}
+	r := pipeline.New("orders", src).To(func(ctx context.Context, n int) error { return nil })
+	// Runs started through the handler can be stopped, paused and resized.
+	_, _ = adm.Run(context.Background(), r)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/pipelines/", nil))
+	fmt.Print(rec.Body.String())
+	// Output:
+	// [{"name":"orders","state":"succeeded"}]
+}
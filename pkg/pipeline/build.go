@@ -0,0 +1,116 @@
+package pipeline
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/jpconstantineau/data-duct/internal/pipelineinternal"
+)
+
+// WithBuildErrors makes the builder record wiring problems instead of
+// panicking, so that Runnable.Build can report them all at once.
+func WithBuildErrors() Option {
+	return func(o *pipelineOptions) {
+		o.buildErrors = true
+	}
+}
+
+// WiringError is a wiring problem of a stage, the sink or the pipeline
+// options.
+type WiringError struct {
+	// Stage is the position of the stage, from 0. The sink comes after the
+	// last stage; pipeline options are at -1.
+	Stage int
+	// Name is the stage name, if it has one.
+	Name string
+	// Sink is set for problems of the sink.
+	Sink bool
+	Err  error
+}
+
+func (e *WiringError) Error() string {
+	var where string
+	switch {
+	case e.Sink:
+		where = "sink"
+	case e.Stage < 0:
+		where = "options"
+	default:
+		where = "stage " + strconv.Itoa(e.Stage) + formatName(e.Name)
+	}
+	return where + ": " + e.Err.Error()
+}
+
+func (e *WiringError) Unwrap() error { return e.Err }
+
+// BuildError lists the wiring problems of a pipeline built with
+// WithBuildErrors, in the order they were found.
+type BuildError struct {
+	Problems []*WiringError
+}
+
+func (e *BuildError) Error() string {
+	if len(e.Problems) == 1 {
+		return "pipeline: " + e.Problems[0].Error()
+	}
+	msgs := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		msgs[i] = p.Error()
+	}
+	return "pipeline: " + strconv.Itoa(len(e.Problems)) + " wiring problems: " + strings.Join(msgs, "; ")
+}
+
+func (e *BuildError) Unwrap() []error {
+	errs := make([]error, len(e.Problems))
+	for i, p := range e.Problems {
+		errs[i] = p
+	}
+	return errs
+}
+
+// Build returns r, or a *BuildError listing every wiring problem recorded
+// while building it (see WithBuildErrors).
+func (r *Runnable) Build() (*Runnable, error) {
+	if r == nil || r.def == nil {
+		return nil, pipelineinternal.ErrInvalidConfig
+	}
+	if err := r.def.buildError(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (d *definition) buildError() error {
+	if len(d.problems) == 0 {
+		return nil
+	}
+	return &BuildError{Problems: d.problems}
+}
+
+// failStage reports a problem of the stage being added.
+func (d *definition) failStage(name string, err error) {
+	d.fail(&WiringError{Stage: len(d.stages), Name: name, Err: err})
+}
+
+func (d *definition) failSink(err error) {
+	d.fail(&WiringError{Stage: len(d.stages), Sink: true, Err: err})
+}
+
+func (d *definition) failOptions(err error) {
+	d.fail(&WiringError{Stage: -1, Err: err})
+}
+
+// fail panics with a wiring problem, or records it with WithBuildErrors.
+func (d *definition) fail(e *WiringError) {
+	if !d.collect {
+		panic("pipeline: " + e.Err.Error())
+	}
+	d.problems = append(d.problems, e)
+}
+
+func formatName(name string) string {
+	if name == "" {
+		return ""
+	}
+	return " (" + name + ")"
+}
@@ -0,0 +1,122 @@
+// Package chaos injects seeded faults (errors, panics, latency and slow
+// drains) into the pipeline stages given its WithFaults option.
+package chaos
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/jpconstantineau/data-duct/pkg/pipeline"
+)
+
+// ErrInjected is the error of a failed call when Faults.Err is nil.
+var ErrInjected = errors.New("chaos: injected fault")
+
+// Faults describes the faults injected into the calls of a stage. Rates are
+// probabilities in [0, 1] drawn per call; the zero value injects nothing.
+type Faults struct {
+	// Seed makes the faults reproducible: the n-th call of the stage meets
+	// the same faults.
+	Seed int64
+
+	// ErrorRate is the probability that a call fails with Err.
+	ErrorRate float64
+	// Err is the error of failed calls; nil means ErrInjected.
+	Err error
+
+	// PanicRate is the probability that a call panics.
+	PanicRate float64
+
+	// LatencyRate is the probability that a call is delayed by a duration
+	// between MinLatency and MaxLatency.
+	LatencyRate            float64
+	MinLatency, MaxLatency time.Duration
+
+	// SlowDrain holds every call of a cancelled run that long, like a
+	// handler that ignores its context.
+	SlowDrain time.Duration
+
+	// Clock times delays; nil means pipeline.SystemClock. It is not taken
+	// from the pipeline, so pass the clock given to WithClock as well.
+	Clock pipeline.Clock
+}
+
+// WithFaults injects f into the calls of a stage's handler, or of the sink
+// when passed to To or ToTx.
+func WithFaults(f Faults) pipeline.StageOption {
+	return pipeline.WithCallWrapper(newInjector(f).wrap)
+}
+
+type injector struct {
+	f Faults
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func newInjector(f Faults) *injector {
+	if f.Err == nil {
+		f.Err = ErrInjected
+	}
+	if f.Clock == nil {
+		f.Clock = pipeline.SystemClock()
+	}
+	if f.MaxLatency < f.MinLatency {
+		f.MaxLatency = f.MinLatency
+	}
+	return &injector{f: f, rng: rand.New(rand.NewPCG(uint64(f.Seed), 0))}
+}
+
+// fault is what happens to one call.
+type fault struct {
+	delay  time.Duration
+	fail   bool
+	panics bool
+}
+
+// draw decides the fault of the next call. It always consumes the same
+// number of random values so that one rate does not shift the others.
+func (in *injector) draw() fault {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	late, lateness, fail, panics := in.rng.Float64(), in.rng.Float64(), in.rng.Float64(), in.rng.Float64()
+
+	var ft fault
+	if late < in.f.LatencyRate {
+		ft.delay = in.f.MinLatency + time.Duration(lateness*float64(in.f.MaxLatency-in.f.MinLatency))
+	}
+	ft.panics = panics < in.f.PanicRate
+	ft.fail = !ft.panics && fail < in.f.ErrorRate
+	return ft
+}
+
+func (in *injector) wrap(next pipeline.Call) pipeline.Call {
+	return func(ctx context.Context, value any) (any, error) {
+		ft := in.draw()
+		if ft.delay > 0 {
+			in.sleep(ctx, ft.delay)
+		}
+		if ctx.Err() != nil && in.f.SlowDrain > 0 {
+			in.sleep(context.Background(), in.f.SlowDrain)
+		}
+		if ft.panics {
+			panic("chaos: injected panic")
+		}
+		if ft.fail {
+			return nil, in.f.Err
+		}
+		return next(ctx, value)
+	}
+}
+
+func (in *injector) sleep(ctx context.Context, d time.Duration) {
+	t := in.f.Clock.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C():
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,155 @@
+package chaos
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jpconstantineau/data-duct/pkg/pipeline"
+	"github.com/jpconstantineau/data-duct/pkg/pipeline/pipelinetest"
+)
+
+func echo(ctx context.Context, v any) (any, error) { return v, nil }
+
+func outcomes(f Faults, n int) []bool {
+	call := newInjector(f).wrap(echo)
+	failed := make([]bool, n)
+	for i := range failed {
+		_, err := call(context.Background(), i)
+		failed[i] = err != nil
+	}
+	return failed
+}
+
+func TestFaultsAreSeeded(t *testing.T) {
+	t.Parallel()
+
+	a := outcomes(Faults{Seed: 42, ErrorRate: 0.3}, 200)
+	if b := outcomes(Faults{Seed: 42, ErrorRate: 0.3}, 200); !slices.Equal(a, b) {
+		t.Fatalf("the same seed injected different faults")
+	}
+	if c := outcomes(Faults{Seed: 43, ErrorRate: 0.3}, 200); slices.Equal(a, c) {
+		t.Fatalf("different seeds injected the same faults")
+	}
+	failures := 0
+	for _, f := range a {
+		if f {
+			failures++
+		}
+	}
+	if failures < 30 || failures > 90 {
+		t.Fatalf("expected about 60 failures out of 200, got %d", failures)
+	}
+
+	// Latency draws do not shift the error draws.
+	if d := outcomes(Faults{Seed: 42, ErrorRate: 0.3, LatencyRate: 1, MaxLatency: time.Nanosecond}, 200); !slices.Equal(a, d) {
+		t.Fatalf("latency changed which calls fail")
+	}
+}
+
+func TestFaultsFailStages(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	always := WithFaults(Faults{ErrorRate: 1})
+	double := func(ctx context.Context, n int) (int, error) { return 2 * n, nil }
+	batch := func(ctx context.Context, in []int) ([]int, error) { return in, nil }
+	var sink pipelinetest.Collector[int]
+
+	r := pipeline.New("single", pipelinetest.FromSlice([]int{1, 2, 3})).Then(double, always).To(sink.Write)
+	res, err := r.Run(ctx)
+	pipelinetest.AssertFailed(t, res, err, ErrInjected)
+
+	r = pipeline.New("batch", pipelinetest.FromSlice([]int{1, 2, 3})).
+		ThenBatch(batch, pipeline.BatchPolicy{Size: 2}, always).To(sink.Write)
+	res, err = r.Run(ctx)
+	pipelinetest.AssertFailed(t, res, err, ErrInjected)
+
+	custom := errors.New("disk full")
+	r = pipeline.New("sink", pipelinetest.FromSlice([]int{1, 2, 3})).
+		To(sink.Write, WithFaults(Faults{ErrorRate: 1, Err: custom}))
+	res, err = r.Run(ctx)
+	pipelinetest.AssertFailed(t, res, err, custom)
+	if sink.Len() != 0 {
+		t.Fatalf("failed calls reached the sink: %v", sink.Items())
+	}
+
+	panics := WithFaults(Faults{PanicRate: 1})
+	for name, r := range map[string]*pipeline.Runnable{
+		"stage": pipeline.New("panic", pipelinetest.FromSlice([]int{1, 2, 3})).Then(double, panics).To(sink.Write),
+		"sink":  pipeline.New("panic", pipelinetest.FromSlice([]int{1, 2, 3})).To(sink.Write, panics),
+		"tx":    pipeline.New("panic", pipelinetest.FromSlice([]int{1, 2, 3})).ToTx(&txSink{}, pipeline.TxPolicy{Size: 2}, panics),
+	} {
+		res, err = r.Run(ctx)
+		pipelinetest.AssertState(t, res, pipeline.StateFailed)
+		if err == nil || !strings.Contains(err.Error(), "chaos: injected panic") {
+			t.Fatalf("%s: expected the injected panic, got %v", name, err)
+		}
+	}
+}
+
+// txSink is a transactional sink that discards its items.
+type txSink struct{}
+
+func (txSink) Begin(ctx context.Context) error        { return nil }
+func (txSink) Write(ctx context.Context, n int) error { return nil }
+func (txSink) Commit(ctx context.Context) error       { return nil }
+func (txSink) Abort(ctx context.Context) error        { return nil }
+
+func TestFaultsWithoutRatesPassThrough(t *testing.T) {
+	t.Parallel()
+
+	var sink pipelinetest.Collector[int]
+	r := pipeline.New("calm", pipelinetest.FromSlice([]int{1, 2, 3})).
+		Then(func(ctx context.Context, n int) (int, error) { return n, nil }, WithFaults(Faults{Seed: 1})).
+		To(sink.Write)
+	res, err := r.Run(context.Background())
+	pipelinetest.AssertSucceeded(t, res, err)
+	if got := sink.Items(); !slices.Equal(got, []int{1, 2, 3}) {
+		t.Fatalf("expected every item, got %v", got)
+	}
+}
+
+func TestLatencyAndSlowDrainFollowClock(t *testing.T) {
+	t.Parallel()
+
+	clock := pipelinetest.NewFakeClock(time.Time{})
+	call := newInjector(Faults{LatencyRate: 1, MinLatency: time.Second, MaxLatency: time.Second, Clock: clock}).wrap(echo)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := call(context.Background(), 1)
+		done <- err
+	}()
+	clock.BlockUntil(1)
+	select {
+	case <-done:
+		t.Fatalf("call returned before its latency")
+	case <-time.After(10 * time.Millisecond):
+	}
+	clock.Advance(time.Second)
+	if err := <-done; err != nil {
+		t.Fatalf("delayed call: %v", err)
+	}
+
+	// A call of a cancelled run holds on for SlowDrain.
+	drain := newInjector(Faults{SlowDrain: time.Minute, Clock: clock}).wrap(echo)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	go func() {
+		_, err := drain(ctx, 2)
+		done <- err
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(59 * time.Second)
+	select {
+	case <-done:
+		t.Fatalf("cancelled call returned before SlowDrain")
+	case <-time.After(10 * time.Millisecond):
+	}
+	clock.Advance(time.Second)
+	<-done
+}
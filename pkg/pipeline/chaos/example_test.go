@@ -0,0 +1,24 @@
+package chaos_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jpconstantineau/data-duct/pkg/pipeline"
+	"github.com/jpconstantineau/data-duct/pkg/pipeline/chaos"
+	"github.com/jpconstantineau/data-duct/pkg/pipeline/pipelinetest"
+)
+
+func Example() {
+	enrich := func(ctx context.Context, n int) (int, error) { return n, nil }
+	store := func(ctx context.Context, n int) error { return nil }
+
+	res, err := pipeline.New("orders", pipelinetest.FromSlice([]int{1, 2, 3, 4, 5, 6, 7, 8})).
+		Then(enrich, chaos.WithFaults(chaos.Faults{Seed: 1, ErrorRate: 0.2})).
+		To(store, chaos.WithFaults(chaos.Faults{Seed: 2, LatencyRate: 0.5, MaxLatency: time.Millisecond})).
+		Run(context.Background())
+	fmt.Println(res.State(), err)
+	// Output:
+	// failed chaos: injected fault
+}
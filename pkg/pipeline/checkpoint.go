@@ -0,0 +1,455 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jpconstantineau/data-duct/internal/pipelineinternal"
+)
+
+// Positioned is a source item together with its position in the source, such
+// as an offset or a file name and line. Positions are opaque to the pipeline;
+// resuming from an item's position must continue with the item after it.
+type Positioned[T any] struct {
+	Value    T
+	Position string
+}
+
+// ResumableSourceFunc is a source that can start after a given position. An
+// empty position means the beginning.
+type ResumableSourceFunc[T any] func(ctx context.Context, after string) (<-chan Positioned[T], error)
+
+type resumeFunc func(ctx context.Context, after string, track func(pos string) func(error)) (<-chan any, error)
+
+// NewResumable creates a pipeline builder for a resumable source. Without
+// WithCheckpoints every run starts from the beginning.
+func NewResumable[T any](name string, source ResumableSourceFunc[T], opts ...Option) *Pipeline {
+	resume := func(ctx context.Context, after string, track func(pos string) func(error)) (<-chan any, error) {
+		ch, err := source(ctx, after)
+		if err != nil {
+			return nil, err
+		}
+		return relay(ctx, ch, func(p Positioned[T]) any {
+			t := pipelineinternal.Tracked{Value: p.Value, Key: p.Position}
+			if track != nil {
+				t.Admit = func() func(error) { return track(p.Position) }
+			}
+			return t
+		}, nil), nil
+	}
+	plain := func(ctx context.Context) (<-chan any, error) { return resume(ctx, "", nil) }
+	return newPipeline(name, typeOf[T](), plain, resume, opts)
+}
+
+// Checkpoint is the saved progress of a pipeline.
+type Checkpoint struct {
+	Pipeline string    `json:"pipeline"`
+	Position string    `json:"position"`
+	Time     time.Time `json:"time"`
+}
+
+// CheckpointStore persists checkpoints. Implementations must be safe for
+// concurrent use by several pipelines.
+type CheckpointStore interface {
+	// Load returns the last checkpoint saved for the pipeline, reporting
+	// false if there is none.
+	Load(ctx context.Context, pipeline string) (Checkpoint, bool, error)
+	Save(ctx context.Context, cp Checkpoint) error
+}
+
+type checkpointPolicy struct {
+	store    CheckpointStore
+	interval time.Duration
+}
+
+// WithCheckpoints saves the low watermark of a resumable source in store
+// every interval and when the run ends, and resumes each run after it.
+func WithCheckpoints(store CheckpointStore, interval time.Duration) Option {
+	return func(o *pipelineOptions) {
+		if store == nil {
+			o.checkpoints = nil
+			return
+		}
+		o.checkpoints = &checkpointPolicy{store: store, interval: interval}
+	}
+}
+
+// checkpointer tracks the watermark of one run and saves it.
+type checkpointer struct {
+	name   string
+	policy checkpointPolicy
+	logger pipelineinternal.Logger
+	clock  pipelineinternal.Clock
+
+	mu sync.Mutex
+	// pending holds the positions not yet part of the watermark, in source
+	// order.
+	pending  []*positionMark
+	position string
+	moved    bool
+	saved    Checkpoint
+	err      error
+	// tracked counts the positions ever tracked and the state changes made
+	// by timers; outputs counts the items emitted by timers still in the
+	// pipeline.
+	tracked      int
+	outputs      int
+	outputFailed bool
+
+	// With stateful stages, states holds their state for the run, labels
+	// their snapshot names, and exec the run once it has started.
+	states     []stateRun
+	labels     []string
+	stateStore StateStore
+	exec       atomic.Pointer[pipelineinternal.Execution]
+	// settled is signalled whenever an item settles.
+	settled chan struct{}
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type positionMark struct {
+	pos     string
+	settled bool
+	failed  bool
+}
+
+func newCheckpointer(name string, policy checkpointPolicy, logger pipelineinternal.Logger, last Checkpoint) *checkpointer {
+	return &checkpointer{
+		name:     name,
+		policy:   policy,
+		logger:   logger,
+		clock:    pipelineinternal.RealClock{},
+		position: last.Position,
+		saved:    last,
+		settled:  make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// track registers the next position in source order and returns the outcome
+// callback of its item.
+func (c *checkpointer) track(pos string) func(error) {
+	m := &positionMark{pos: pos}
+	c.mu.Lock()
+	c.pending = append(c.pending, m)
+	c.tracked++
+	c.mu.Unlock()
+
+	return func(err error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		m.settled = true
+		m.failed = err != nil && !errors.Is(err, ErrDropped)
+		c.notify()
+		for len(c.pending) > 0 && c.pending[0].settled && !c.pending[0].failed {
+			c.position, c.moved = c.pending[0].pos, true
+			c.pending[0] = nil
+			c.pending = c.pending[1:]
+		}
+	}
+}
+
+// emitted registers an item emitted by a timer and returns its outcome
+// callback. Such items have no position, but state snapshots wait for them
+// like for source items.
+func (c *checkpointer) emitted() func(error) {
+	c.mu.Lock()
+	c.outputs++
+	c.tracked++
+	c.mu.Unlock()
+
+	var once sync.Once
+	return func(err error) {
+		once.Do(func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.outputs--
+			if err != nil && !errors.Is(err, ErrDropped) {
+				c.outputFailed = true
+			}
+			c.notify()
+		})
+	}
+}
+
+// notify signals settled without blocking.
+func (c *checkpointer) notify() {
+	select {
+	case c.settled <- struct{}{}:
+	default:
+	}
+}
+
+// changed records that timers changed the state.
+func (c *checkpointer) changed() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tracked++
+	c.moved = true
+}
+
+// run saves the watermark every interval until finish is called.
+func (c *checkpointer) run() {
+	defer close(c.done)
+	var tick <-chan time.Time
+	if c.policy.interval > 0 {
+		t := c.clock.NewTicker(c.policy.interval)
+		defer t.Stop()
+		tick = t.C()
+	}
+	for {
+		select {
+		case <-c.stop:
+			c.save()
+			return
+		case <-tick:
+			c.save()
+		}
+	}
+}
+
+// finish makes the final save once the run is over.
+func (c *checkpointer) finish() {
+	close(c.stop)
+	<-c.done
+}
+
+func (c *checkpointer) save() {
+	if c.stateStore != nil {
+		c.saveState()
+		return
+	}
+	c.mu.Lock()
+	if !c.moved {
+		c.mu.Unlock()
+		return
+	}
+	cp := Checkpoint{Pipeline: c.name, Position: c.position, Time: c.clock.Now()}
+	c.moved = false
+	c.mu.Unlock()
+
+	// The run's context may already be cancelled; the save must still happen.
+	err := c.policy.store.Save(context.Background(), cp)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+	if err != nil {
+		c.moved = true
+		if c.logger != nil {
+			c.logger.Error("pipeline checkpoint save failed", "pipeline", c.name, "error", err)
+		}
+		return
+	}
+	c.saved = cp
+}
+
+// saveState drains the pipeline, then saves a state snapshot and a
+// checkpoint at the same position. Nothing is saved once an item failed.
+func (c *checkpointer) saveState() {
+	c.mu.Lock()
+	moved := c.moved
+	c.mu.Unlock()
+	exec := c.exec.Load()
+	if !moved || exec == nil {
+		return
+	}
+	exec.Hold()
+	defer exec.Unhold()
+
+	var snap StateSnapshot
+	for {
+		tracked, ok := c.quiesce(exec)
+		if !ok {
+			return
+		}
+		stages := make(map[string]map[string][]byte, len(c.labels))
+		timers := make(map[string][]TimerSnapshot)
+		for i, st := range c.states {
+			if st == nil {
+				continue
+			}
+			entries, pending, err := st.snapshot()
+			if err != nil {
+				c.failed(fmt.Errorf("pipeline: snapshot state of stage %s: %w", c.labels[i], err))
+				return
+			}
+			stages[c.labels[i]] = entries
+			if len(pending) > 0 {
+				timers[c.labels[i]] = pending
+			}
+		}
+		c.mu.Lock()
+		if c.tracked != tracked {
+			// An item admitted before the hold took effect may have
+			// reached the state; try again.
+			c.mu.Unlock()
+			continue
+		}
+		snap = StateSnapshot{Pipeline: c.name, Position: c.position, Stages: stages, Timers: timers, Time: c.clock.Now()}
+		c.moved = false
+		c.mu.Unlock()
+		break
+	}
+
+	if err := c.stateStore.Save(context.Background(), snap); err != nil {
+		c.failed(err)
+		return
+	}
+	cp := Checkpoint{Pipeline: c.name, Position: snap.Position, Time: snap.Time}
+	if err := c.policy.store.Save(context.Background(), cp); err != nil {
+		// The snapshot carries its position, so resuming from it is still
+		// consistent.
+		c.failed(err)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.saved, c.err = cp, nil
+}
+
+// quiesce waits until every tracked position and every item emitted by a
+// timer has settled, flushing batches so none is held back. It reports the
+// tracked count by then, or false if any of them failed.
+func (c *checkpointer) quiesce(exec *pipelineinternal.Execution) (int, bool) {
+	exec.Flush()
+	// Items still on their way to a batch stage miss the first flush.
+	reflush := c.clock.NewTicker(quiesceFlushInterval)
+	defer reflush.Stop()
+	stopped := false
+	for {
+		c.mu.Lock()
+		settled, failed := c.outputs == 0, c.outputFailed
+		for _, m := range c.pending {
+			settled = settled && m.settled
+			failed = failed || m.failed
+		}
+		tracked := c.tracked
+		c.mu.Unlock()
+		switch {
+		case failed:
+			return 0, false
+		case settled:
+			return tracked, true
+		case stopped:
+			// The run is over; whatever has not settled never will.
+			return 0, false
+		}
+		select {
+		case <-c.settled:
+		case <-reflush.C():
+			exec.Flush()
+		case <-c.stop:
+			stopped = true
+		}
+	}
+}
+
+// quiesceFlushInterval is how often quiesce flushes batches again.
+const quiesceFlushInterval = 10 * time.Millisecond
+
+// failed records a failed save; the next one tries again.
+func (c *checkpointer) failed(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err, c.moved = err, true
+	if c.logger != nil {
+		c.logger.Error("pipeline checkpoint save failed", "pipeline", c.name, "error", err)
+	}
+}
+
+func (c *checkpointer) last() (Checkpoint, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.saved, c.err
+}
+
+// FileCheckpointStore keeps one JSON file per pipeline in a directory.
+// Files are replaced atomically.
+type FileCheckpointStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileCheckpointStore returns a store writing to dir, which is created on
+// the first save if needed.
+func NewFileCheckpointStore(dir string) *FileCheckpointStore {
+	return &FileCheckpointStore{dir: dir}
+}
+
+func (s *FileCheckpointStore) path(pipeline string) string {
+	return filepath.Join(s.dir, storeFileName(pipeline, ".checkpoint.json"))
+}
+
+// Load implements CheckpointStore.
+func (s *FileCheckpointStore) Load(_ context.Context, pipeline string) (Checkpoint, bool, error) {
+	b, err := os.ReadFile(s.path(pipeline))
+	if errors.Is(err, os.ErrNotExist) {
+		return Checkpoint{}, false, nil
+	}
+	if err != nil {
+		return Checkpoint{}, false, err
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(b, &cp); err != nil {
+		return Checkpoint{}, false, fmt.Errorf("pipeline: checkpoint %s: %w", s.path(pipeline), err)
+	}
+	return cp, true, nil
+}
+
+// Save implements CheckpointStore.
+func (s *FileCheckpointStore) Save(_ context.Context, cp Checkpoint) error {
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeFileAtomic(s.dir, s.path(cp.Pipeline), b)
+}
+
+// storeFileName is the file name of a pipeline's entry in a file store.
+func storeFileName(pipeline, suffix string) string {
+	return url.PathEscape(pipeline) + suffix
+}
+
+// writeFileAtomic replaces path, in dir, with b.
+func writeFileAtomic(dir, path string, b []byte) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
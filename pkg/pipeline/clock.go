@@ -0,0 +1,70 @@
+package pipeline
+
+import (
+	"time"
+
+	"github.com/jpconstantineau/data-duct/internal/pipelineinternal"
+)
+
+// Clock is a source of time; the pipeline uses the system clock unless
+// WithClock substitutes another one.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is a one-shot timer created by a Clock, with the semantics of
+// time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker is a periodic timer created by a Clock, with the semantics of
+// time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// WithClock makes every timeout, interval and timer of the pipeline follow
+// clock, such as a pipelinetest.FakeClock. Traces keep the system clock.
+func WithClock(clock Clock) Option {
+	return func(o *pipelineOptions) {
+		o.clock = clock
+	}
+}
+
+// SystemClock returns the clock of pipelines without WithClock.
+func SystemClock() Clock {
+	return systemClock{}
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) NewTimer(d time.Duration) Timer { return pipelineinternal.RealClock{}.NewTimer(d) }
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return pipelineinternal.RealClock{}.NewTicker(d)
+}
+
+// internalClock adapts clock to the engine, which uses the system clock when
+// clock is nil.
+func internalClock(clock Clock) pipelineinternal.Clock {
+	if clock == nil || clock == SystemClock() {
+		return pipelineinternal.RealClock{}
+	}
+	return clockAdapter{clock}
+}
+
+type clockAdapter struct{ c Clock }
+
+func (a clockAdapter) Now() time.Time { return a.c.Now() }
+
+func (a clockAdapter) NewTimer(d time.Duration) pipelineinternal.Timer { return a.c.NewTimer(d) }
+
+func (a clockAdapter) NewTicker(d time.Duration) pipelineinternal.Ticker { return a.c.NewTicker(d) }
@@ -6,4 +6,5 @@
 //   - Then / ThenBatch: add processors
 //   - To: attach a sink
 //   - Run: execute with a root context
+//   - Start: execute asynchronously and control the run through a Handle
 package pipeline
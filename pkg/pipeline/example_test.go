@@ -1,8 +1,15 @@
 package pipeline
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
 func Example() {
@@ -32,3 +39,403 @@ func Example() {
 	// succeeded
 	// [1 2 3]
 }
+
+func ExampleWithTrace() {
+	rec := NewTraceRecorder()
+	_, _ = New("traced", compileTimeSource([]int{1, 2, 3}), WithTrace(rec, 1)).
+		Then(func(ctx context.Context, n int) (int, error) { return n * 2, nil }, WithStageName("double")).
+		To(func(ctx context.Context, n int) error { return nil }).
+		Run(context.Background())
+
+	var buf bytes.Buffer
+	_, _ = rec.WriteTo(&buf) // open the JSON in Perfetto or chrome://tracing
+	fmt.Println(strings.Count(buf.String(), `"name":"double","cat":"handler"`))
+	// Output:
+	// 3
+}
+
+func ExampleRunnable_Start() {
+	h, err := New("async", endlessSource).
+		To(func(ctx context.Context, n int) error { return nil }).
+		Start(context.Background())
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	h.Cancel()
+	res, _ := h.Wait()
+	fmt.Println(res.State())
+	// Output:
+	// cancelled
+}
+
+func ExampleRunnable_RunGraceful() {
+	// In a service, stop would be the Done channel of
+	// signal.NotifyContext(ctx, syscall.SIGTERM).
+	stop := make(chan struct{})
+	close(stop)
+
+	res, err := New("graceful", endlessSource).
+		To(func(ctx context.Context, n int) error { return nil }).
+		RunGraceful(context.Background(), stop, 25*time.Second)
+	fmt.Println(res.State(), err)
+	// Output:
+	// stopped <nil>
+}
+
+func ExampleRunWithSignals() {
+	r := New("service", endlessSource).
+		To(func(ctx context.Context, n int) error { return nil })
+
+	res, err := RunWithSignals(context.Background(), r, SignalPolicy{
+		DrainTimeout:  25 * time.Second, // cancel if draining takes longer
+		FlushOnHangup: true,             // SIGHUP flushes partial batches
+	})
+	fmt.Println(res.State(), err)
+}
+
+func ExampleHandle_Pause() {
+	h, _ := New("paused", endlessSource).
+		To(func(ctx context.Context, n int) error { return nil }).
+		Start(context.Background())
+
+	h.Pause() // the source sees backpressure; stages keep running
+	fmt.Println(h.State())
+	h.Resume()
+	fmt.Println(h.State())
+	h.Cancel()
+	_, _ = h.Wait()
+	// Output:
+	// paused
+	// running
+}
+
+func ExampleHandle_SetConcurrency() {
+	h, _ := New("scaled", endlessSource).
+		Then(func(ctx context.Context, n int) (int, error) { return n, nil }, WithStageAutoscale(1, 8)).
+		To(func(ctx context.Context, n int) error { return nil }).
+		Start(context.Background())
+
+	n, err := h.SetConcurrency(0, 16) // clamped to the autoscale bounds
+	fmt.Println(n, err)
+	h.Cancel()
+	_, _ = h.Wait()
+	// Output:
+	// 8 <nil>
+}
+
+func ExampleWithStageLimiter() {
+	callPricing := func(ctx context.Context, n int) (int, error) {
+		if n%10 == 0 {
+			ReportOverload(ctx) // the backend answered 429; back off without failing
+		}
+		return n, nil
+	}
+
+	res, _ := New("pricing", compileTimeSource([]int{10, 11, 12})).
+		Then(callPricing, WithStageLimiter(Limiter{
+			Algorithm: LimitGradient,
+			Min:       2,
+			Max:       64,
+			Timeout:   500 * time.Millisecond, // slower calls count as drops
+		})).
+		To(func(ctx context.Context, n int) error { return nil }).
+		Run(context.Background())
+	fmt.Println(res.State())
+	// Output:
+	// succeeded
+}
+
+func ExampleNewRateLimiter() {
+	partner := NewRateLimiter(50, 10) // 50 calls/s across both stages
+	push := func(ctx context.Context, n int) (int, error) { return n, nil }
+
+	res, _ := New("sync", compileTimeSource([]int{1, 2, 3})).
+		Throttle(200, 20).                        // pass-through stage
+		Then(push, WithRateLimit(20, 5)).         // per-stage quota
+		Then(push, WithSharedRateLimit(partner)). // shared quota
+		ThenBatch(func(ctx context.Context, in []int) ([]int, error) { return in, nil },
+			BatchPolicy{Size: 3}, WithSharedRateLimit(partner)).
+		To(func(ctx context.Context, n int) error { return nil }).
+		Run(context.Background())
+	fmt.Println(res.State())
+	// Output:
+	// succeeded
+}
+
+func ExampleWithOnDrop() {
+	var stale atomic.Int64
+	r := New("sensors", endlessSource,
+		WithBuffer(64, OverflowDropOldest),
+		WithOnDrop(func(stage string, item any) { stale.Add(1) })).
+		Then(func(ctx context.Context, n int) (int, error) { return n, nil },
+			WithStageBuffer(16, OverflowSample(10))).
+		To(func(ctx context.Context, n int) error { return nil })
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, _ = r.Run(ctx)
+	fmt.Println(stale.Load(), r.Stats().Dropped)
+}
+
+func ExampleWithStageSpill() {
+	dir, _ := os.MkdirTemp("", "spill")
+	defer os.RemoveAll(dir)
+
+	total := 0
+	res, _ := New("events", compileTimeSource([]int{1, 2, 3, 4, 5})).
+		Then(func(ctx context.Context, n int) (int, error) { return n, nil },
+			WithStageBuffer(1),
+			WithStageSpill(SpillPolicy{Dir: dir, MaxBytes: 1 << 20}, JSONCodec[int]())).
+		To(func(ctx context.Context, n int) error {
+			total += n
+			return nil
+		}).
+		Run(context.Background())
+	fmt.Println(res.State(), total)
+	// Output:
+	// succeeded 15
+}
+
+func ExampleWithMaxInFlight() {
+	words := []string{"memory", "stays", "bounded", "whatever", "the", "buffers"}
+	var written []string
+	res, _ := New("ingest", compileTimeSource(words),
+		WithMaxInFlight(4),
+		WithMaxInFlightBytes(16, func(s string) int64 { return int64(len(s)) })).
+		ThenBatch(func(ctx context.Context, in []string) ([]string, error) { return in, nil },
+			BatchPolicy{Size: 2}). // at most the in-flight limit, or set a MaxWait
+		To(func(ctx context.Context, s string) error {
+			written = append(written, s)
+			return nil
+		}).
+		Run(context.Background())
+	fmt.Println(res.State(), written)
+	// Output:
+	// succeeded [memory stays bounded whatever the buffers]
+}
+
+func ExampleWithPriority() {
+	type alert struct {
+		Severity int
+		Text     string
+	}
+	r := New("alerts", compileTimeSource([]alert{{1, "disk 70%"}, {3, "node down"}}),
+		WithPriority(func(a alert) int { return a.Severity }), // higher goes first
+		WithPriorityAging(time.Minute)).
+		To(func(ctx context.Context, a alert) error { return nil })
+	d := r.Describe()
+	fmt.Println(d.Prioritized, d.PriorityAging)
+	// Output:
+	// true 1m0s
+}
+
+func ExampleNewAcked() {
+	src := func(ctx context.Context) (<-chan Message[int], error) {
+		ch := make(chan Message[int], 1)
+		ch <- Message[int]{
+			Value: 1,
+			Ack:   func() { fmt.Println("ack") },
+			Nack:  func(err error) { fmt.Println("requeue:", err) },
+		}
+		close(ch)
+		return ch, nil
+	}
+
+	_, _ = NewAcked("jobs", src).
+		To(func(ctx context.Context, n int) error { return nil }).
+		Run(context.Background())
+	// Output:
+	// ack
+}
+
+func ExampleWithCheckpoints() {
+	dir, _ := os.MkdirTemp("", "checkpoints")
+	defer os.RemoveAll(dir)
+
+	rows := []string{"a", "b", "c", "d"}
+	src := func(ctx context.Context, after string) (<-chan Positioned[string], error) {
+		fmt.Printf("reading after %q\n", after)
+		ch := make(chan Positioned[string], len(rows))
+		for i, row := range rows {
+			if pos := strconv.Itoa(i + 1); pos > after {
+				ch <- Positioned[string]{Value: row, Position: pos}
+			}
+		}
+		close(ch)
+		return ch, nil
+	}
+
+	broken := true
+	r := NewResumable("backfill", src, WithCheckpoints(NewFileCheckpointStore(dir), 10*time.Second)).
+		To(func(ctx context.Context, row string) error {
+			if row == "c" && broken {
+				return errors.New("warehouse unavailable")
+			}
+			return nil
+		})
+	_, err := r.Run(context.Background())
+	fmt.Println(err)
+	broken = false
+	res, _ := r.Run(context.Background())
+	fmt.Println(res.State())
+	// Output:
+	// reading after ""
+	// warehouse unavailable
+	// reading after "2"
+	// succeeded
+}
+
+// ledgerWriter prints the transactions the pipeline drives.
+type ledgerWriter struct{}
+
+func (ledgerWriter) Begin(ctx context.Context) error { fmt.Println("begin"); return nil }
+
+func (ledgerWriter) Write(ctx context.Context, entry int) error {
+	key, _ := IdempotencyKey(ctx)
+	fmt.Println("write", entry, "key", key)
+	return nil
+}
+
+func (ledgerWriter) Commit(ctx context.Context) error { fmt.Println("commit"); return nil }
+func (ledgerWriter) Abort(ctx context.Context) error  { fmt.Println("abort"); return nil }
+
+func ExamplePipeline_ToTx() {
+	entries := func(ctx context.Context, after string) (<-chan Positioned[int], error) {
+		ch := make(chan Positioned[int], 3)
+		for i, amount := range []int{100, -40, 25} {
+			ch <- Positioned[int]{Value: amount, Position: fmt.Sprintf("entry-%d", i+1)}
+		}
+		close(ch)
+		return ch, nil
+	}
+
+	_, _ = NewResumable("ledger", entries).
+		ToTx(ledgerWriter{}, TxPolicy{Size: 2}).
+		Run(context.Background())
+	// Output:
+	// begin
+	// write 100 key entry-1
+	// write -40 key entry-2
+	// commit
+	// begin
+	// write 25 key entry-3
+	// commit
+}
+
+func ExampleStatefulThen() {
+	type reading struct {
+		Sensor string
+		Value  int
+	}
+	readings := []reading{{"a", 1}, {"b", 10}, {"a", 2}, {"b", 20}, {"a", 3}}
+
+	p := New("totals", compileTimeSource(readings))
+	p = StatefulThen(p, func(r reading) string { return r.Sensor },
+		func(ctx context.Context, st KeyState[string, int], r reading) (string, error) {
+			sum, _ := st.Get()
+			st.Set(sum + r.Value)
+			return fmt.Sprintf("%s=%d", r.Sensor, sum+r.Value), nil
+		}, WithStageName("totals"))
+
+	var totals []string
+	_, _ = p.To(func(ctx context.Context, total string) error {
+		totals = append(totals, total)
+		return nil
+	}).Run(context.Background())
+	fmt.Println(totals)
+	// Output:
+	// [a=1 b=10 a=3 b=30 a=6]
+}
+
+func ExampleWithTimers() {
+	type reading struct {
+		Sensor string
+		At     time.Duration // since the start of the day
+	}
+	readings := []reading{{"a", 0}, {"b", time.Minute}, {"b", 7 * time.Minute}}
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	p := New("silence", compileTimeSource(readings))
+	p = StatefulThen(p, func(r reading) string { return r.Sensor },
+		func(ctx context.Context, st KeyState[string, bool], r reading) (string, error) {
+			st.SetTimer("silence", EventTime, day.Add(r.At+5*time.Minute))
+			return r.Sensor + " reported", nil
+		},
+		WithStageName("silence"),
+		WithEventTime(func(r reading) time.Time { return day.Add(r.At) }, 0),
+		WithTimers(func(ctx context.Context, st KeyState[string, bool], t KeyTimer) ([]string, error) {
+			return []string{st.Key() + " went quiet"}, nil
+		}))
+
+	_, _ = p.To(func(ctx context.Context, event string) error {
+		fmt.Println(event)
+		return nil
+	}).Run(context.Background())
+	// Output:
+	// a reported
+	// b reported
+	// b reported
+	// a went quiet
+}
+
+func ExampleNewTyped() {
+	orders := NewTyped("orders", compileTimeSource([]int{120, 80, 45}))
+	priced := Then(orders, func(ctx context.Context, cents int) (string, error) {
+		return fmt.Sprintf("$%d.%02d", cents/100, cents%100), nil
+	})
+	res, err := To(priced, func(ctx context.Context, price string) error {
+		fmt.Println(price)
+		return nil
+	}).Run(context.Background())
+	fmt.Println(res.State(), err)
+	// Output:
+	// $1.20
+	// $0.80
+	// $0.45
+	// succeeded <nil>
+}
+
+func ExampleRunnable_Build() {
+	handlers := map[string]any{
+		"parse": func(ctx context.Context, s string) (int, error) { return strconv.Atoi(s) },
+	}
+
+	_, err := New("orders", compileTimeSource([]string{"1", "2"}), WithBuildErrors()).
+		Then(handlers["decode"], WithStageName("parse")). // not registered
+		ThenBatch(func(ctx context.Context, ns []int) ([]int, error) { return ns, nil }, BatchPolicy{Size: 0}).
+		To(func(ctx context.Context, n int) error { return nil }).
+		Build()
+	fmt.Println(err)
+	// Output:
+	// pipeline: 2 wiring problems: stage 0 (parse): handler must not be nil; stage 1: batch size must be >= 1
+}
+
+// fileStore stands in for a sink that holds a file open while its stage runs.
+type fileStore struct{ log *strings.Builder }
+
+func (s *fileStore) Open(ctx context.Context) error {
+	s.log = new(strings.Builder)
+	fmt.Println("open")
+	return nil
+}
+
+func (s *fileStore) Write(ctx context.Context, line string) error {
+	s.log.WriteString(line + "\n")
+	return nil
+}
+
+func (s *fileStore) Close(ctx context.Context) error {
+	fmt.Printf("close after %d bytes\n", s.log.Len())
+	return nil
+}
+
+func ExampleToSink() {
+	lines := NewTyped("lines", compileTimeSource([]string{"a", "bb", "ccc"}))
+	res, err := ToSink(lines, Sink[string](&fileStore{})).Run(context.Background())
+	fmt.Println(res.State(), err)
+	// Output:
+	// open
+	// close after 9 bytes
+	// succeeded <nil>
+}
@@ -0,0 +1,241 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jpconstantineau/data-duct/internal/pipelineinternal"
+)
+
+// ErrDrainTimeout is the cause reported when a graceful stop did not finish
+// draining in-flight items before its deadline.
+var ErrDrainTimeout = pipelineinternal.ErrDrainTimeout
+
+var (
+	// ErrStageNotFound is returned by Handle.SetConcurrency for an index that
+	// does not name a processing stage.
+	ErrStageNotFound = pipelineinternal.ErrStageNotFound
+	// ErrNotScalable is returned by Handle.SetConcurrency for a stage whose
+	// worker count is fixed, such as a batch stage.
+	ErrNotScalable = pipelineinternal.ErrNotScalable
+)
+
+// Phase is the lifecycle phase of a started run.
+type Phase string
+
+const (
+	PhaseStarting Phase = "starting"
+	PhaseRunning  Phase = "running"
+	PhasePaused   Phase = "paused"
+	PhaseDraining Phase = "draining"
+	PhaseStopped  Phase = "stopped"
+)
+
+// Handle controls a run started with Runnable.Start.
+type Handle struct {
+	def   *definition
+	exec  *pipelineinternal.Execution
+	stats *pipelineinternal.Stats
+	ckpt  *checkpointer
+}
+
+// Start launches the pipeline without waiting for it; a source error is
+// returned here rather than through Wait.
+func (r *Runnable) Start(ctx context.Context) (*Handle, error) {
+	if r == nil || r.def == nil {
+		return nil, pipelineinternal.ErrInvalidConfig
+	}
+	if err := r.def.buildError(); err != nil {
+		return nil, err
+	}
+	if r.def.source == nil || r.def.sink == nil {
+		return nil, pipelineinternal.ErrInvalidConfig
+	}
+
+	source, states, ckpt, err := r.prepare(ctx)
+	if err != nil {
+		return nil, err
+	}
+	onDone := func() { r.active.Add(-1) }
+	if ckpt != nil {
+		go ckpt.run()
+		onDone = func() {
+			ckpt.finish()
+			r.active.Add(-1)
+		}
+	}
+
+	stats := pipelineinternal.NewStats(len(r.def.stages))
+	r.stats.Store(stats)
+	r.active.Add(1)
+
+	exec, err := pipelineinternal.Start(
+		ctx,
+		r.def.name,
+		source,
+		toInternalStages(r.def.stages, states),
+		r.def.sink,
+		pipelineinternal.Config{
+			DefaultBuffer: r.def.buffer,
+			Overflow:      r.def.overflow.internal(),
+			OnDrop:        r.def.onDrop,
+			Logger:        r.def.logger,
+			Tracer:        r.def.tracer,
+			TraceRate:     r.def.traceRate,
+			Stats:         stats,
+			OnDone:        onDone,
+
+			SuspendBatchTimers: r.def.suspendTimers,
+			MaxInFlight:        r.def.maxInFlight,
+			MaxInFlightBytes:   r.def.maxInFlightBytes,
+			Sizer:              r.def.sizer,
+			Priority:           r.def.priority,
+			Tx:                 r.def.tx,
+			Key:                r.def.key,
+			Clock:              r.def.clock,
+			Scheduler:          r.def.scheduler,
+			SinkLifecycle:      r.def.sinkLife,
+		},
+	)
+	if err != nil {
+		if ckpt != nil {
+			ckpt.finish()
+		}
+		r.active.Add(-1)
+		return nil, err
+	}
+	if ckpt != nil {
+		ckpt.exec.Store(exec)
+	}
+	return &Handle{def: r.def, exec: exec, stats: stats, ckpt: ckpt}, nil
+}
+
+// prepare returns the source and the state of stateful stages for a new run.
+// With checkpoints it resumes after the last saved position, restoring the
+// matching state snapshot, and tracks the run's watermark.
+func (r *Runnable) prepare(ctx context.Context) (pipelineinternal.Source, []stateRun, *checkpointer, error) {
+	states := r.def.newStates()
+	policy := r.def.checkpoints
+	if policy == nil {
+		return r.def.source, states, nil, nil
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	last, _, err := policy.store.Load(ctx, r.def.name)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("pipeline: load checkpoint: %w", err)
+	}
+	ckpt := newCheckpointer(r.def.name, *policy, r.def.logger, last)
+	ckpt.clock = r.def.clock
+	if store := r.def.stateStore; store != nil && states != nil {
+		snap, ok, err := store.Load(ctx, r.def.name)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("pipeline: load state: %w", err)
+		}
+		ckpt.states, ckpt.stateStore = states, store
+		ckpt.labels = make([]string, len(states))
+		for i, st := range states {
+			if st == nil {
+				continue
+			}
+			ckpt.labels[i] = stateLabel(i, r.def.stages[i])
+			st.bind(ckpt.emitted, ckpt.changed)
+			if !ok {
+				continue
+			}
+			if err := st.restore(snap.Stages[ckpt.labels[i]], snap.Timers[ckpt.labels[i]]); err != nil {
+				return nil, nil, nil, fmt.Errorf("pipeline: restore state of stage %s: %w", ckpt.labels[i], err)
+			}
+		}
+		if ok {
+			// The snapshot, not the checkpoint, says where the state stands.
+			last.Position = snap.Position
+			ckpt.position = snap.Position
+		} else {
+			// State starts empty, so the run must start from the beginning.
+			last.Position, ckpt.position = "", ""
+		}
+	}
+	return func(ctx context.Context) (<-chan any, error) {
+		return r.def.resume(ctx, last.Position, ckpt.track)
+	}, states, ckpt, nil
+}
+
+// Checkpoint returns the last checkpoint saved by the run (or loaded when it
+// started) and the error of the most recent save attempt, if it failed. It
+// returns a zero Checkpoint without WithCheckpoints.
+func (h *Handle) Checkpoint() (Checkpoint, error) {
+	if h.ckpt == nil {
+		return Checkpoint{}, nil
+	}
+	return h.ckpt.last()
+}
+
+// Done is closed once the run has finished and all its goroutines have exited.
+func (h *Handle) Done() <-chan struct{} {
+	return h.exec.Done()
+}
+
+// Wait blocks until the run finishes and returns its result.
+func (h *Handle) Wait() (Result, error) {
+	state, cause := h.exec.Wait()
+	if state == pipelineinternal.StateStopped {
+		completed, abandoned := h.exec.Drained()
+		return Stopped{Cause: cause, Completed: completed, Abandoned: abandoned}, cause
+	}
+	return toResult(state, cause)
+}
+
+// Stop stops the source and lets admitted items drain to the sink. If the
+// drain outlasts a positive drainTimeout, the run is cancelled with
+// ErrDrainTimeout. Stop does not block.
+func (h *Handle) Stop(drainTimeout time.Duration) {
+	h.exec.Stop(drainTimeout)
+}
+
+// Pause stops reading the source while admitted items keep flowing to the
+// sink. It only has an effect while running.
+func (h *Handle) Pause() {
+	h.exec.Pause()
+}
+
+// Resume resumes intake after Pause.
+func (h *Handle) Resume() {
+	h.exec.Resume()
+}
+
+// SetConcurrency resizes a single-item stage (its Describe index) to n workers,
+// clamped to its autoscale bounds, and returns the resulting count.
+func (h *Handle) SetConcurrency(stage, n int) (int, error) {
+	return h.exec.SetConcurrency(stage, n)
+}
+
+// Cancel stops the run immediately, abandoning in-flight items. Wait reports a
+// Cancelled result. Cancel does not block.
+func (h *Handle) Cancel() {
+	h.exec.Cancel(nil)
+}
+
+// State reports the current lifecycle phase. Stop from a paused run moves it
+// to draining.
+func (h *Handle) State() Phase {
+	switch h.exec.Phase() {
+	case pipelineinternal.PhaseStarting:
+		return PhaseStarting
+	case pipelineinternal.PhaseRunning:
+		return PhaseRunning
+	case pipelineinternal.PhasePaused:
+		return PhasePaused
+	case pipelineinternal.PhaseDraining:
+		return PhaseDraining
+	default:
+		return PhaseStopped
+	}
+}
+
+// Stats returns a snapshot of this run's counters.
+func (h *Handle) Stats() Stats {
+	return snapshotStats(h.def, h.stats, h.State() != PhaseStopped)
+}
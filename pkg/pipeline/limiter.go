@@ -0,0 +1,80 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jpconstantineau/data-duct/internal/pipelineinternal"
+)
+
+// LimitAlgorithm selects how an adaptive limiter adjusts its limit.
+type LimitAlgorithm string
+
+const (
+	// LimitAIMD adds one to the limit while it is used and cuts it by 10% on
+	// every drop.
+	LimitAIMD LimitAlgorithm = "aimd"
+	// LimitGradient grows the limit while latency stays at its long-term
+	// average and shrinks it as latency rises.
+	LimitGradient LimitAlgorithm = "gradient"
+)
+
+// Limiter configures adaptive concurrency limiting for a stage that calls a
+// remote service. A drop is a ReportOverload call or a slow call; a handler
+// error fails the run instead, so report recoverable overloads explicitly.
+type Limiter struct {
+	// Algorithm defaults to LimitAIMD. Other values fail the build.
+	Algorithm LimitAlgorithm
+	// Initial is the starting limit; it defaults to Min.
+	Initial int
+	// Min and Max bound the limit. They default to 1 and 64.
+	Min int
+	Max int
+	// Timeout, if positive, counts slower calls as drops.
+	Timeout time.Duration
+}
+
+// WithStageLimiter adds an adaptive concurrency limiter to a single-item
+// stage, which runs at least l.Max workers.
+func WithStageLimiter(l Limiter) StageOption {
+	if l.Algorithm == "" {
+		l.Algorithm = LimitAIMD
+	}
+	if l.Min < 1 {
+		l.Min = 1
+	}
+	if l.Max < 1 {
+		l.Max = 64
+	}
+	if l.Max < l.Min {
+		l.Max = l.Min
+	}
+	if l.Initial < l.Min || l.Initial > l.Max {
+		l.Initial = l.Min
+	}
+	return func(o *stageOptions) {
+		o.limiter = &l
+	}
+}
+
+// ReportOverload counts the handler call of ctx as a drop without failing the
+// run, for example after an HTTP 429. Outside a limited stage it does nothing.
+func ReportOverload(ctx context.Context) {
+	pipelineinternal.ReportOverload(ctx)
+}
+
+// checkLimiter rejects a limiter algorithm this package does not know.
+func (p *Pipeline) checkLimiter(so stageOptions) {
+	if l := so.limiter; l != nil && l.Algorithm != LimitAIMD && l.Algorithm != LimitGradient {
+		p.def.failStage(so.name, fmt.Errorf("unknown limiter algorithm %q", l.Algorithm))
+	}
+}
+
+func toLimiterConfig(l *Limiter) *pipelineinternal.LimiterConfig {
+	cfg := &pipelineinternal.LimiterConfig{Algorithm: pipelineinternal.LimitAIMD, Initial: l.Initial, Min: l.Min, Max: l.Max, Timeout: l.Timeout}
+	if l.Algorithm == LimitGradient {
+		cfg.Algorithm = pipelineinternal.LimitGradient
+	}
+	return cfg
+}
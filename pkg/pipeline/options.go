@@ -1,20 +1,61 @@
 package pipeline
 
-import "log/slog"
+import (
+	"fmt"
+	"log/slog"
+	"reflect"
+	"time"
+)
 
 type Option func(*pipelineOptions)
 
 type StageOption func(*stageOptions)
 
 type pipelineOptions struct {
-	buffer int
-	logger *slog.Logger
+	buffer    int
+	overflow  OverflowPolicy
+	onDrop    func(stage string, item any)
+	logger    *slog.Logger
+	trace     *TraceRecorder
+	traceRate float64
+
+	suspendTimers bool
+
+	maxInFlight      int64
+	maxInFlightBytes int64
+	sizer            func(any) int64
+	sizerType        reflect.Type
+
+	priority      func(any) int
+	priorityType  reflect.Type
+	priorityAging time.Duration
+
+	checkpoints *checkpointPolicy
+	stateStore  StateStore
+
+	key     func(any) string
+	keyType reflect.Type
+
+	clock     Clock
+	scheduler Scheduler
+
+	buildErrors bool
 }
 
 type stageOptions struct {
 	buffer      int
+	overflow    OverflowPolicy
 	concurrency int
 	name        string
+	autoscale   *AutoscalePolicy
+	limiter     *Limiter
+	rate        *RateLimiter
+	spill       *spillDef
+	stateCodec  any
+	onTimer     any
+	eventTime   any
+	lateness    time.Duration
+	wrap        []func(Call) Call
 }
 
 func defaultPipelineOptions() pipelineOptions {
@@ -25,13 +66,57 @@ func defaultStageOptions() stageOptions {
 	return stageOptions{buffer: 0, concurrency: 1, name: ""}
 }
 
-// WithBuffer sets the default inter-stage buffer size.
-func WithBuffer(n int) Option {
+// WithBuffer sets the default inter-stage buffer size and, optionally, the
+// default overflow policy of every queue (OverflowBlock if omitted). It also
+// applies to the queue between the source and the first stage.
+func WithBuffer(n int, overflow ...OverflowPolicy) Option {
 	return func(o *pipelineOptions) {
 		if n < 0 {
 			n = 0
 		}
 		o.buffer = n
+		if len(overflow) > 0 {
+			o.overflow = overflow[0]
+		}
+	}
+}
+
+// WithMaxInFlight stops reading the source while that many source items, with
+// everything derived from them, are inside the pipeline. Batches larger than
+// items need a MaxWait.
+func WithMaxInFlight(items int) Option {
+	return func(o *pipelineOptions) {
+		o.maxInFlight = int64(max(0, items))
+	}
+}
+
+// checkInFlight reports batch stages, and the transactional sink tx if set,
+// that cannot fill within the in-flight limit and have no MaxWait to flush.
+func (p *Pipeline) checkInFlight(tx *TxPolicy) {
+	limit := int(p.def.maxInFlight)
+	if limit == 0 {
+		return
+	}
+	for i, s := range p.def.stages {
+		if s.kind == stageBatch && s.batchPolicy.MaxWait <= 0 && s.batchPolicy.Size > limit {
+			p.def.fail(&WiringError{Stage: i, Name: s.name, Err: fmt.Errorf("batch size %d exceeds the in-flight limit %d without a MaxWait", s.batchPolicy.Size, limit)})
+		}
+	}
+	if tx != nil && tx.MaxWait <= 0 && tx.Size > limit {
+		p.def.failSink(fmt.Errorf("transaction size %d exceeds the in-flight limit %d without a MaxWait", tx.Size, limit))
+	}
+}
+
+// WithMaxInFlightBytes bounds the total size, measured by sizer on admission,
+// of the source items inside the pipeline. T must be the source item type.
+func WithMaxInFlightBytes[T any](n int64, sizer func(T) int64) Option {
+	return func(o *pipelineOptions) {
+		o.maxInFlightBytes = max(0, n)
+		o.sizerType = typeOf[T]()
+		o.sizer = func(v any) int64 {
+			t, _ := v.(T)
+			return sizer(t)
+		}
 	}
 }
 
@@ -42,13 +127,43 @@ func WithLogger(logger *slog.Logger) Option {
 	}
 }
 
-// WithStageBuffer sets the buffer size between this stage and the next.
-func WithStageBuffer(n int) StageOption {
+// WithTrace records per-item stage spans into rec for a fraction of the items
+// admitted from the source. sampleRate is clamped to [0, 1]; 0 disables tracing
+// and 1 traces every item. Sampling is deterministic and evenly spaced. rec
+// keeps only its latest events (see NewTraceRecorderSize).
+func WithTrace(rec *TraceRecorder, sampleRate float64) Option {
+	return func(o *pipelineOptions) {
+		if sampleRate < 0 {
+			sampleRate = 0
+		}
+		if sampleRate > 1 {
+			sampleRate = 1
+		}
+		o.trace = rec
+		o.traceRate = sampleRate
+	}
+}
+
+// WithSuspendBatchTimers suspends batch MaxWait timers while the run is
+// paused, so partial batches are kept until Resume.
+func WithSuspendBatchTimers() Option {
+	return func(o *pipelineOptions) {
+		o.suspendTimers = true
+	}
+}
+
+// WithStageBuffer sets the buffer size between this stage and the next and,
+// optionally, what happens when that buffer is full. Without a policy the
+// pipeline default from WithBuffer applies.
+func WithStageBuffer(n int, overflow ...OverflowPolicy) StageOption {
 	return func(o *stageOptions) {
 		if n < 0 {
 			n = 0
 		}
 		o.buffer = n
+		if len(overflow) > 0 {
+			o.overflow = overflow[0]
+		}
 	}
 }
 
@@ -62,6 +177,26 @@ func WithStageConcurrency(n int) StageOption {
 	}
 }
 
+// AutoscalePolicy bounds the worker count of an autoscaled stage.
+type AutoscalePolicy struct {
+	Min int
+	Max int
+}
+
+// WithStageAutoscale sizes a single-item stage's worker pool between min and
+// max, following its input queue.
+func WithStageAutoscale(min, max int) StageOption {
+	return func(o *stageOptions) {
+		if min < 1 {
+			min = 1
+		}
+		if max < min {
+			max = min
+		}
+		o.autoscale = &AutoscalePolicy{Min: min, Max: max}
+	}
+}
+
 // WithStageName labels a stage (primarily for logging).
 func WithStageName(name string) StageOption {
 	return func(o *stageOptions) {
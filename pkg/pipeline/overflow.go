@@ -0,0 +1,59 @@
+package pipeline
+
+import (
+	"strconv"
+
+	"github.com/jpconstantineau/data-duct/internal/pipelineinternal"
+)
+
+// OverflowPolicy decides what happens to an item emitted into a full queue.
+// Pass one to WithBuffer or WithStageBuffer. The zero value is OverflowBlock.
+type OverflowPolicy struct {
+	kind  pipelineinternal.OverflowKind
+	every int
+}
+
+var (
+	// OverflowBlock waits for space, pushing backpressure upstream.
+	OverflowBlock = OverflowPolicy{}
+	// OverflowDropNewest discards the item that did not fit.
+	OverflowDropNewest = OverflowPolicy{kind: pipelineinternal.OverflowDropNewest}
+	// OverflowDropOldest evicts the oldest queued item to make room; an
+	// unbuffered queue drops the newest instead.
+	OverflowDropOldest = OverflowPolicy{kind: pipelineinternal.OverflowDropOldest}
+)
+
+// OverflowSample keeps one in every n items that arrive while the queue is
+// full, waiting for space for that item, and discards the others.
+func OverflowSample(n int) OverflowPolicy {
+	if n < 1 {
+		n = 1
+	}
+	return OverflowPolicy{kind: pipelineinternal.OverflowSample, every: n}
+}
+
+// String returns "block", "drop-newest", "drop-oldest" or "sample(n)".
+func (p OverflowPolicy) String() string {
+	switch p.kind {
+	case pipelineinternal.OverflowDropNewest:
+		return "drop-newest"
+	case pipelineinternal.OverflowDropOldest:
+		return "drop-oldest"
+	case pipelineinternal.OverflowSample:
+		return "sample(" + strconv.Itoa(p.every) + ")"
+	default:
+		return "block"
+	}
+}
+
+// WithOnDrop calls fn, on the emitting goroutine, with every item discarded
+// by an overflow policy and the name of the stage that emitted it.
+func WithOnDrop(fn func(stage string, item any)) Option {
+	return func(o *pipelineOptions) {
+		o.onDrop = fn
+	}
+}
+
+func (p OverflowPolicy) internal() pipelineinternal.Overflow {
+	return pipelineinternal.Overflow{Kind: p.kind, Every: p.every}
+}
@@ -2,6 +2,7 @@ package pipeline
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"reflect"
 
@@ -13,27 +14,67 @@ type stageKind int
 const (
 	stageSingle stageKind = iota
 	stageBatch
+	stageKeyed
 )
 
 type stageDef struct {
 	kind        stageKind
 	name        string
 	buffer      int
+	overflow    OverflowPolicy
 	concurrency int
+	autoscale   *AutoscalePolicy
+	limiter     *Limiter
+	rate        *RateLimiter
+	spill       *spillDef
 
 	single      pipelineinternal.SingleHandler
 	batch       pipelineinternal.BatchHandler
 	batchPolicy pipelineinternal.BatchPolicy
+	state       *stateDef
+	// wrap are the call wrappers of the stage (see WithCallWrapper).
+	wrap []func(Call) Call
+	// life, if set, opens and closes the stage's processor.
+	life *pipelineinternal.Lifecycle
 }
 
 type definition struct {
-	name   string
-	buffer int
-	logger pipelineinternal.Logger
+	name      string
+	buffer    int
+	overflow  OverflowPolicy
+	onDrop    func(stage string, item any)
+	logger    pipelineinternal.Logger
+	tracer    *pipelineinternal.Tracer
+	traceRate float64
+
+	suspendTimers bool
+
+	maxInFlight      int64
+	maxInFlightBytes int64
+	sizer            func(any) int64
+	priority         *pipelineinternal.PriorityConfig
 
 	source pipelineinternal.Source
+	// resume, if set, starts the source after a checkpointed position.
+	resume      resumeFunc
+	checkpoints *checkpointPolicy
+	stateStore  StateStore
+	key         func(any) string
+	clock       pipelineinternal.Clock
+	scheduler   pipelineinternal.Scheduler
+
+	// collect records wiring problems in problems instead of panicking (see
+	// WithBuildErrors).
+	collect  bool
+	problems []*WiringError
+
 	stages []stageDef
-	sink   pipelineinternal.Sink
+	// tx, if set, is the transactional form of sink.
+	tx       *pipelineinternal.TxSink
+	txPolicy *TxPolicy
+	sink     pipelineinternal.Sink
+	// sinkLife, if set, opens and closes the sink.
+	sinkLife *pipelineinternal.Lifecycle
 
 	currentType reflect.Type
 }
@@ -50,12 +91,26 @@ type definition struct {
 //	Then:      func(context.Context, In) (Out, error)
 //	ThenBatch: func(context.Context, []In) ([]Out, error)
 //	To:        func(context.Context, In) error
+//
+// Typed is the compile-time checked, reflection-free form of the builder.
 type Pipeline struct {
 	def *definition
 }
 
 // New creates a new pipeline builder.
 func New[T any](name string, source SourceFunc[T], opts ...Option) *Pipeline {
+	return newPipeline(name, typeOf[T](), func(ctx context.Context) (<-chan any, error) {
+		ch, err := source(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return relay(ctx, ch, func(v T) any { return v }, nil), nil
+	}, nil, opts)
+}
+
+// newPipeline creates a builder for source, or for resume when the source
+// can be resumed from a position.
+func newPipeline(name string, currentType reflect.Type, source pipelineinternal.Source, resume resumeFunc, opts []Option) *Pipeline {
 	o := defaultPipelineOptions()
 	for _, opt := range opts {
 		if opt != nil {
@@ -63,65 +118,123 @@ func New[T any](name string, source SourceFunc[T], opts ...Option) *Pipeline {
 		}
 	}
 
-	currentType := typeOf[T]()
-
 	def := &definition{
-		name:        name,
-		buffer:      o.buffer,
-		logger:      pipelineinternal.FromSlog(o.logger),
-		currentType: currentType,
-		source: func(ctx context.Context) (<-chan any, error) {
-			ch, err := source(ctx)
-			if err != nil {
-				return nil, err
-			}
+		name:          name,
+		buffer:        o.buffer,
+		overflow:      o.overflow,
+		onDrop:        o.onDrop,
+		logger:        pipelineinternal.FromSlog(o.logger),
+		traceRate:     o.traceRate,
+		suspendTimers: o.suspendTimers,
+
+		maxInFlight:      o.maxInFlight,
+		maxInFlightBytes: o.maxInFlightBytes,
+		sizer:            o.sizer,
+		priority:         o.priorityConfig(),
+		currentType:      currentType,
+		source:           source,
+		resume:           resume,
+		checkpoints:      o.checkpoints,
+		stateStore:       o.stateStore,
+		key:              o.key,
+		clock:            internalClock(o.clock),
+		scheduler:        internalScheduler(o.scheduler),
+		collect:          o.buildErrors,
+	}
 
-			out := make(chan any)
-			go func() {
-				defer close(out)
-				for {
-					select {
-					case <-ctx.Done():
-						return
-					case v, ok := <-ch:
-						if !ok {
-							return
-						}
-						select {
-						case <-ctx.Done():
-							return
-						case out <- v:
-						}
-					}
-				}
-			}()
-			return out, nil
-		},
+	if o.trace != nil {
+		def.tracer = o.trace.t
+	}
+
+	if o.sizerType != nil && o.sizerType != currentType {
+		def.failOptions(fmt.Errorf("in-flight sizer type %v does not match source item type %v", o.sizerType, currentType))
+	}
+	if o.priorityType != nil && o.priorityType != currentType {
+		def.failOptions(fmt.Errorf("priority function type %v does not match source item type %v", o.priorityType, currentType))
+	}
+	if o.keyType != nil && o.keyType != currentType {
+		def.failOptions(fmt.Errorf("idempotency key type %v does not match source item type %v", o.keyType, currentType))
+	}
+	if o.checkpoints != nil && resume == nil {
+		def.failOptions(errors.New("checkpoints require a resumable source (see NewResumable)"))
+	}
+	if o.stateStore != nil && o.checkpoints == nil {
+		def.failOptions(errors.New("a state store requires WithCheckpoints"))
 	}
 
 	return &Pipeline{def: def}
 }
 
+// relay forwards the items of ch as wrapped by wrap until ctx is done. An
+// item already taken from ch when ctx ends is passed to lost, if set.
+func relay[T any](ctx context.Context, ch <-chan T, wrap func(T) any, lost func(T)) <-chan any {
+	out := make(chan any)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case v, ok := <-ch:
+				if !ok {
+					return
+				}
+				select {
+				case <-ctx.Done():
+					if lost != nil {
+						lost(v)
+					}
+					return
+				case out <- wrap(v):
+				}
+			}
+		}
+	}()
+	return out
+}
+
 func (p *Pipeline) Then(handler any, opts ...StageOption) *Pipeline {
 	if p == nil || p.def == nil {
 		panic("pipeline: builder must not be nil")
 	}
-	wrapped, outType := wrapSingleHandler(handler, p.def.currentType)
+	so := p.stageOptions(opts)
+	wrapped, outType, err := wrapSingleHandler(handler, p.def.currentType)
+	if err != nil {
+		p.def.failStage(so.name, err)
+	}
+	return p.then(wrapped, outType, so)
+}
 
+// stageOptions applies opts over the pipeline's stage defaults.
+func (p *Pipeline) stageOptions(opts []StageOption) stageOptions {
 	so := defaultStageOptions()
 	so.buffer = p.def.buffer
+	so.overflow = p.def.overflow
 	for _, opt := range opts {
 		if opt != nil {
 			opt(&so)
 		}
 	}
+	return so
+}
+
+// then adds a single-item stage running handler, whose outputs are of type
+// outType, or of an unknown type if outType is nil.
+func (p *Pipeline) then(handler pipelineinternal.SingleHandler, outType reflect.Type, so stageOptions) *Pipeline {
+	p.checkSpill(so, outType)
+	p.checkLimiter(so)
 
 	p.def.stages = append(p.def.stages, stageDef{
 		kind:        stageSingle,
 		name:        so.name,
 		buffer:      so.buffer,
+		overflow:    so.overflow,
 		concurrency: so.concurrency,
-		single:      wrapped,
+		autoscale:   so.autoscale,
+		limiter:     so.limiter,
+		rate:        so.rate,
+		spill:       so.spill,
+		single:      wrapSingleCalls(handler, so.wrap),
 	})
 
 	p.def.currentType = outType
@@ -132,29 +245,31 @@ func (p *Pipeline) ThenBatch(handler any, batch BatchPolicy, opts ...StageOption
 	if p == nil || p.def == nil {
 		panic("pipeline: builder must not be nil")
 	}
-
-	bp := pipelineinternal.BatchPolicy{Size: batch.Size, MaxWait: batch.MaxWait}
-	if bp.Size < 1 {
-		panic("pipeline: batch size must be >= 1")
+	so := p.stageOptions(opts)
+	wrapped, outType, err := wrapBatchHandler(handler, p.def.currentType)
+	if err != nil {
+		p.def.failStage(so.name, err)
 	}
+	return p.thenBatch(wrapped, outType, batch, so)
+}
 
-	wrapped, outType := wrapBatchHandler(handler, p.def.currentType)
-
-	so := defaultStageOptions()
-	so.buffer = p.def.buffer
-	for _, opt := range opts {
-		if opt != nil {
-			opt(&so)
-		}
+// thenBatch is then for batch stages.
+func (p *Pipeline) thenBatch(handler pipelineinternal.BatchHandler, outType reflect.Type, batch BatchPolicy, so stageOptions) *Pipeline {
+	if batch.Size < 1 {
+		p.def.failStage(so.name, errors.New("batch size must be >= 1"))
 	}
+	p.checkSpill(so, outType)
 
 	p.def.stages = append(p.def.stages, stageDef{
 		kind:        stageBatch,
 		name:        so.name,
 		buffer:      so.buffer,
+		overflow:    so.overflow,
 		concurrency: so.concurrency,
-		batch:       wrapped,
-		batchPolicy: bp,
+		rate:        so.rate,
+		spill:       so.spill,
+		batch:       wrapBatchCalls(handler, so.wrap),
+		batchPolicy: pipelineinternal.BatchPolicy{Size: batch.Size, MaxWait: batch.MaxWait},
 	})
 
 	p.def.currentType = outType
@@ -165,18 +280,20 @@ func (p *Pipeline) To(sink any, opts ...StageOption) *Runnable {
 	if p == nil || p.def == nil {
 		panic("pipeline: builder must not be nil")
 	}
-
-	wrapped := wrapSink(sink, p.def.currentType)
-
-	so := defaultStageOptions()
-	so.buffer = p.def.buffer
-	for _, opt := range opts {
-		if opt != nil {
-			opt(&so)
-		}
+	so := p.stageOptions(opts)
+	wrapped, err := wrapSink(sink, p.def.currentType)
+	if err != nil {
+		p.def.failSink(err)
 	}
+	return p.to(wrapped, so)
+}
 
-	p.def.sink = wrapped
+// to finalizes the pipeline with sink.
+func (p *Pipeline) to(sink pipelineinternal.Sink, so stageOptions) *Runnable {
+	p.checkState()
+	p.checkInFlight(nil)
+	p.def.sink = wrapSinkCalls(sink, so.wrap)
+	p.def.tx, p.def.txPolicy, p.def.sinkLife = nil, nil, nil
 	return &Runnable{def: p.def}
 }
 
@@ -190,24 +307,24 @@ func typeOf[T any]() reflect.Type {
 	return reflect.TypeOf(zero).Elem()
 }
 
-func wrapSingleHandler(handler any, expectedIn reflect.Type) (pipelineinternal.SingleHandler, reflect.Type) {
+func wrapSingleHandler(handler any, expectedIn reflect.Type) (pipelineinternal.SingleHandler, reflect.Type, error) {
 	if handler == nil {
-		panic("pipeline: handler must not be nil")
+		return nil, nil, errors.New("handler must not be nil")
 	}
 	v := reflect.ValueOf(handler)
 	if v.Kind() != reflect.Func {
-		panic(fmt.Sprintf("pipeline: handler must be a func, got %T", handler))
+		return nil, nil, fmt.Errorf("handler must be a func, got %T", handler)
 	}
 	t := v.Type()
 	if t.NumIn() != 2 || t.In(0) != ctxType {
-		panic(fmt.Sprintf("pipeline: handler must have signature func(context.Context, In) (Out, error), got %s", t.String()))
+		return nil, nil, fmt.Errorf("handler must have signature func(context.Context, In) (Out, error), got %s", t.String())
 	}
 	if t.NumOut() != 2 || t.Out(1) != errorType {
-		panic(fmt.Sprintf("pipeline: handler must have signature func(context.Context, In) (Out, error), got %s", t.String()))
+		return nil, nil, fmt.Errorf("handler must have signature func(context.Context, In) (Out, error), got %s", t.String())
 	}
 	inType := t.In(1)
 	if expectedIn != nil && !expectedIn.AssignableTo(inType) && !expectedIn.ConvertibleTo(inType) {
-		panic(fmt.Sprintf("pipeline: handler input type %s is not compatible with previous stage output %s", inType, expectedIn))
+		return nil, nil, fmt.Errorf("handler input type %s is not compatible with previous stage output %s", inType, expectedIn)
 	}
 	outType := t.Out(0)
 
@@ -223,28 +340,28 @@ func wrapSingleHandler(handler any, expectedIn reflect.Type) (pipelineinternal.S
 		return outs[0].Interface(), nil
 	}
 
-	return wrapped, outType
+	return wrapped, outType, nil
 }
 
-func wrapBatchHandler(handler any, expectedElem reflect.Type) (pipelineinternal.BatchHandler, reflect.Type) {
+func wrapBatchHandler(handler any, expectedElem reflect.Type) (pipelineinternal.BatchHandler, reflect.Type, error) {
 	if handler == nil {
-		panic("pipeline: batch handler must not be nil")
+		return nil, nil, errors.New("batch handler must not be nil")
 	}
 	v := reflect.ValueOf(handler)
 	if v.Kind() != reflect.Func {
-		panic(fmt.Sprintf("pipeline: batch handler must be a func, got %T", handler))
+		return nil, nil, fmt.Errorf("batch handler must be a func, got %T", handler)
 	}
 	t := v.Type()
 	if t.NumIn() != 2 || t.In(0) != ctxType || t.In(1).Kind() != reflect.Slice {
-		panic(fmt.Sprintf("pipeline: batch handler must have signature func(context.Context, []In) ([]Out, error), got %s", t.String()))
+		return nil, nil, fmt.Errorf("batch handler must have signature func(context.Context, []In) ([]Out, error), got %s", t.String())
 	}
 	if t.NumOut() != 2 || t.Out(1) != errorType || t.Out(0).Kind() != reflect.Slice {
-		panic(fmt.Sprintf("pipeline: batch handler must have signature func(context.Context, []In) ([]Out, error), got %s", t.String()))
+		return nil, nil, fmt.Errorf("batch handler must have signature func(context.Context, []In) ([]Out, error), got %s", t.String())
 	}
 	inSliceType := t.In(1)
 	inElem := inSliceType.Elem()
 	if expectedElem != nil && !expectedElem.AssignableTo(inElem) && !expectedElem.ConvertibleTo(inElem) {
-		panic(fmt.Sprintf("pipeline: batch handler input element type %s is not compatible with previous stage output %s", inElem, expectedElem))
+		return nil, nil, fmt.Errorf("batch handler input element type %s is not compatible with previous stage output %s", inElem, expectedElem)
 	}
 	outSliceType := t.Out(0)
 	outElem := outSliceType.Elem()
@@ -276,27 +393,27 @@ func wrapBatchHandler(handler any, expectedElem reflect.Type) (pipelineinternal.
 		return anyOut, nil
 	}
 
-	return wrapped, outElem
+	return wrapped, outElem, nil
 }
 
-func wrapSink(sink any, expectedIn reflect.Type) pipelineinternal.Sink {
+func wrapSink(sink any, expectedIn reflect.Type) (pipelineinternal.Sink, error) {
 	if sink == nil {
-		panic("pipeline: sink must not be nil")
+		return nil, errors.New("sink must not be nil")
 	}
 	v := reflect.ValueOf(sink)
 	if v.Kind() != reflect.Func {
-		panic(fmt.Sprintf("pipeline: sink must be a func, got %T", sink))
+		return nil, fmt.Errorf("sink must be a func, got %T", sink)
 	}
 	t := v.Type()
 	if t.NumIn() != 2 || t.In(0) != ctxType {
-		panic(fmt.Sprintf("pipeline: sink must have signature func(context.Context, In) error, got %s", t.String()))
+		return nil, fmt.Errorf("sink must have signature func(context.Context, In) error, got %s", t.String())
 	}
 	if t.NumOut() != 1 || t.Out(0) != errorType {
-		panic(fmt.Sprintf("pipeline: sink must have signature func(context.Context, In) error, got %s", t.String()))
+		return nil, fmt.Errorf("sink must have signature func(context.Context, In) error, got %s", t.String())
 	}
 	inType := t.In(1)
 	if expectedIn != nil && !expectedIn.AssignableTo(inType) && !expectedIn.ConvertibleTo(inType) {
-		panic(fmt.Sprintf("pipeline: sink input type %s is not compatible with previous stage output %s", inType, expectedIn))
+		return nil, fmt.Errorf("sink input type %s is not compatible with previous stage output %s", inType, expectedIn)
 	}
 
 	return func(ctx context.Context, input any) error {
@@ -309,7 +426,7 @@ func wrapSink(sink any, expectedIn reflect.Type) pipelineinternal.Sink {
 			return nil
 		}
 		return outs[0].Interface().(error)
-	}
+	}, nil
 }
 
 func adaptValue(input any, target reflect.Type) (reflect.Value, error) {
@@ -0,0 +1,179 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// acks records the outcome of every message of an ackSource.
+type acks struct {
+	mu      sync.Mutex
+	acked   map[int]int
+	nacked  map[int]error
+	settled chan struct{}
+}
+
+func ackSource(n int) (AckSourceFunc[int], *acks) {
+	a := &acks{acked: map[int]int{}, nacked: map[int]error{}, settled: make(chan struct{}, n)}
+	src := func(ctx context.Context) (<-chan Message[int], error) {
+		ch := make(chan Message[int], n)
+		for i := 0; i < n; i++ {
+			ch <- Message[int]{
+				Value: i,
+				Ack: func() {
+					a.mu.Lock()
+					a.acked[i]++
+					a.mu.Unlock()
+					a.settled <- struct{}{}
+				},
+				Nack: func(err error) {
+					a.mu.Lock()
+					a.nacked[i] = err
+					a.mu.Unlock()
+					a.settled <- struct{}{}
+				},
+			}
+		}
+		close(ch)
+		return ch, nil
+	}
+	return src, a
+}
+
+func (a *acks) wait(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-a.settled:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("only %d of %d messages settled", i, n)
+		}
+	}
+	select {
+	case <-a.settled:
+		t.Fatalf("a message was settled twice")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestAckAfterSink(t *testing.T) {
+	t.Parallel()
+
+	src, a := ackSource(10)
+	var sunk int
+	_, err := NewAcked("ack", src).
+		Then(func(ctx context.Context, n int) (int, error) { return n * 2, nil }, WithStageConcurrency(3)).
+		To(func(ctx context.Context, n int) error {
+			sunk++
+			return nil
+		}).
+		Run(context.Background())
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	a.wait(t, 10)
+	if len(a.acked) != 10 || len(a.nacked) != 0 || sunk != 10 {
+		t.Fatalf("expected 10 acks, got %v acked and %v nacked", a.acked, a.nacked)
+	}
+}
+
+func TestNackOnHandlerError(t *testing.T) {
+	t.Parallel()
+
+	boom := errors.New("boom")
+	src, a := ackSource(5)
+	_, err := NewAcked("nack", src).
+		Then(func(ctx context.Context, n int) (int, error) {
+			if n == 2 {
+				return 0, boom
+			}
+			return n, nil
+		}).
+		To(func(ctx context.Context, n int) error { return nil }).
+		Run(context.Background())
+	if !errors.Is(err, boom) {
+		t.Fatalf("expected boom, got %v", err)
+	}
+	a.wait(t, 5)
+	if !errors.Is(a.nacked[2], boom) {
+		t.Fatalf("expected item 2 to be nacked with boom, got %v", a.nacked[2])
+	}
+	for i := 0; i < 5; i++ {
+		if _, ok := a.acked[i]; ok == (a.nacked[i] != nil) {
+			t.Fatalf("item %d not settled exactly once: acked %v nacked %v", i, a.acked, a.nacked)
+		}
+	}
+}
+
+func TestNackFollowsBatchOutputs(t *testing.T) {
+	t.Parallel()
+
+	boom := errors.New("sink failed")
+	src, a := ackSource(6)
+	_, err := NewAcked("batch", src).
+		ThenBatch(func(ctx context.Context, in []int) ([]int, error) {
+			return []int{in[0] + in[1] + in[2]}, nil
+		}, BatchPolicy{Size: 3}).
+		To(func(ctx context.Context, sum int) error {
+			if sum == 0+1+2 {
+				return boom
+			}
+			return nil
+		}).
+		Run(context.Background())
+	if !errors.Is(err, boom) {
+		t.Fatalf("expected the sink error, got %v", err)
+	}
+	a.wait(t, 6)
+	for i := 0; i < 3; i++ {
+		if !errors.Is(a.nacked[i], boom) {
+			t.Fatalf("expected input %d of the failed batch nacked with the sink error, got %v", i, a.nacked[i])
+		}
+	}
+}
+
+func TestNackDroppedAndAbandoned(t *testing.T) {
+	t.Parallel()
+
+	src, a := ackSource(20)
+	release := make(chan struct{})
+	r := NewAcked("drops", src).
+		Then(func(ctx context.Context, n int) (int, error) { return n, nil }, WithStageBuffer(1, OverflowDropNewest)).
+		To(func(ctx context.Context, n int) error {
+			<-release
+			return nil
+		})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h, err := r.Start(ctx)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for h.Stats().Stages[0].In != 20 {
+		if time.Now().After(deadline) {
+			t.Fatalf("stage never saw every item: %+v", h.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	close(release)
+	h.Wait()
+	a.wait(t, 20)
+
+	var dropped, abandoned int
+	for _, err := range a.nacked {
+		switch {
+		case errors.Is(err, ErrDropped):
+			dropped++
+		case errors.Is(err, ErrAbandoned):
+			abandoned++
+		}
+	}
+	if st := h.Stats(); int64(dropped) != st.Dropped || dropped+abandoned+len(a.acked) != 20 {
+		t.Fatalf("expected drops and abandons to be nacked, got %d dropped, %d abandoned, %d acked (%+v)", dropped, abandoned, len(a.acked), st)
+	}
+}
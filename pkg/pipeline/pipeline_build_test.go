@@ -0,0 +1,146 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestBuildCollectsWiringProblems(t *testing.T) {
+	t.Parallel()
+
+	_, err := New("misconfigured", compileTimeSource([]int{1, 2}), WithBuildErrors(),
+		WithPriority(func(s string) int { return len(s) })).
+		Then(func(n int) int { return n }, WithStageName("parse")).
+		Then(func(ctx context.Context, s string) (string, error) { return s, nil }).
+		ThenBatch(func(ctx context.Context, in []string) ([]string, error) { return in, nil }, BatchPolicy{}, WithStageName("store")).
+		To(func(ctx context.Context, n int) error { return nil }).
+		Build()
+
+	var be *BuildError
+	if !errors.As(err, &be) {
+		t.Fatalf("expected a *BuildError, got %v", err)
+	}
+	want := []struct {
+		stage int
+		name  string
+		sink  bool
+		msg   string
+	}{
+		{-1, "", false, "priority function type string does not match source item type int"},
+		{0, "parse", false, "handler must have signature"},
+		{2, "store", false, "batch size must be >= 1"},
+		{3, "", true, "sink input type int is not compatible with previous stage output string"},
+	}
+	if len(be.Problems) != len(want) {
+		t.Fatalf("expected %d problems, got %v", len(want), err)
+	}
+	for i, w := range want {
+		p := be.Problems[i]
+		if p.Stage != w.stage || p.Name != w.name || p.Sink != w.sink || !strings.Contains(p.Err.Error(), w.msg) {
+			t.Errorf("problem %d: expected stage %d (%q, sink=%v) %q, got %+v", i, w.stage, w.name, w.sink, w.msg, p)
+		}
+	}
+	if msg := err.Error(); !strings.HasPrefix(msg, "pipeline: 4 wiring problems: options: ") ||
+		!strings.Contains(msg, "; stage 0 (parse): ") || !strings.Contains(msg, "; sink: ") {
+		t.Fatalf("unexpected message %q", msg)
+	}
+
+	var we *WiringError
+	if !errors.As(err, &we) || we.Stage != -1 {
+		t.Fatalf("expected the first *WiringError, got %v", we)
+	}
+}
+
+func TestBuildCollectsStatefulAndTypedProblems(t *testing.T) {
+	t.Parallel()
+
+	p := New("typed", compileTimeSource([]reading{{"a", 1}}), WithBuildErrors())
+	keyed := StatefulThen(p, func(r reading) string { return r.Sensor },
+		func(ctx context.Context, st KeyState[string, int], r reading) (int, error) { return r.Value, nil },
+		WithStageName("totals"), WithStateCodec(JSONCodec[string]()))
+	_, err := To(Then[int, string](AsTyped[int](keyed), nil), nil).Build()
+
+	var be *BuildError
+	if !errors.As(err, &be) || len(be.Problems) != 3 {
+		t.Fatalf("expected 3 problems, got %v", err)
+	}
+	if p := be.Problems[0]; p.Stage != 0 || p.Name != "totals" || !strings.Contains(p.Err.Error(), "state codec") {
+		t.Fatalf("unexpected state codec problem %+v", p)
+	}
+	if p := be.Problems[1]; p.Stage != 1 || !strings.Contains(p.Err.Error(), "handler must not be nil") {
+		t.Fatalf("unexpected handler problem %+v", p)
+	}
+	if p := be.Problems[2]; !p.Sink || !strings.Contains(p.Err.Error(), "sink must not be nil") {
+		t.Fatalf("unexpected sink problem %+v", p)
+	}
+}
+
+func TestBuildCollectsTxSinkProblems(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name string
+		sink any
+		msg  string
+	}{
+		{"nil", nil, "sink must not be nil"},
+		{"no tx", func(ctx context.Context, n int) error { return nil }, "must implement TxSink"},
+	} {
+		_, err := New(tc.name, compileTimeSource([]int{1}), WithBuildErrors()).
+			ToTx(tc.sink, TxPolicy{Size: 1}).
+			Build()
+		var be *BuildError
+		if !errors.As(err, &be) || len(be.Problems) != 1 {
+			t.Fatalf("%s: expected 1 problem, got %v", tc.name, err)
+		}
+		if p := be.Problems[0]; !p.Sink || !strings.Contains(p.Err.Error(), tc.msg) {
+			t.Fatalf("%s: unexpected sink problem %+v", tc.name, p)
+		}
+	}
+}
+
+func TestBuildSucceedsAndRuns(t *testing.T) {
+	t.Parallel()
+
+	sink := &stringSink{}
+	r, err := New("valid", compileTimeSource([]int{1, 2}), WithBuildErrors()).
+		Then(func(ctx context.Context, n int) (string, error) { return itoa(n), nil }).
+		To(sink.write).
+		Build()
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if _, err := r.Run(context.Background()); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if got := sink.items(); len(got) != 2 {
+		t.Fatalf("expected 2 items, got %v", got)
+	}
+}
+
+func TestStartRefusesMisconfiguredPipeline(t *testing.T) {
+	t.Parallel()
+
+	r := New("refused", compileTimeSource([]int{1}), WithBuildErrors()).
+		To(func(ctx context.Context, r reading) error { return nil })
+	res, err := r.Run(context.Background())
+	var be *BuildError
+	if !errors.As(err, &be) || res.State() != StateFailed {
+		t.Fatalf("expected a failed run with a *BuildError, got %v (%v)", res.State(), err)
+	}
+}
+
+func TestWiringProblemsPanicByDefault(t *testing.T) {
+	t.Parallel()
+
+	defer func() {
+		r := recover()
+		if r == nil || !strings.Contains(r.(string), "pipeline: batch size must be >= 1") {
+			t.Fatalf("expected a batch size panic, got %v", r)
+		}
+	}()
+	New("panics", compileTimeSource([]int{1})).
+		ThenBatch(func(ctx context.Context, in []int) ([]int, error) { return in, nil }, BatchPolicy{})
+}
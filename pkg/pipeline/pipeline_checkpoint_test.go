@@ -0,0 +1,147 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingSource yields 0..n-1 with their decimal value as position.
+func countingSource(n int) ResumableSourceFunc[int] {
+	return func(ctx context.Context, after string) (<-chan Positioned[int], error) {
+		start := 0
+		if after != "" {
+			last, err := strconv.Atoi(after)
+			if err != nil {
+				return nil, err
+			}
+			start = last + 1
+		}
+		ch := make(chan Positioned[int])
+		go func() {
+			defer close(ch)
+			for i := start; i < n; i++ {
+				select {
+				case <-ctx.Done():
+					return
+				case ch <- Positioned[int]{Value: i, Position: strconv.Itoa(i)}:
+				}
+			}
+		}()
+		return ch, nil
+	}
+}
+
+func TestCheckpointResumesAfterFailure(t *testing.T) {
+	t.Parallel()
+
+	store := NewFileCheckpointStore(t.TempDir())
+	boom := errors.New("boom")
+	var (
+		mu     sync.Mutex
+		failed bool
+		seen   = map[int]int{}
+	)
+	r := NewResumable("backfill", countingSource(100), WithCheckpoints(store, time.Millisecond)).
+		Then(func(ctx context.Context, n int) (int, error) {
+			time.Sleep(time.Duration(n%3) * time.Millisecond)
+			return n, nil
+		}, WithStageConcurrency(4)).
+		To(func(ctx context.Context, n int) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if n == 50 && !failed {
+				failed = true
+				return boom
+			}
+			seen[n]++
+			return nil
+		})
+
+	h, err := r.Start(context.Background())
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	if _, err := h.Wait(); !errors.Is(err, boom) {
+		t.Fatalf("expected the first run to fail, got %v", err)
+	}
+	cp, err := h.Checkpoint()
+	if err != nil {
+		t.Fatalf("checkpoint: %v", err)
+	}
+	mark, _ := strconv.Atoi(cp.Position)
+	if cp.Position == "" || mark >= 50 {
+		t.Fatalf("expected a watermark below the failed item, got %q", cp.Position)
+	}
+	for i := 0; i <= mark; i++ {
+		if seen[i] != 1 {
+			t.Fatalf("watermark %d passed item %d, which was not sunk", mark, i)
+		}
+	}
+
+	h, err = r.Start(context.Background())
+	if err != nil {
+		t.Fatalf("restart: %v", err)
+	}
+	if _, err := h.Wait(); err != nil {
+		t.Fatalf("resumed run: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		if seen[i] == 0 {
+			t.Fatalf("item %d was never sunk", i)
+		}
+		if i <= mark && seen[i] != 1 {
+			t.Fatalf("item %d below the checkpoint was processed again", i)
+		}
+	}
+	if cp, _, _ := store.Load(context.Background(), "backfill"); cp.Position != "99" {
+		t.Fatalf("expected the final checkpoint at 99, got %+v", cp)
+	}
+}
+
+func TestCheckpointWatermarkWaitsForEarlierItems(t *testing.T) {
+	t.Parallel()
+
+	c := newCheckpointer("w", checkpointPolicy{}, nil, Checkpoint{})
+	a, b, d, e := c.track("a"), c.track("b"), c.track("c"), c.track("d")
+	d(nil)
+	b(nil)
+	if c.position != "" {
+		t.Fatalf("watermark moved past an unfinished item: %q", c.position)
+	}
+	a(nil)
+	if c.position != "c" {
+		t.Fatalf("expected watermark c, got %q", c.position)
+	}
+	e(errors.New("failed"))
+	c.track("e")(ErrDropped)
+	if c.position != "c" {
+		t.Fatalf("a failed item must hold the watermark, got %q", c.position)
+	}
+
+	c = newCheckpointer("w", checkpointPolicy{}, nil, Checkpoint{})
+	x, y := c.track("x"), c.track("y")
+	x(ErrDropped)
+	y(nil)
+	if c.position != "y" {
+		t.Fatalf("dropped items must not hold the watermark, got %q", c.position)
+	}
+}
+
+func TestCheckpointRequiresResumableSource(t *testing.T) {
+	t.Parallel()
+
+	store := NewFileCheckpointStore(t.TempDir())
+	if _, ok, err := store.Load(context.Background(), "none"); ok || err != nil {
+		t.Fatalf("expected no checkpoint, got %v %v", ok, err)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected a panic for checkpoints on a plain source")
+		}
+	}()
+	New("plain", compileTimeSource([]int{1}), WithCheckpoints(store, time.Second))
+}
@@ -0,0 +1,95 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunGracefulDrainsAdmittedItems(t *testing.T) {
+	t.Parallel()
+
+	var sunk atomic.Int64
+	stop := make(chan struct{})
+	r := New("graceful", endlessSource, WithBuffer(16)).
+		Then(func(ctx context.Context, n int) (int, error) {
+			time.Sleep(200 * time.Microsecond)
+			return n, nil
+		}, WithStageConcurrency(4)).
+		To(func(ctx context.Context, n int) error {
+			if sunk.Add(1) == 50 {
+				close(stop)
+			}
+			return nil
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := r.RunGraceful(ctx, stop, 5*time.Second)
+	if err != nil {
+		t.Fatalf("expected clean drain, got %v", err)
+	}
+	stopped, ok := res.(Stopped)
+	if !ok {
+		t.Fatalf("expected Stopped result, got %T (%s)", res, res.State())
+	}
+	if stopped.Abandoned != 0 {
+		t.Fatalf("expected nothing abandoned, got %d", stopped.Abandoned)
+	}
+	stats := r.Stats()
+	if stats.Admitted != sunk.Load() {
+		t.Fatalf("expected every admitted item to be sunk: admitted=%d sunk=%d", stats.Admitted, sunk.Load())
+	}
+	// Stop is observed asynchronously, so the sink may write a few more items
+	// before the drain starts; those are not counted as completed.
+	if stopped.Completed <= 0 || stopped.Completed > sunk.Load()-49 {
+		t.Fatalf("expected 0 < completed <= %d, got %d", sunk.Load()-49, stopped.Completed)
+	}
+}
+
+func TestRunGracefulDeadlineAbandonsItems(t *testing.T) {
+	t.Parallel()
+
+	full := make(chan struct{})
+	var seen atomic.Int64
+	r := New("deadline", endlessSource, WithBuffer(32)).
+		To(func(ctx context.Context, n int) error {
+			if seen.Add(1) == 1 {
+				// Let the buffer fill up behind the slow sink.
+				for i := 0; i < 200; i++ {
+					time.Sleep(time.Millisecond)
+				}
+				close(full)
+			}
+			select {
+			case <-time.After(5 * time.Millisecond):
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+
+	stop := make(chan struct{})
+	go func() {
+		<-full
+		close(stop)
+	}()
+
+	res, err := r.RunGraceful(context.Background(), stop, 30*time.Millisecond)
+	if !errors.Is(err, ErrDrainTimeout) {
+		t.Fatalf("expected drain timeout, got %v", err)
+	}
+	stopped, ok := res.(Stopped)
+	if !ok {
+		t.Fatalf("expected Stopped result, got %T", res)
+	}
+	if stopped.Abandoned == 0 {
+		t.Fatalf("expected buffered items to be abandoned")
+	}
+	if got := r.Stats().Abandoned; got != stopped.Abandoned {
+		t.Fatalf("expected stats to agree with result: %d vs %d", got, stopped.Abandoned)
+	}
+}
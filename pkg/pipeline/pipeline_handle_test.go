@@ -0,0 +1,135 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func endlessSource(ctx context.Context) (<-chan int, error) {
+	ch := make(chan int)
+	go func() {
+		defer close(ch)
+		for i := 0; ; i++ {
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- i:
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func waitPhase(t *testing.T, h *Handle, want Phase) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for h.State() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected phase %s, got %s", want, h.State())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHandleStopDrainsInFlightItems(t *testing.T) {
+	t.Parallel()
+
+	var sunk atomic.Int64
+	r := New("drain", endlessSource, WithBuffer(8)).
+		Then(func(ctx context.Context, n int) (int, error) {
+			time.Sleep(100 * time.Microsecond)
+			return n, nil
+		}, WithStageConcurrency(2)).
+		ThenBatch(func(ctx context.Context, in []int) ([]int, error) { return in, nil }, BatchPolicy{Size: 7}).
+		To(func(ctx context.Context, n int) error {
+			sunk.Add(1)
+			return nil
+		})
+
+	h, err := r.Start(context.Background())
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	waitPhase(t, h, PhaseRunning)
+	for sunk.Load() < 20 {
+		time.Sleep(time.Millisecond)
+	}
+
+	h.Stop(5 * time.Second)
+	if s := h.State(); s != PhaseDraining && s != PhaseStopped {
+		t.Fatalf("expected draining after Stop, got %s", s)
+	}
+
+	select {
+	case <-h.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected drain to finish")
+	}
+
+	res, err := h.Wait()
+	if err != nil || res.State() != StateStopped {
+		t.Fatalf("expected clean stop, got %s %v", res.State(), err)
+	}
+	if h.State() != PhaseStopped {
+		t.Fatalf("expected stopped phase, got %s", h.State())
+	}
+	stats := h.Stats()
+	if stats.Admitted == 0 || stats.Admitted != sunk.Load() {
+		t.Fatalf("expected every admitted item to reach the sink: admitted=%d sunk=%d", stats.Admitted, sunk.Load())
+	}
+}
+
+func TestHandleStopDrainTimeoutCancels(t *testing.T) {
+	t.Parallel()
+
+	entered := make(chan struct{}, 1)
+	r := New("stuck", endlessSource).
+		To(func(ctx context.Context, n int) error {
+			select {
+			case entered <- struct{}{}:
+			default:
+			}
+			<-ctx.Done()
+			return ctx.Err()
+		})
+
+	h, err := r.Start(context.Background())
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	<-entered
+	h.Stop(20 * time.Millisecond)
+
+	res, err := h.Wait()
+	if !errors.Is(err, ErrDrainTimeout) || res.State() != StateStopped {
+		t.Fatalf("expected drain timeout, got %s %v", res.State(), err)
+	}
+}
+
+func TestHandleCancelAndStartError(t *testing.T) {
+	t.Parallel()
+
+	r := New("cancel", endlessSource).To(func(ctx context.Context, n int) error { return nil })
+	h, err := r.Start(context.Background())
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	h.Cancel()
+	res, err := h.Wait()
+	if !errors.Is(err, context.Canceled) || res.State() != StateCancelled {
+		t.Fatalf("expected cancelled, got %s %v", res.State(), err)
+	}
+
+	sentinel := errors.New("no source")
+	broken := New("broken", func(ctx context.Context) (<-chan int, error) { return nil, sentinel }).
+		To(func(ctx context.Context, n int) error { return nil })
+	if _, err := broken.Start(context.Background()); !errors.Is(err, sentinel) {
+		t.Fatalf("expected source error from Start, got %v", err)
+	}
+	if broken.Stats().Running {
+		t.Fatalf("expected failed start not to count as running")
+	}
+}
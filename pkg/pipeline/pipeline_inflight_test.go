@@ -0,0 +1,174 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// settle waits until the run's counters stop changing and returns them.
+func settle(t *testing.T, h *Handle) Stats {
+	t.Helper()
+	prev := h.Stats()
+	for i := 0; i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+		cur := h.Stats()
+		if cur.Admitted == prev.Admitted && cur.Dropped == prev.Dropped && cur.Sink.In == prev.Sink.In {
+			return cur
+		}
+		prev = cur
+	}
+	t.Fatalf("counters never settled: %+v", prev)
+	return prev
+}
+
+func TestMaxInFlightBoundsAdmission(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	r := New("bounded", endlessSource, WithBuffer(100), WithMaxInFlight(8)).
+		Then(func(ctx context.Context, n int) (int, error) { return n, nil }, WithStageConcurrency(4)).
+		// Four inputs become one output, which keeps all four in flight.
+		ThenBatch(func(ctx context.Context, in []int) ([]int, error) { return in[:1], nil }, BatchPolicy{Size: 4}).
+		To(func(ctx context.Context, n int) error {
+			<-release
+			return nil
+		})
+	if d := r.Describe(); d.MaxInFlight != 8 {
+		t.Fatalf("expected MaxInFlight 8 in description, got %d", d.MaxInFlight)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	h, err := r.Start(ctx)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+
+	st := settle(t, h)
+	if st.Admitted != 8 || st.InFlight != 8 {
+		t.Fatalf("expected exactly 8 admitted items in flight, got %+v", st)
+	}
+
+	// Each item written by the sink frees its four source items.
+	release <- struct{}{}
+	st = settle(t, h)
+	if st.Admitted != 12 || st.InFlight != 8 {
+		t.Fatalf("expected one batch worth of room to be reused, got %+v", st)
+	}
+
+	h.Stop(0)
+	close(release)
+	if _, err := h.Wait(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if st := h.Stats(); st.InFlight != 0 {
+		t.Fatalf("expected nothing in flight after the run, got %d", st.InFlight)
+	}
+}
+
+func TestMaxInFlightBytes(t *testing.T) {
+	t.Parallel()
+
+	items := []string{"aaaa", "bbbb", "cccc", "an item larger than the limit", "dddd"}
+	release := make(chan struct{})
+	r := New("bytes", compileTimeSource(items), WithBuffer(10),
+		WithMaxInFlightBytes(10, func(s string) int64 { return int64(len(s)) })).
+		To(func(ctx context.Context, s string) error {
+			<-release
+			return nil
+		})
+
+	h, err := r.Start(context.Background())
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	if st := settle(t, h); st.Admitted != 2 || st.InFlightBytes != 8 {
+		t.Fatalf("expected two 4-byte items in flight, got %+v", st)
+	}
+	close(release)
+	if _, err := h.Wait(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if st := h.Stats(); st.Admitted != 5 || st.InFlightBytes != 0 {
+		t.Fatalf("expected every item through, including the oversized one, got %+v", st)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected a panic for a sizer of the wrong type")
+		}
+	}()
+	New("mismatch", compileTimeSource(items), WithMaxInFlightBytes(10, func(n int) int64 { return 1 }))
+}
+
+func TestMaxInFlightBytesSizerPanicFailsRun(t *testing.T) {
+	t.Parallel()
+
+	res, err := New("sizer-panic", compileTimeSource([]string{"a", "bb"}), WithMaxInFlightBytes(8, func(s string) int64 {
+		if s == "bb" {
+			panic("no size")
+		}
+		return int64(len(s))
+	})).
+		To(func(ctx context.Context, s string) error { return nil }).
+		Run(context.Background())
+	if res.State() != StateFailed || err == nil || !strings.Contains(err.Error(), "panic in in-flight sizer: no size") {
+		t.Fatalf("expected a failed run with the sizer panic, got %v (%v)", res.State(), err)
+	}
+}
+
+func TestMaxInFlightReleasedByDrops(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	defer close(release)
+	r := New("drops", compileTimeSource(make([]int, 100)), WithMaxInFlight(2)).
+		Then(func(ctx context.Context, n int) (int, error) { return n, nil }, WithStageBuffer(0, OverflowDropNewest)).
+		To(func(ctx context.Context, n int) error {
+			<-release
+			return nil
+		})
+
+	h, err := r.Start(context.Background())
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	// Dropped items leave the pipeline, so the source is never starved.
+	if st := settle(t, h); st.Admitted != 100 || st.InFlight != 1 {
+		t.Fatalf("expected drops to free the budget, got %+v", st)
+	}
+}
+
+func TestMaxInFlightRejectsUnfillableBatches(t *testing.T) {
+	t.Parallel()
+
+	batch := func(ctx context.Context, in []int) ([]int, error) { return in, nil }
+	_, err := New("unfillable", endlessSource, WithMaxInFlight(4), WithBuildErrors()).
+		ThenBatch(batch, BatchPolicy{Size: 10}, WithStageName("group")).
+		ToTx(&ledger{}, TxPolicy{Size: 5}).
+		Build()
+	var be *BuildError
+	if !errors.As(err, &be) || len(be.Problems) != 2 {
+		t.Fatalf("expected 2 problems, got %v", err)
+	}
+	if p := be.Problems[0]; p.Stage != 0 || p.Name != "group" || !strings.Contains(p.Err.Error(), "batch size 10 exceeds the in-flight limit 4") {
+		t.Fatalf("unexpected batch problem %+v", p)
+	}
+	if p := be.Problems[1]; !p.Sink || !strings.Contains(p.Err.Error(), "transaction size 5 exceeds the in-flight limit 4") {
+		t.Fatalf("unexpected sink problem %+v", p)
+	}
+
+	// A MaxWait flushes partial batches, so the limit is reached but not stuck.
+	var sunk int
+	r := New("flushed", compileTimeSource(make([]int, 100)), WithMaxInFlight(4)).
+		ThenBatch(batch, BatchPolicy{Size: 10, MaxWait: time.Millisecond}).
+		To(func(ctx context.Context, n int) error { sunk++; return nil })
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := r.Run(ctx); err != nil || sunk != 100 {
+		t.Fatalf("expected 100 items, got %d (%v)", sunk, err)
+	}
+}
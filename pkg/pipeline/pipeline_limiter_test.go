@@ -0,0 +1,129 @@
+package pipeline
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// backend simulates a remote service that can serve capacity calls at once.
+type backend struct {
+	capacity int64
+	inFlight atomic.Int64
+	peak     atomic.Int64
+}
+
+func (b *backend) call(ctx context.Context, latency func(inFlight int64) time.Duration) {
+	cur := b.inFlight.Add(1)
+	defer b.inFlight.Add(-1)
+	for {
+		p := b.peak.Load()
+		if cur <= p || b.peak.CompareAndSwap(p, cur) {
+			break
+		}
+	}
+	if cur > b.capacity {
+		ReportOverload(ctx)
+	}
+	time.Sleep(latency(cur))
+}
+
+func runLimited(t *testing.T, l Limiter, b *backend, latency func(int64) time.Duration) StageStats {
+	t.Helper()
+
+	r := New("limited", endlessSource).
+		Then(func(ctx context.Context, n int) (int, error) {
+			b.call(ctx, latency)
+			return n, nil
+		}, WithStageLimiter(l)).
+		To(func(ctx context.Context, n int) error { return nil })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	h, err := r.Start(ctx)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	time.Sleep(400 * time.Millisecond)
+	st := h.Stats().Stages[0]
+	h.Cancel()
+	h.Wait()
+	return st
+}
+
+func TestAIMDLimiterBacksOffOnOverload(t *testing.T) {
+	t.Parallel()
+
+	b := &backend{capacity: 4}
+	st := runLimited(t, Limiter{Algorithm: LimitAIMD, Initial: 1, Max: 32}, b,
+		func(int64) time.Duration { return time.Millisecond })
+
+	if st.Workers != 32 {
+		t.Fatalf("expected one worker per possible call, got %d", st.Workers)
+	}
+	if st.Limit < 1 || st.Limit > 8 {
+		t.Fatalf("expected the limit to settle near the backend capacity, got %d", st.Limit)
+	}
+	if st.InFlight > st.Limit+1 {
+		t.Fatalf("in-flight calls %d exceed limit %d", st.InFlight, st.Limit)
+	}
+	if b.peak.Load() >= 32 {
+		t.Fatalf("limiter never held back calls: peak %d", b.peak.Load())
+	}
+}
+
+func TestGradientLimiterFollowsLatency(t *testing.T) {
+	t.Parallel()
+
+	// Latency is flat up to 4 concurrent calls, then grows with the queue.
+	b := &backend{capacity: 1 << 30}
+	st := runLimited(t, Limiter{Algorithm: LimitGradient, Initial: 1, Max: 64}, b,
+		func(n int64) time.Duration {
+			if n <= 4 {
+				return time.Millisecond
+			}
+			return time.Duration(n-3) * time.Millisecond
+		})
+
+	if st.Limit <= 1 {
+		t.Fatalf("expected the limit to grow while latency is flat, got %d", st.Limit)
+	}
+	if st.Limit >= 64 {
+		t.Fatalf("expected rising latency to hold the limit below its maximum, got %d", st.Limit)
+	}
+}
+
+func TestLimiterDescribed(t *testing.T) {
+	t.Parallel()
+
+	r := New("described", endlessSource).
+		Then(func(ctx context.Context, n int) (int, error) { return n, nil }, WithStageLimiter(Limiter{Max: 10, Timeout: time.Second})).
+		To(func(ctx context.Context, n int) error { return nil })
+
+	info := r.Describe().Stages[0]
+	if info.Concurrency != 10 {
+		t.Fatalf("expected the limited stage to start 10 workers, got %d", info.Concurrency)
+	}
+	l := info.Limiter
+	if l == nil || l.Algorithm != LimitAIMD || l.Min != 1 || l.Max != 10 || l.Initial != 1 || l.Timeout != time.Second {
+		t.Fatalf("unexpected limiter description: %+v", l)
+	}
+
+	// Outside a limited stage reporting overload is a no-op.
+	ReportOverload(context.Background())
+}
+
+func TestLimiterRejectsUnknownAlgorithm(t *testing.T) {
+	t.Parallel()
+
+	_, err := New("unknown", endlessSource, WithBuildErrors()).
+		Then(func(ctx context.Context, n int) (int, error) { return n, nil },
+			WithStageName("call"), WithStageLimiter(Limiter{Algorithm: "vegas"})).
+		To(func(ctx context.Context, n int) error { return nil }).
+		Build()
+	if err == nil || !strings.Contains(err.Error(), `unknown limiter algorithm "vegas"`) {
+		t.Fatalf("expected an unknown algorithm error, got %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// runGatedSink runs 100 items through one pass-through stage whose output
+// queue holds two items and a sink that blocks until the stage has finished
+// emitting.
+func runGatedSink(t *testing.T, overflow OverflowPolicy, opts ...Option) (sunk []int, r *Runnable, drops map[string]int) {
+	t.Helper()
+
+	items := make([]int, 100)
+	for i := range items {
+		items[i] = i
+	}
+	var (
+		mu      sync.Mutex
+		release = make(chan struct{})
+	)
+	drops = map[string]int{}
+	opts = append(opts, WithOnDrop(func(stage string, item any) {
+		mu.Lock()
+		drops[stage]++
+		mu.Unlock()
+	}))
+
+	r = New("overflow", compileTimeSource(items), opts...).
+		Then(func(ctx context.Context, n int) (int, error) { return n, nil }, WithStageName("fresh"), WithStageBuffer(2, overflow)).
+		To(func(ctx context.Context, n int) error {
+			<-release
+			mu.Lock()
+			sunk = append(sunk, n)
+			mu.Unlock()
+			return nil
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	h, err := r.Start(ctx)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		st := h.Stats()
+		// The stage's workers are gone once it has emitted its last item.
+		if st.Stages[0].In == 100 && st.Stages[0].Workers == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("stage never consumed its input: %+v", st)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	if _, err := h.Wait(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	return sunk, r, drops
+}
+
+func TestOverflowDropNewestKeepsOldest(t *testing.T) {
+	t.Parallel()
+
+	sunk, r, drops := runGatedSink(t, OverflowDropNewest)
+
+	// The sink holds one item and the queue two; everything else is dropped.
+	if len(sunk) != 3 || sunk[0] != 0 || sunk[1] != 1 {
+		t.Fatalf("expected the first items to survive, got %v", sunk)
+	}
+	st := r.Stats()
+	if st.Dropped != 97 || st.Stages[0].Dropped != 97 || drops["fresh"] != 97 {
+		t.Fatalf("expected 97 drops, got stats %d/%d, callback %v", st.Dropped, st.Stages[0].Dropped, drops)
+	}
+	if got := r.Describe().Stages[0].Overflow; got != OverflowDropNewest || got.String() != "drop-newest" {
+		t.Fatalf("unexpected overflow description: %v", got)
+	}
+}
+
+func TestOverflowDropOldestKeepsFreshest(t *testing.T) {
+	t.Parallel()
+
+	sunk, r, _ := runGatedSink(t, OverflowDropOldest)
+
+	if len(sunk) != 3 || sunk[1] != 98 || sunk[2] != 99 {
+		t.Fatalf("expected the freshest items to survive, got %v", sunk)
+	}
+	if st := r.Stats(); st.Dropped != 97 || st.Stages[0].Out != 100 {
+		t.Fatalf("expected 100 emitted and 97 evicted, got %+v", st)
+	}
+}
+
+func TestOverflowSampleAndSourceQueue(t *testing.T) {
+	t.Parallel()
+
+	// Every item passes through a slow sink; while the queue is full one in
+	// four arrivals waits for space.
+	items := make([]int, 200)
+	var sunk atomic.Int64
+	r := New("sample", compileTimeSource(items)).
+		Then(func(ctx context.Context, n int) (int, error) { return n, nil }, WithStageBuffer(1, OverflowSample(4))).
+		To(func(ctx context.Context, n int) error {
+			time.Sleep(100 * time.Microsecond)
+			sunk.Add(1)
+			return nil
+		})
+	if _, err := r.Run(context.Background()); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	st := r.Stats()
+	if st.Dropped == 0 || sunk.Load()+st.Dropped != 200 {
+		t.Fatalf("expected sampled drops to account for every item, sunk %d dropped %d", sunk.Load(), st.Dropped)
+	}
+	if sunk.Load() < 200/4 {
+		t.Fatalf("expected at least one in four items to survive, got %d", sunk.Load())
+	}
+
+	// WithBuffer sets the policy of the source queue too.
+	release := make(chan struct{})
+	var sourceDrops atomic.Int64
+	r = New("source", compileTimeSource(items), WithBuffer(0, OverflowDropNewest), WithOnDrop(func(stage string, item any) {
+		if stage == "source" {
+			sourceDrops.Add(1)
+		}
+	})).
+		Then(func(ctx context.Context, n int) (int, error) {
+			<-release
+			return n, nil
+		}).
+		To(func(ctx context.Context, n int) error { return nil })
+	h, err := r.Start(context.Background())
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for st := h.Stats(); st.Admitted+st.Dropped != 200; st = h.Stats() {
+		if time.Now().After(deadline) {
+			t.Fatalf("source was never drained: %+v", st)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	h.Wait()
+	if st := r.Stats(); st.Dropped == 0 || st.Dropped != sourceDrops.Load() {
+		t.Fatalf("expected source drops to be reported, got %d and %d", st.Dropped, sourceDrops.Load())
+	}
+}
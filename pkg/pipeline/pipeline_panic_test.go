@@ -2,6 +2,7 @@ package pipeline
 
 import (
 	"context"
+	"strings"
 	"testing"
 	"time"
 )
@@ -30,3 +31,41 @@ func TestPipelinePanicBecomesError(t *testing.T) {
 		t.Fatalf("expected error")
 	}
 }
+
+type panickyLedger struct {
+	ledger
+	in string
+}
+
+func (l *panickyLedger) Begin(ctx context.Context) error {
+	if l.in == "begin" {
+		panic("begin")
+	}
+	return l.ledger.Begin(ctx)
+}
+
+func (l *panickyLedger) Commit(ctx context.Context) error {
+	if l.in == "commit" {
+		panic("commit")
+	}
+	return l.ledger.Commit(ctx)
+}
+
+func TestSinkPanicFailsRun(t *testing.T) {
+	t.Parallel()
+
+	res, err := New("sink-panic", compileTimeSource([]int{1, 2})).
+		To(func(ctx context.Context, n int) error { panic("sink boom") }).
+		Run(context.Background())
+	if res.State() != StateFailed || err == nil || !strings.Contains(err.Error(), "panic in sink: sink boom") {
+		t.Fatalf("expected a failed run with the panic, got %v (%v)", res.State(), err)
+	}
+
+	for _, in := range []string{"begin", "commit"} {
+		l := &panickyLedger{in: in}
+		res, err := New("tx-panic", compileTimeSource([]int{1, 2})).ToTx(l, TxPolicy{Size: 2}).Run(context.Background())
+		if res.State() != StateFailed || err == nil || !strings.Contains(err.Error(), "panic in sink "+in) {
+			t.Fatalf("%s: expected a failed run with the panic, got %v (%v)", in, res.State(), err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHandlePauseHoldsIntake(t *testing.T) {
+	t.Parallel()
+
+	r := New("pause", endlessSource, WithBuffer(4)).
+		Then(func(ctx context.Context, n int) (int, error) { return n, nil }).
+		To(func(ctx context.Context, n int) error { return nil })
+
+	h, err := r.Start(context.Background())
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer h.Cancel()
+
+	for h.Stats().Admitted < 10 {
+		time.Sleep(time.Millisecond)
+	}
+	h.Pause()
+	if h.State() != PhasePaused {
+		t.Fatalf("expected paused, got %s", h.State())
+	}
+
+	// At most the item already taken from the source is admitted after Pause.
+	time.Sleep(5 * time.Millisecond)
+	before := h.Stats()
+	time.Sleep(30 * time.Millisecond)
+	after := h.Stats()
+	if after.Admitted != before.Admitted {
+		t.Fatalf("expected no intake while paused: %d -> %d", before.Admitted, after.Admitted)
+	}
+	if after.Sink.Out != after.Admitted {
+		t.Fatalf("expected admitted items to keep flowing while paused: admitted=%d sunk=%d", after.Admitted, after.Sink.Out)
+	}
+
+	h.Resume()
+	if h.State() != PhaseRunning {
+		t.Fatalf("expected running, got %s", h.State())
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for h.Stats().Admitted <= after.Admitted {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected intake to resume")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	h.Pause()
+	h.Stop(time.Second)
+	if res, err := h.Wait(); err != nil || res.State() != StateStopped {
+		t.Fatalf("expected Stop to drain a paused run, got %s %v", res.State(), err)
+	}
+}
+
+func TestHandlePauseSuspendsBatchTimers(t *testing.T) {
+	t.Parallel()
+
+	src := func(ctx context.Context) (<-chan int, error) {
+		ch := make(chan int)
+		go func() {
+			defer close(ch)
+			for i := 1; i <= 2; i++ {
+				select {
+				case <-ctx.Done():
+					return
+				case ch <- i:
+				}
+			}
+			<-ctx.Done()
+		}()
+		return ch, nil
+	}
+
+	r := New("timers", src, WithSuspendBatchTimers()).
+		ThenBatch(func(ctx context.Context, in []int) ([]int, error) { return in, nil }, BatchPolicy{Size: 100, MaxWait: 150 * time.Millisecond}).
+		To(func(ctx context.Context, n int) error { return nil })
+
+	h, err := r.Start(context.Background())
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer h.Cancel()
+
+	for h.Stats().Stages[0].In < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	h.Pause()
+
+	time.Sleep(300 * time.Millisecond)
+	if out := h.Stats().Sink.Out; out != 0 {
+		t.Fatalf("expected MaxWait to be suspended while paused, %d items flushed", out)
+	}
+
+	h.Resume()
+	deadline := time.Now().Add(2 * time.Second)
+	for h.Stats().Sink.Out < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected partial batch to flush after resume")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
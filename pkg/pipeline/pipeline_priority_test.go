@@ -0,0 +1,191 @@
+package pipeline
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPriorityOvertakesQueuedItems(t *testing.T) {
+	t.Parallel()
+
+	items := make([]int, 20)
+	for i := range items {
+		items[i] = i
+	}
+	release := make(chan struct{})
+	var sunk []int
+	r := New("priority", compileTimeSource(items), WithBuffer(32),
+		WithPriority(func(n int) int { return n / 10 })).
+		Then(func(ctx context.Context, n int) (int, error) { return n, nil }).
+		To(func(ctx context.Context, n int) error {
+			<-release
+			sunk = append(sunk, n)
+			return nil
+		})
+	if d := r.Describe(); !d.Prioritized || d.PriorityAging != time.Second {
+		t.Fatalf("expected a prioritized description with default aging, got %+v", d)
+	}
+
+	h, err := r.Start(context.Background())
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for h.Stats().Stages[0].Out != 20 {
+		if time.Now().After(deadline) {
+			t.Fatalf("stage never emitted every item: %+v", h.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n := h.Stats().Sink.Queued; n != 19 {
+		t.Fatalf("expected 19 items queued for the sink, got %d", n)
+	}
+	close(release)
+	if _, err := h.Wait(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	// The sink took one item before the rest were queued; after it, the high
+	// lane goes first and each lane keeps its order.
+	want := append(slices.Clone(items[10:]), items[:10]...)
+	want = slices.DeleteFunc(want, func(n int) bool { return n == sunk[0] })
+	if !slices.Equal(sunk[1:], want) {
+		t.Fatalf("unexpected order %v", sunk)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected a panic for a priority function of the wrong type")
+		}
+	}()
+	New("mismatch", compileTimeSource(items), WithPriority(func(s string) int { return 0 }))
+}
+
+func TestPriorityAgingPreventsStarvation(t *testing.T) {
+	t.Parallel()
+
+	found := make(chan struct{})
+	r := New("aging", endlessSource, WithBuffer(16), WithPriorityAging(2*time.Millisecond),
+		WithPriority(func(n int) int {
+			if n == 0 {
+				return 0
+			}
+			return 10
+		})).
+		Then(func(ctx context.Context, n int) (int, error) { return n, nil }).
+		To(func(ctx context.Context, n int) error {
+			if n == 0 {
+				close(found)
+			}
+			time.Sleep(time.Millisecond)
+			return nil
+		})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h, err := r.Start(ctx)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	select {
+	case <-found:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("low-priority item starved: %+v", h.Stats())
+	}
+	cancel()
+	h.Wait()
+}
+
+func TestPriorityBatchOutputsTakeHighestInput(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	var urgent, sunk []int
+	r := New("batch", compileTimeSource([]int{1, 2, 3, 4, 5, 6}), WithBuffer(16),
+		WithPriority(func(n int) int {
+			if n == 6 {
+				return 1
+			}
+			return 0
+		})).
+		ThenBatch(func(ctx context.Context, in []int) ([]int, error) {
+			if slices.Contains(in, 6) {
+				urgent = slices.Clone(in)
+			}
+			return in, nil
+		}, BatchPolicy{Size: 3}).
+		To(func(ctx context.Context, n int) error {
+			<-release
+			sunk = append(sunk, n)
+			return nil
+		})
+
+	h, err := r.Start(context.Background())
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for h.Stats().Stages[0].Out != 6 {
+		if time.Now().After(deadline) {
+			t.Fatalf("batch stage never finished: %+v", h.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	if _, err := h.Wait(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	// Every output of the batch holding 6 inherits its priority, so after the
+	// item the sink took early they all come before the other batch.
+	rest := sunk[1:]
+	n := len(slices.DeleteFunc(slices.Clone(urgent), func(v int) bool { return v == sunk[0] }))
+	for _, v := range rest[:n] {
+		if !slices.Contains(urgent, v) {
+			t.Fatalf("expected the outputs of batch %v first, got %v", urgent, sunk)
+		}
+	}
+}
+
+func TestPriorityStatsWhileStarting(t *testing.T) {
+	t.Parallel()
+
+	var r *Runnable
+	src := func(ctx context.Context) (<-chan int, error) {
+		ch := make(chan int)
+		go func() {
+			defer close(ch)
+			// Stats may be read while Start is still wiring the queues.
+			for range 100 {
+				_ = r.Stats()
+			}
+		}()
+		return ch, nil
+	}
+	r = New("starting", src, WithPriority(func(n int) int { return n })).
+		Then(func(ctx context.Context, n int) (int, error) { return n, nil }).
+		Then(func(ctx context.Context, n int) (int, error) { return n, nil }).
+		To(func(ctx context.Context, n int) error { return nil })
+	if _, err := r.Run(context.Background()); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+}
+
+func TestPriorityFunctionPanicFailsRun(t *testing.T) {
+	t.Parallel()
+
+	res, err := New("prio-panic", compileTimeSource([]int{1, 2, 3}), WithPriority(func(n int) int {
+		if n == 2 {
+			panic("no priority")
+		}
+		return n
+	})).
+		To(func(ctx context.Context, n int) error { return nil }).
+		Run(context.Background())
+	if res.State() != StateFailed || err == nil || !strings.Contains(err.Error(), "panic in priority function: no priority") {
+		t.Fatalf("expected a failed run with the priority panic, got %v (%v)", res.State(), err)
+	}
+}
@@ -0,0 +1,299 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// events records the lifecycle calls of test processors.
+type events struct {
+	mu  sync.Mutex
+	log []string
+}
+
+func (e *events) add(ev string) {
+	e.mu.Lock()
+	e.log = append(e.log, ev)
+	e.mu.Unlock()
+}
+
+func (e *events) count(ev string) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	n := 0
+	for _, l := range e.log {
+		if l == ev {
+			n++
+		}
+	}
+	return n
+}
+
+func (e *events) wait(t *testing.T, ev string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for e.count(ev) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d %q, got %d", n, ev, e.count(ev))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// resource is a test resource that must be open while it is used.
+type resource struct {
+	name     string
+	ev       *events
+	open     bool
+	openErr  error
+	closeErr error
+}
+
+func (r *resource) Open(ctx context.Context) error {
+	r.ev.add(r.name + " open")
+	r.open = r.openErr == nil
+	return r.openErr
+}
+
+func (r *resource) Close(ctx context.Context) error {
+	if ctx.Err() != nil {
+		r.ev.add(r.name + " close cancelled")
+	}
+	r.ev.add(r.name + " close")
+	r.open = false
+	return r.closeErr
+}
+
+func (r *resource) use() error {
+	if !r.open {
+		return errors.New(r.name + " used while closed")
+	}
+	return nil
+}
+
+type doubler struct{ resource }
+
+func (d *doubler) Process(ctx context.Context, n int) (int, error) {
+	if n < 0 {
+		panic("negative")
+	}
+	return 2 * n, d.use()
+}
+
+type summer struct{ resource }
+
+func (s *summer) ProcessBatch(ctx context.Context, in []int) ([]int, error) {
+	total := 0
+	for _, n := range in {
+		total += n
+	}
+	return []int{total}, s.use()
+}
+
+type intSink struct {
+	resource
+	mu    sync.Mutex
+	items []int
+}
+
+func (s *intSink) Write(ctx context.Context, n int) error {
+	s.mu.Lock()
+	s.items = append(s.items, n)
+	s.mu.Unlock()
+	return s.use()
+}
+
+func TestProcessorsOpenAndCloseOncePerRun(t *testing.T) {
+	t.Parallel()
+
+	ev := &events{}
+	d := &doubler{resource{name: "double", ev: ev}}
+	s := &summer{resource{name: "sum", ev: ev}}
+	sink := &intSink{resource: resource{name: "sink", ev: ev}}
+
+	src := NewTyped("processors", compileTimeSource([]int{1, 2, 3, 4}))
+	doubled := ThenProcessor(src, Processor[int, int](d), WithStageConcurrency(3))
+	r := ToSink(ThenBatchProcessor(doubled, BatchProcessor[int, int](s), BatchPolicy{Size: 2}), Sink[int](sink))
+
+	for run := 1; run <= 2; run++ {
+		if _, err := r.Run(context.Background()); err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+		for _, name := range []string{"double", "sum", "sink"} {
+			if ev.count(name+" open") != run || ev.count(name+" close") != run {
+				t.Fatalf("run %d: expected %s opened and closed once per run, got %v", run, name, ev.log)
+			}
+		}
+	}
+	total := 0
+	for _, n := range sink.items {
+		total += n
+	}
+	if total != 40 {
+		t.Fatalf("expected the items of two runs to sum to 40, got %v", sink.items)
+	}
+}
+
+type counter struct {
+	resource
+	seen int
+}
+
+func (c *counter) Process(ctx context.Context, n int) (int, error) {
+	c.seen++ // per-worker state needs no lock
+	return n, c.use()
+}
+
+func TestPerWorkerProcessors(t *testing.T) {
+	t.Parallel()
+
+	ev := &events{}
+	var (
+		mu       sync.Mutex
+		counters []*counter
+	)
+	newCounter := func() Processor[int, int] {
+		c := &counter{resource: resource{name: "worker", ev: ev}}
+		mu.Lock()
+		counters = append(counters, c)
+		mu.Unlock()
+		return c
+	}
+
+	r := To(ThenPerWorker(NewTyped("per-worker", endlessSource), newCounter, WithStageConcurrency(3)),
+		func(ctx context.Context, n int) error { return nil })
+	h, err := r.Start(context.Background())
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	ev.wait(t, "worker open", 3)
+	if _, err := h.SetConcurrency(0, 1); err != nil {
+		t.Fatalf("set concurrency: %v", err)
+	}
+	// Workers that stop close their processor while the stage runs on.
+	ev.wait(t, "worker close", 2)
+
+	h.Stop(0)
+	if _, err := h.Wait(); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+	if n := ev.count("worker close"); n != 3 {
+		t.Fatalf("expected every processor closed, got %d", n)
+	}
+	if len(counters) != 3 {
+		t.Fatalf("expected one processor per worker, got %d", len(counters))
+	}
+}
+
+func TestProcessorsCloseOnFailureAndCancellation(t *testing.T) {
+	t.Parallel()
+
+	ev := &events{}
+	d := &doubler{resource{name: "double", ev: ev}}
+	sink := &intSink{resource: resource{name: "sink", ev: ev}}
+	r := ToSink(ThenProcessor(NewTyped("panics", compileTimeSource([]int{1, -1, 2})), Processor[int, int](d)), Sink[int](sink))
+	res, err := r.Run(context.Background())
+	if err == nil || res.State() != StateFailed {
+		t.Fatalf("expected a failed run, got %v (%v)", res.State(), err)
+	}
+	if ev.count("double close") != 1 || ev.count("sink close") != 1 {
+		t.Fatalf("expected both closed after a panic, got %v", ev.log)
+	}
+
+	ev = &events{}
+	d = &doubler{resource{name: "double", ev: ev}}
+	sink = &intSink{resource: resource{name: "sink", ev: ev}}
+	r = ToSink(ThenProcessor(NewTyped("cancelled", endlessSource), Processor[int, int](d)), Sink[int](sink))
+	ctx, cancel := context.WithCancel(context.Background())
+	h, err := r.Start(ctx)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	ev.wait(t, "sink open", 1)
+	cancel()
+	if res, _ := h.Wait(); res.State() != StateCancelled {
+		t.Fatalf("expected a cancelled run, got %v", res.State())
+	}
+	if ev.count("double close") != 1 || ev.count("sink close") != 1 {
+		t.Fatalf("expected both closed after cancellation, got %v", ev.log)
+	}
+	if n := ev.count("double close cancelled") + ev.count("sink close cancelled"); n != 0 {
+		t.Fatalf("expected Close to get a live context, got %v", ev.log)
+	}
+}
+
+func TestOpenAndCloseErrorsFailRun(t *testing.T) {
+	t.Parallel()
+
+	refused := errors.New("connection refused")
+	ev := &events{}
+	d := &doubler{resource{name: "double", ev: ev}}
+	sink := &intSink{resource: resource{name: "sink", ev: ev, openErr: refused}}
+	r := ToSink(ThenProcessor(NewTyped("open", compileTimeSource([]int{1, 2, 3})), Processor[int, int](d)), Sink[int](sink))
+	res, err := r.Run(context.Background())
+	if !errors.Is(err, refused) || res.State() != StateFailed {
+		t.Fatalf("expected failed with %v, got %v (%v)", refused, res.State(), err)
+	}
+	if len(sink.items) != 0 || ev.count("sink close") != 0 || ev.count("double close") != 1 {
+		t.Fatalf("expected only the opened processor closed and nothing written, got %v %v", ev.log, sink.items)
+	}
+
+	unflushed := errors.New("flush failed")
+	ev = &events{}
+	r = ThenPerWorker(NewTyped("close", compileTimeSource([]int{1, 2, 3})), func() Processor[int, int] {
+		return &counter{resource: resource{name: "worker", ev: ev, closeErr: unflushed}}
+	}, WithStageConcurrency(2)).Pipeline().To(func(ctx context.Context, n int) error { return nil })
+	res, err = r.Run(context.Background())
+	if !errors.Is(err, unflushed) || res.State() != StateFailed {
+		t.Fatalf("expected failed with %v, got %v (%v)", unflushed, res.State(), err)
+	}
+	if n := ev.count("worker close"); n != 2 {
+		t.Fatalf("expected both workers closed, got %d", n)
+	}
+}
+
+type openLedger struct {
+	*ledger
+	resource
+}
+
+func TestTxSinkLifecycle(t *testing.T) {
+	t.Parallel()
+
+	ev := &events{}
+	l := &openLedger{ledger: &ledger{}, resource: resource{name: "ledger", ev: ev}}
+	r := New("tx-lifecycle", compileTimeSource([]int{1, 2, 3})).ToTx(l, TxPolicy{Size: 2})
+	if _, err := r.Run(context.Background()); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if want := []string{"ledger open", "ledger close"}; !slices.Equal(ev.log, want) {
+		t.Fatalf("expected %v, got %v", want, ev.log)
+	}
+	if len(l.committed) != 3 {
+		t.Fatalf("expected 3 committed items, got %v", l.committed)
+	}
+}
+
+type panickySink struct{ intSink }
+
+func (s *panickySink) Write(ctx context.Context, n int) error {
+	panic("write")
+}
+
+func TestSinkClosesAfterWritePanics(t *testing.T) {
+	t.Parallel()
+
+	ev := &events{}
+	sink := &panickySink{intSink{resource: resource{name: "sink", ev: ev}}}
+	res, err := ToSink(NewTyped("write-panics", compileTimeSource([]int{1, 2})), Sink[int](sink)).Run(context.Background())
+	if err == nil || res.State() != StateFailed {
+		t.Fatalf("expected a failed run, got %v (%v)", res.State(), err)
+	}
+	if want := []string{"sink open", "sink close"}; !slices.Equal(ev.log, want) {
+		t.Fatalf("expected %v, got %v", want, ev.log)
+	}
+}
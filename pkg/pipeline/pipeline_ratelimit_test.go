@@ -0,0 +1,91 @@
+package pipeline
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimitSpacesHandlerCalls(t *testing.T) {
+	t.Parallel()
+
+	items := make([]int, 11)
+	var calls atomic.Int64
+	r := New("rate", compileTimeSource(items)).
+		Then(func(ctx context.Context, n int) (int, error) {
+			calls.Add(1)
+			return n, nil
+		}, WithRateLimit(100, 1), WithStageConcurrency(4)).
+		To(func(ctx context.Context, n int) error { return nil })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	if _, err := r.Run(ctx); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	// The first call uses the burst; the other ten wait 10ms each.
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Fatalf("expected at least ~100ms at 100/s, took %v", elapsed)
+	}
+	if calls.Load() != 11 {
+		t.Fatalf("expected 11 calls, got %d", calls.Load())
+	}
+}
+
+func TestSharedRateLimitAcrossStages(t *testing.T) {
+	t.Parallel()
+
+	shared := NewRateLimiter(100, 1)
+	items := make([]int, 6)
+	r := New("shared", compileTimeSource(items)).
+		Then(func(ctx context.Context, n int) (int, error) { return n, nil }, WithSharedRateLimit(shared)).
+		ThenBatch(func(ctx context.Context, in []int) ([]int, error) { return in, nil }, BatchPolicy{Size: 1}, WithSharedRateLimit(shared)).
+		To(func(ctx context.Context, n int) error { return nil })
+
+	d := r.Describe()
+	if d.Stages[0].RateLimit != shared || d.Stages[1].RateLimit != shared {
+		t.Fatalf("expected both stages to report the shared limiter")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	if _, err := r.Run(ctx); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	// Twelve calls from one budget: 110ms after the burst.
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("expected stages to share the budget, took %v", elapsed)
+	}
+}
+
+func TestThrottleRespectsCancellation(t *testing.T) {
+	t.Parallel()
+
+	var sunk atomic.Int64
+	r := New("throttle", endlessSource).
+		Throttle(1, 2).
+		To(func(ctx context.Context, n int) error {
+			sunk.Add(1)
+			return nil
+		})
+	if d := r.Describe(); d.Stages[0].Name != "throttle" || d.Stages[0].RateLimit.Rate() != 1 {
+		t.Fatalf("unexpected throttle stage: %+v", d.Stages[0])
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	res, _ := r.Run(ctx)
+	if res.State() != StateCancelled {
+		t.Fatalf("expected cancelled, got %s", res.State())
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("throttle wait ignored cancellation: %v", elapsed)
+	}
+	if sunk.Load() != 2 {
+		t.Fatalf("expected only the burst to pass, got %d", sunk.Load())
+	}
+}
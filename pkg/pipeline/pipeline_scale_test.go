@@ -0,0 +1,128 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func waitWorkers(t *testing.T, h *Handle, stage int, ok func(workers int) bool) int {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		w := h.Stats().Stages[stage].Workers
+		if ok(w) {
+			return w
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected worker count %d for stage %d", w, stage)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSetConcurrencyResizesRunningStage(t *testing.T) {
+	t.Parallel()
+
+	var inFlight, peak atomic.Int64
+	release := make(chan struct{})
+	r := New("resize", endlessSource).
+		Then(func(ctx context.Context, n int) (int, error) {
+			cur := inFlight.Add(1)
+			for {
+				p := peak.Load()
+				if cur <= p || peak.CompareAndSwap(p, cur) {
+					break
+				}
+			}
+			<-release
+			inFlight.Add(-1)
+			return n, nil
+		}, WithStageConcurrency(2)).
+		ThenBatch(func(ctx context.Context, in []int) ([]int, error) { return in, nil }, BatchPolicy{Size: 4}).
+		To(func(ctx context.Context, n int) error { return nil })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	h, err := r.Start(ctx)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+
+	waitWorkers(t, h, 0, func(w int) bool { return w == 2 })
+	if n, err := h.SetConcurrency(0, 6); err != nil || n != 6 {
+		t.Fatalf("expected 6 workers, got %d, %v", n, err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for inFlight.Load() < 6 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 6 busy workers, got %d", inFlight.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if n, err := h.SetConcurrency(0, 1); err != nil || n != 1 {
+		t.Fatalf("expected 1 worker, got %d, %v", n, err)
+	}
+	close(release)
+	waitWorkers(t, h, 0, func(w int) bool { return w == 1 })
+
+	if _, err := h.SetConcurrency(1, 2); !errors.Is(err, ErrNotScalable) {
+		t.Fatalf("expected ErrNotScalable for a batch stage, got %v", err)
+	}
+	if _, err := h.SetConcurrency(2, 2); !errors.Is(err, ErrStageNotFound) {
+		t.Fatalf("expected ErrStageNotFound for the sink, got %v", err)
+	}
+
+	h.Cancel()
+	h.Wait()
+	if peak.Load() != 6 {
+		t.Fatalf("expected peak concurrency 6, got %d", peak.Load())
+	}
+	if w := h.Stats().Stages[0].Workers; w != 0 {
+		t.Fatalf("expected no workers after the run, got %d", w)
+	}
+}
+
+func TestAutoscaleFollowsLoad(t *testing.T) {
+	t.Parallel()
+
+	r := New("autoscale", endlessSource, WithBuffer(2)).
+		Then(func(ctx context.Context, n int) (int, error) {
+			time.Sleep(5 * time.Millisecond)
+			return n, nil
+		}, WithStageName("enrich"), WithStageAutoscale(1, 3)).
+		To(func(ctx context.Context, n int) error { return nil })
+
+	if a := r.Describe().Stages[0].Autoscale; a == nil || a.Min != 1 || a.Max != 3 {
+		t.Fatalf("unexpected autoscale description: %+v", a)
+	}
+	clamped := New("clamped", endlessSource).
+		Then(func(ctx context.Context, n int) (int, error) { return n, nil }, WithStageConcurrency(5), WithStageAutoscale(1, 3)).
+		To(func(ctx context.Context, n int) error { return nil })
+	if n := clamped.Describe().Stages[0].Concurrency; n != 3 {
+		t.Fatalf("expected the stage to start 3 workers, got %d", n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	h, err := r.Start(ctx)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+
+	// A saturated stage grows to its maximum and no further.
+	waitWorkers(t, h, 0, func(w int) bool { return w == 3 })
+	if n, _ := h.SetConcurrency(0, 10); n != 3 {
+		t.Fatalf("expected resize to be clamped to 3, got %d", n)
+	}
+
+	// Without intake the stage goes idle and shrinks.
+	h.Pause()
+	waitWorkers(t, h, 0, func(w int) bool { return w < 3 })
+
+	h.Cancel()
+	h.Wait()
+}
@@ -0,0 +1,127 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRunWithSignalsFlushesThenDrains(t *testing.T) {
+	t.Parallel()
+
+	src := func(ctx context.Context) (<-chan int, error) {
+		ch := make(chan int)
+		go func() {
+			defer close(ch)
+			for i := 1; i <= 3; i++ {
+				select {
+				case <-ctx.Done():
+					return
+				case ch <- i:
+				}
+			}
+			<-ctx.Done()
+		}()
+		return ch, nil
+	}
+
+	var (
+		mu      sync.Mutex
+		batches [][]int
+	)
+	r := New("signals", src).
+		ThenBatch(func(ctx context.Context, in []int) ([]int, error) {
+			mu.Lock()
+			batches = append(batches, append([]int(nil), in...))
+			mu.Unlock()
+			return in, nil
+		}, BatchPolicy{Size: 100}).
+		To(func(ctx context.Context, n int) error { return nil })
+
+	sigs := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	var (
+		res Result
+		err error
+	)
+	go func() {
+		defer close(done)
+		res, err = runWithSignals(context.Background(), r, SignalPolicy{FlushOnHangup: true}, []os.Signal{syscall.SIGTERM}, sigs)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for r.Stats().Stages[0].In < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("batch never received items: %+v", r.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	sigs <- syscall.SIGHUP
+	for r.Stats().Sink.Out < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected SIGHUP to flush the partial batch")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	sigs <- syscall.SIGTERM
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected SIGTERM to stop the run")
+	}
+
+	if err != nil || res.State() != StateStopped {
+		t.Fatalf("expected clean stop, got %s %v", res.State(), err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := [][]int{{1, 2, 3}}; !reflect.DeepEqual(batches, want) {
+		t.Fatalf("got %v want %v", batches, want)
+	}
+}
+
+func TestRunWithSignalsSecondSignalForcesCancel(t *testing.T) {
+	t.Parallel()
+
+	entered := make(chan struct{}, 1)
+	r := New("force", endlessSource).
+		To(func(ctx context.Context, n int) error {
+			select {
+			case entered <- struct{}{}:
+			default:
+			}
+			<-ctx.Done()
+			return ctx.Err()
+		})
+
+	sigs := make(chan os.Signal, 2)
+	done := make(chan struct{})
+	var (
+		res Result
+		err error
+	)
+	go func() {
+		defer close(done)
+		res, err = runWithSignals(context.Background(), r, SignalPolicy{}, []os.Signal{os.Interrupt}, sigs)
+	}()
+
+	<-entered
+	sigs <- os.Interrupt
+	sigs <- os.Interrupt
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected second signal to force cancellation")
+	}
+	if !errors.Is(err, context.Canceled) || res.State() != StateCancelled {
+		t.Fatalf("expected cancelled, got %s %v", res.State(), err)
+	}
+}
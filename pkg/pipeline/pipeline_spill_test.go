@@ -0,0 +1,140 @@
+package pipeline
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+type spillRun struct {
+	h       *Handle
+	release chan struct{}
+
+	mu   sync.Mutex
+	sunk []int
+}
+
+func startSpill(t *testing.T, n int, policy SpillPolicy) *spillRun {
+	t.Helper()
+
+	items := make([]int, n)
+	for i := range items {
+		items[i] = i
+	}
+	sr := &spillRun{release: make(chan struct{})}
+	r := New("spill", compileTimeSource(items)).
+		Then(func(ctx context.Context, n int) (int, error) { return n, nil },
+			WithStageBuffer(4), WithStageSpill(policy, JSONCodec[int]())).
+		To(func(ctx context.Context, n int) error {
+			<-sr.release
+			sr.mu.Lock()
+			sr.sunk = append(sr.sunk, n)
+			sr.mu.Unlock()
+			return nil
+		})
+
+	h, err := r.Start(context.Background())
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	sr.h = h
+	return sr
+}
+
+func (sr *spillRun) finish(t *testing.T, n int) {
+	t.Helper()
+	close(sr.release)
+	if _, err := sr.h.Wait(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if len(sr.sunk) != n {
+		t.Fatalf("expected %d items, got %d", n, len(sr.sunk))
+	}
+	for i, v := range sr.sunk {
+		if v != i {
+			t.Fatalf("items out of order at %d: got %d", i, v)
+		}
+	}
+}
+
+func TestSpillKeepsStageMovingAndReplaysInOrder(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	sr := startSpill(t, 500, SpillPolicy{Dir: dir, SegmentBytes: 256})
+
+	// With the sink blocked the stage still gets through all its input.
+	deadline := time.Now().Add(2 * time.Second)
+	for st := sr.h.Stats().Stages[0]; st.In != 500 || st.Workers != 0; st = sr.h.Stats().Stages[0] {
+		if time.Now().After(deadline) {
+			t.Fatalf("stage blocked despite spilling: %+v", st)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	sink := sr.h.Stats().Sink
+	if sink.OnDisk == 0 || sink.Spilled < int64(sink.OnDisk) {
+		t.Fatalf("expected items on disk, got %+v", sink)
+	}
+	runs, _ := os.ReadDir(dir)
+	if len(runs) != 1 {
+		t.Fatalf("expected one spill directory, got %d", len(runs))
+	}
+	if segs, _ := os.ReadDir(dir + "/" + runs[0].Name()); len(segs) < 2 {
+		t.Fatalf("expected several segments, got %d", len(segs))
+	}
+
+	sr.finish(t, 500)
+	if runs, _ := os.ReadDir(dir); len(runs) != 0 {
+		t.Fatalf("expected spill files to be removed after success, found %d", len(runs))
+	}
+}
+
+func TestSpillRemovedAfterCancel(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	sr := startSpill(t, 500, SpillPolicy{Dir: dir, SegmentBytes: 256})
+	deadline := time.Now().Add(2 * time.Second)
+	for sr.h.Stats().Sink.OnDisk == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("nothing spilled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	sr.h.Cancel()
+	close(sr.release)
+	if res, _ := sr.h.Wait(); res.State() != StateCancelled {
+		t.Fatalf("expected a cancelled run, got %v", res.State())
+	}
+	if runs, _ := os.ReadDir(dir); len(runs) != 0 {
+		t.Fatalf("expected spill files to be removed after cancellation, found %d", len(runs))
+	}
+}
+
+func TestSpillMaxBytesBlocks(t *testing.T) {
+	t.Parallel()
+
+	sr := startSpill(t, 200, SpillPolicy{Dir: t.TempDir(), MaxBytes: 64})
+
+	time.Sleep(50 * time.Millisecond)
+	if st := sr.h.Stats(); st.Stages[0].In >= 200 || st.Sink.OnDisk > 16 {
+		t.Fatalf("expected the size cap to hold the stage back, got %+v", st)
+	}
+	sr.finish(t, 200)
+}
+
+func TestSpillCodecTypeMustMatch(t *testing.T) {
+	t.Parallel()
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected a panic for a mismatched codec")
+		}
+	}()
+	New("mismatch", compileTimeSource([]int{1})).
+		Then(func(ctx context.Context, n int) (string, error) { return "", nil },
+			WithStageSpill(SpillPolicy{Dir: t.TempDir()}, GobCodec[int]()))
+}
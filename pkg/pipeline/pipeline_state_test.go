@@ -0,0 +1,223 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type reading struct {
+	Sensor string
+	Value  int
+}
+
+func TestStatefulThenKeepsRunningTotalsPerKey(t *testing.T) {
+	t.Parallel()
+
+	sensors := []string{"a", "b", "c", "d", "e", "f"}
+	var items []reading
+	for i := 0; i < 600; i++ {
+		items = append(items, reading{Sensor: sensors[i%len(sensors)], Value: i})
+	}
+
+	var (
+		mu     sync.Mutex
+		totals = map[string][]int{}
+	)
+	p := New("totals", compileTimeSource(items))
+	p = StatefulThen(p, func(r reading) string { return r.Sensor },
+		func(ctx context.Context, st KeyState[string, int], r reading) (reading, error) {
+			sum, _ := st.Get()
+			sum += r.Value
+			st.Set(sum)
+			if st.Key() != r.Sensor {
+				return reading{}, errors.New("state of the wrong key")
+			}
+			return reading{Sensor: r.Sensor, Value: sum}, nil
+		}, WithStageConcurrency(3), WithStageName("sum"))
+	r := p.To(func(ctx context.Context, r reading) error {
+		mu.Lock()
+		defer mu.Unlock()
+		totals[r.Sensor] = append(totals[r.Sensor], r.Value)
+		return nil
+	})
+
+	if info := r.Describe().Stages[0]; info.Kind != StageKindKeyed || info.Concurrency != 3 {
+		t.Fatalf("unexpected stage description %+v", info)
+	}
+	h, err := r.Start(context.Background())
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	if _, err := h.SetConcurrency(0, 5); !errors.Is(err, ErrNotScalable) {
+		t.Fatalf("expected ErrNotScalable, got %v", err)
+	}
+	if _, err := h.Wait(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	// Items of one key are handled in source order, so each key sees the
+	// prefix sums of its values.
+	for k, s := range sensors {
+		want, sum := []int{}, 0
+		for i := k; i < len(items); i += len(sensors) {
+			sum += i
+			want = append(want, sum)
+		}
+		got := totals[s]
+		if len(got) != len(want) {
+			t.Fatalf("sensor %s: expected %d totals, got %d", s, len(want), len(got))
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("sensor %s: total %d is %d, want %d", s, i, got[i], want[i])
+			}
+		}
+	}
+}
+
+func TestStatefulThenResumesStateWithCheckpoint(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	states := NewFileStateStore(dir)
+	boom := errors.New("boom")
+	var (
+		mu     sync.Mutex
+		failed bool
+		last   = map[int]int{}
+	)
+	p := NewResumable("resume", countingSource(200),
+		WithCheckpoints(NewFileCheckpointStore(dir), time.Millisecond),
+		WithStateStore(states))
+	p = StatefulThen(p, func(n int) int { return n % 5 },
+		func(ctx context.Context, st KeyState[int, int], n int) ([2]int, error) {
+			sum, _ := st.Get()
+			st.Set(sum + n)
+			return [2]int{n % 5, sum + n}, nil
+		}, WithStageConcurrency(2), WithStageName("sum"))
+	r := p.Then(func(ctx context.Context, v [2]int) ([2]int, error) {
+		time.Sleep(50 * time.Microsecond)
+		return v, nil
+	}, WithStageConcurrency(4)).
+		To(func(ctx context.Context, v [2]int) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if v[1] > 3000 && !failed {
+				failed = true
+				return boom
+			}
+			last[v[0]] = max(last[v[0]], v[1])
+			return nil
+		})
+
+	h, err := r.Start(context.Background())
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	if _, err := h.Wait(); !errors.Is(err, boom) {
+		t.Fatalf("expected the first run to fail, got %v", err)
+	}
+
+	h, err = r.Start(context.Background())
+	if err != nil {
+		t.Fatalf("restart: %v", err)
+	}
+	if _, err := h.Wait(); err != nil {
+		t.Fatalf("resumed run: %v", err)
+	}
+
+	want := map[int]int{}
+	for i := 0; i < 200; i++ {
+		want[i%5] += i
+	}
+	for k, sum := range want {
+		if last[k] != sum {
+			t.Fatalf("key %d: final total %d, want %d", k, last[k], sum)
+		}
+	}
+
+	snap, ok, err := states.Load(context.Background(), "resume")
+	if err != nil || !ok {
+		t.Fatalf("load snapshot: %v %v", ok, err)
+	}
+	if snap.Position != "199" {
+		t.Fatalf("expected the final snapshot at 199, got %q", snap.Position)
+	}
+	for k, sum := range want {
+		kb, _ := json.Marshal(k)
+		var got int
+		if err := json.Unmarshal(snap.Stages["sum"][string(kb)], &got); err != nil || got != sum {
+			t.Fatalf("key %d: snapshot total %d (%v), want %d", k, got, err, sum)
+		}
+	}
+	if cp, _ := h.Checkpoint(); cp.Position != snap.Position {
+		t.Fatalf("checkpoint %q does not match the snapshot %q", cp.Position, snap.Position)
+	}
+}
+
+func TestStatefulThenKeyPanicFailsRun(t *testing.T) {
+	t.Parallel()
+
+	items := []reading{{"a", 1}, {"b", 2}, {"bad", 3}, {"a", 4}}
+	p := New("keys", compileTimeSource(items))
+	p = StatefulThen(p, func(r reading) string {
+		if r.Sensor == "bad" {
+			panic("no key")
+		}
+		return r.Sensor
+	}, func(ctx context.Context, st KeyState[string, int], r reading) (reading, error) { return r, nil },
+		WithStageName("sum"))
+	res, err := p.To(func(ctx context.Context, r reading) error { return nil }).Run(context.Background())
+	if res.State() != StateFailed || err == nil || !strings.Contains(err.Error(), "panic in key function (sum): no key") {
+		t.Fatalf("expected a failed run with the key panic, got %v (%v)", res.State(), err)
+	}
+}
+
+func TestStatefulThenRejectsScalingOptions(t *testing.T) {
+	t.Parallel()
+
+	for name, opt := range map[string]StageOption{
+		"autoscale": WithStageAutoscale(1, 4),
+		"limiter":   WithStageLimiter(Limiter{Max: 4}),
+	} {
+		p := New(name, compileTimeSource([]reading{{"a", 1}}), WithBuildErrors())
+		p = StatefulThen(p, func(r reading) string { return r.Sensor },
+			func(ctx context.Context, st KeyState[string, int], r reading) (reading, error) { return r, nil }, opt)
+		_, err := p.To(func(ctx context.Context, r reading) error { return nil }).Build()
+		if err == nil || !strings.Contains(err.Error(), "autoscaling and limiters do not apply") {
+			t.Fatalf("%s: expected a wiring problem, got %v", name, err)
+		}
+	}
+}
+
+func TestStateStoreRequiresCheckpoints(t *testing.T) {
+	t.Parallel()
+
+	store := NewMemoryStateStore()
+	if _, ok, err := store.Load(context.Background(), "none"); ok || err != nil {
+		t.Fatalf("expected no snapshot, got %v %v", ok, err)
+	}
+	mustPanic := func(name string, build func()) {
+		t.Helper()
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("%s: expected a panic", name)
+			}
+		}()
+		build()
+	}
+	mustPanic("state store without checkpoints", func() {
+		NewResumable("s", countingSource(1), WithStateStore(store))
+	})
+	mustPanic("stateful stage without state store", func() {
+		p := NewResumable("s", countingSource(1), WithCheckpoints(NewFileCheckpointStore(t.TempDir()), time.Second))
+		p = StatefulThen(p, func(n int) int { return n },
+			func(ctx context.Context, st KeyState[int, int], n int) (int, error) { return n, nil })
+		p.To(func(ctx context.Context, n int) error { return nil })
+	})
+}
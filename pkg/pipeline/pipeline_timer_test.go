@@ -0,0 +1,307 @@
+package pipeline
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock that only moves when advanced.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	return c.add(d, 0)
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) Ticker {
+	return fakeTicker{c.add(d, d)}
+}
+
+func (c *fakeClock) add(d, period time.Duration) *fakeTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{c: c, ch: make(chan time.Time, 1), period: period}
+	t.arm(d)
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the clock forward, firing the timers that come due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	for _, t := range c.timers {
+		t.fire()
+	}
+}
+
+type fakeTimer struct {
+	c      *fakeClock
+	ch     chan time.Time
+	at     time.Time
+	period time.Duration
+	active bool
+}
+
+// arm and fire run with the clock locked.
+func (t *fakeTimer) arm(d time.Duration) {
+	t.at, t.active = t.c.now.Add(d), true
+	t.fire()
+}
+
+func (t *fakeTimer) fire() {
+	for t.active && !t.at.After(t.c.now) {
+		select {
+		case t.ch <- t.c.now:
+		default:
+		}
+		if t.period <= 0 {
+			t.active = false
+			return
+		}
+		t.at = t.at.Add(t.period)
+	}
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.ch }
+
+func (t *fakeTimer) Stop() bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	was := t.active
+	t.active = false
+	return was
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	was := t.active
+	t.arm(d)
+	return was
+}
+
+type fakeTicker struct{ *fakeTimer }
+
+func (t fakeTicker) Stop() { t.fakeTimer.Stop() }
+
+// stringSink is a thread-safe sink of strings.
+type stringSink struct {
+	mu  sync.Mutex
+	got []string
+}
+
+func (s *stringSink) write(ctx context.Context, v string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.got = append(s.got, v)
+	return nil
+}
+
+func (s *stringSink) items() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.got)
+}
+
+func (s *stringSink) wait(t *testing.T, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if got := s.items(); len(got) >= n {
+			return got
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("expected %d items, got %v", n, s.items())
+	return nil
+}
+
+// silence reports sensors that stayed silent for five minutes.
+func silence(p *Pipeline, domain TimeDomain, opts ...StageOption) *Pipeline {
+	opts = append(opts, WithStageName("silence"), WithTimers(func(ctx context.Context, st KeyState[string, int], t KeyTimer) ([]string, error) {
+		last, _ := st.Get()
+		return []string{"silent " + st.Key() + " " + strconv.Itoa(last)}, nil
+	}))
+	return StatefulThen(p, func(r reading) string { return r.Sensor },
+		func(ctx context.Context, st KeyState[string, int], r reading) (string, error) {
+			st.Set(r.Value)
+			at := st.Now()
+			if domain == EventTime {
+				at = time.Unix(int64(r.Value), 0)
+			}
+			st.SetTimer("silence", domain, at.Add(5*time.Minute))
+			return "seen " + r.Sensor, nil
+		}, opts...)
+}
+
+func TestProcessingTimeTimersFireOnClock(t *testing.T) {
+	t.Parallel()
+
+	clock := newFakeClock()
+	src := make(chan reading)
+	sink := &stringSink{}
+	r := silence(New("silence", func(ctx context.Context) (<-chan reading, error) { return src, nil }, WithClock(clock)),
+		ProcessingTime, WithStageConcurrency(2)).To(sink.write)
+	h, err := r.Start(context.Background())
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+
+	src <- reading{Sensor: "a", Value: 1}
+	src <- reading{Sensor: "b", Value: 2}
+	sink.wait(t, 2)
+	clock.Advance(3 * time.Minute)
+	src <- reading{Sensor: "a", Value: 3}
+	sink.wait(t, 3)
+
+	clock.Advance(3 * time.Minute)
+	if got := sink.wait(t, 4); got[3] != "silent b 2" {
+		t.Fatalf("expected b to go silent first, got %v", got)
+	}
+	clock.Advance(time.Minute)
+	time.Sleep(10 * time.Millisecond)
+	if got := sink.items(); len(got) != 4 {
+		t.Fatalf("a fired before its reset deadline: %v", got)
+	}
+	clock.Advance(2 * time.Minute)
+	if got := sink.wait(t, 5); got[4] != "silent a 3" {
+		t.Fatalf("expected a to go silent, got %v", got)
+	}
+
+	close(src)
+	if _, err := h.Wait(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+}
+
+func TestEventTimeTimersFollowWatermark(t *testing.T) {
+	t.Parallel()
+
+	// Values are event times in seconds.
+	items := []reading{
+		{Sensor: "a", Value: 0},
+		{Sensor: "b", Value: 60},
+		{Sensor: "a", Value: 240},
+		{Sensor: "c", Value: 420}, // watermark 7m: b (6m) fires
+		{Sensor: "c", Value: 600}, // watermark 10m: a (9m) fires
+	}
+	sink := &stringSink{}
+	p := New("event-time", compileTimeSource(items))
+	r := silence(p, EventTime,
+		WithEventTime(func(r reading) time.Time { return time.Unix(int64(r.Value), 0) }, 0)).To(sink.write)
+	if _, err := r.Run(context.Background()); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	var silent []string
+	for _, s := range sink.items() {
+		if s[:6] == "silent" {
+			silent = append(silent, s)
+		}
+	}
+	slices.Sort(silent)
+	if want := []string{"silent a 240", "silent b 60"}; !slices.Equal(silent, want) {
+		t.Fatalf("expected %v, got %v", want, silent)
+	}
+}
+
+func TestTimersSurviveRestart(t *testing.T) {
+	t.Parallel()
+
+	clock := newFakeClock()
+	items := []reading{{Sensor: "a", Value: 1}, {Sensor: "b", Value: 2}}
+	var hold chan struct{}
+	source := func(ctx context.Context, after string) (<-chan Positioned[reading], error) {
+		start := 0
+		if after != "" {
+			n, _ := strconv.Atoi(after)
+			start = n + 1
+		}
+		ch := make(chan Positioned[reading])
+		release := hold
+		go func() {
+			defer close(ch)
+			for i := start; i < len(items); i++ {
+				ch <- Positioned[reading]{Value: items[i], Position: strconv.Itoa(i)}
+			}
+			<-release
+		}()
+		return ch, nil
+	}
+	states := NewMemoryStateStore()
+	sink := &stringSink{}
+	p := NewResumable("durable", source, WithClock(clock),
+		WithCheckpoints(NewFileCheckpointStore(t.TempDir()), 0), WithStateStore(states))
+	r := silence(p, ProcessingTime).To(sink.write)
+
+	hold = make(chan struct{})
+	close(hold)
+	if _, err := r.Run(context.Background()); err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+	snap, _, _ := states.Load(context.Background(), "durable")
+	if len(snap.Timers["silence"]) != 2 {
+		t.Fatalf("expected two pending timers in the snapshot, got %+v", snap)
+	}
+
+	hold = make(chan struct{})
+	h, err := r.Start(context.Background())
+	if err != nil {
+		t.Fatalf("restart: %v", err)
+	}
+	clock.Advance(6 * time.Minute)
+	got := sink.wait(t, 4)
+	silent := slices.Clone(got[2:])
+	slices.Sort(silent)
+	if want := []string{"silent a 1", "silent b 2"}; !slices.Equal(silent, want) {
+		t.Fatalf("expected restored timers to fire, got %v", got)
+	}
+	close(hold)
+	if _, err := h.Wait(); err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	snap, _, _ = states.Load(context.Background(), "durable")
+	if len(snap.Timers) != 0 {
+		t.Fatalf("fired timers remain in the snapshot: %+v", snap.Timers)
+	}
+}
+
+func TestEventTimeWatermarkNeverMovesBack(t *testing.T) {
+	t.Parallel()
+
+	base := time.Unix(1_700_000_000, 0)
+	for round := 0; round < 100; round++ {
+		st := newStateTime(nil, true, true, 0)
+		var wg sync.WaitGroup
+		for i := 1; i <= 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				st.observe(base.Add(time.Duration(i) * time.Second))
+			}()
+		}
+		wg.Wait()
+		if wm, ok := st.watermark(); !ok || !wm.Equal(base.Add(8*time.Second)) {
+			t.Fatalf("round %d: expected the latest event time, got %v (%v)", round, wm, ok)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+type traceFile struct {
+	TraceEvents []struct {
+		Name string         `json:"name"`
+		Cat  string         `json:"cat"`
+		Ph   string         `json:"ph"`
+		Tid  int            `json:"tid"`
+		Args map[string]any `json:"args"`
+	} `json:"traceEvents"`
+}
+
+func TestPipelineTraceRecordsStageSpans(t *testing.T) {
+	t.Parallel()
+
+	src := func(ctx context.Context) (<-chan int, error) {
+		ch := make(chan int, 4)
+		for i := 1; i <= 4; i++ {
+			ch <- i
+		}
+		close(ch)
+		return ch, nil
+	}
+
+	rec := NewTraceRecorder()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	res, err := New("trace", src, WithTrace(rec, 1)).
+		Then(func(ctx context.Context, n int) (int, error) { return n * 2, nil }, WithStageName("double")).
+		ThenBatch(func(ctx context.Context, in []int) ([]int, error) { return in, nil }, BatchPolicy{Size: 2}, WithStageName("pairs")).
+		To(func(ctx context.Context, n int) error { return nil }).
+		Run(ctx)
+	if err != nil || res.State() != StateSucceeded {
+		t.Fatalf("expected success, got %v %v", res.State(), err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := rec.WriteTo(&buf); err != nil {
+		t.Fatalf("write trace: %v", err)
+	}
+	var tf traceFile
+	if err := json.Unmarshal(buf.Bytes(), &tf); err != nil {
+		t.Fatalf("trace is not valid JSON: %v", err)
+	}
+
+	counts := map[string]int{}
+	for _, ev := range tf.TraceEvents {
+		counts[ev.Ph+" "+ev.Name]++
+		if ev.Ph == "i" && ev.Name == "flush" {
+			if size, _ := ev.Args["size"].(float64); size != 2 {
+				t.Fatalf("expected flush of 2 items, got %v", ev.Args)
+			}
+		}
+	}
+
+	checks := map[string]int{
+		"X double":       4,
+		"b queue double": 4,
+		"e queue double": 4,
+		"X pairs":        2,
+		"i flush":        2,
+		"b batch pairs":  4,
+		"X sink":         4,
+	}
+	for key, want := range checks {
+		if counts[key] != want {
+			t.Fatalf("expected %d %q events, got %d (all: %v)", want, key, counts[key], counts)
+		}
+	}
+}
+
+func TestPipelineTraceSamplingRate(t *testing.T) {
+	t.Parallel()
+
+	src := func(ctx context.Context) (<-chan int, error) {
+		ch := make(chan int, 10)
+		for i := 0; i < 10; i++ {
+			ch <- i
+		}
+		close(ch)
+		return ch, nil
+	}
+
+	rec := NewTraceRecorder()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	_, err := New("sampled", src, WithTrace(rec, 0.2)).
+		Then(func(ctx context.Context, n int) (int, error) { return n, nil }, WithStageName("id")).
+		To(func(ctx context.Context, n int) error { return nil }).
+		Run(ctx)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	var buf bytes.Buffer
+	_, _ = rec.WriteTo(&buf)
+	var tf traceFile
+	if err := json.Unmarshal(buf.Bytes(), &tf); err != nil {
+		t.Fatalf("trace is not valid JSON: %v", err)
+	}
+
+	spans := 0
+	for _, ev := range tf.TraceEvents {
+		if ev.Ph == "X" && ev.Name == "id" {
+			spans++
+		}
+	}
+	if spans != 2 {
+		t.Fatalf("expected 2 sampled items out of 10, got %d", spans)
+	}
+}
+
+func TestPipelineTraceNamesWorkersAddedLater(t *testing.T) {
+	t.Parallel()
+
+	rec := NewTraceRecorder()
+	h, err := New("trace-scale", endlessSource, WithTrace(rec, 1)).
+		Then(func(ctx context.Context, n int) (int, error) { return n, nil }, WithStageName("double")).
+		To(func(ctx context.Context, n int) error { return nil }).
+		Start(context.Background())
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	if _, err := h.SetConcurrency(0, 3); err != nil {
+		t.Fatalf("set concurrency: %v", err)
+	}
+	waitWorkers(t, h, 0, func(w int) bool { return w == 3 })
+	h.Stop(0)
+	if _, err := h.Wait(); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := rec.WriteTo(&buf); err != nil {
+		t.Fatalf("write trace: %v", err)
+	}
+	var tf traceFile
+	if err := json.Unmarshal(buf.Bytes(), &tf); err != nil {
+		t.Fatalf("trace is not valid JSON: %v", err)
+	}
+	names := map[string]int{}
+	for _, ev := range tf.TraceEvents {
+		if ev.Ph == "M" && ev.Name == "thread_name" {
+			name, _ := ev.Args["name"].(string)
+			names[name]++
+		}
+	}
+	for _, want := range []string{"double", "double #1", "double #2"} {
+		if names[want] != 1 {
+			t.Fatalf("expected thread %q named once, got %v", want, names)
+		}
+	}
+}
+
+func TestPipelineTraceKeepsLatestEvents(t *testing.T) {
+	t.Parallel()
+
+	rec := NewTraceRecorderSize(10)
+	items := make([]int, 100)
+	_, err := New("bounded", compileTimeSource(items), WithTrace(rec, 1)).
+		Then(func(ctx context.Context, n int) (int, error) { return n, nil }, WithStageName("id")).
+		To(func(ctx context.Context, n int) error { return nil }).
+		Run(context.Background())
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := rec.WriteTo(&buf); err != nil {
+		t.Fatalf("write trace: %v", err)
+	}
+	var tf traceFile
+	if err := json.Unmarshal(buf.Bytes(), &tf); err != nil {
+		t.Fatalf("trace is not valid JSON: %v", err)
+	}
+	spans, names := 0, 0
+	for _, ev := range tf.TraceEvents {
+		if ev.Ph == "M" {
+			names++
+		} else {
+			spans++
+		}
+	}
+	if spans != 10 || names == 0 {
+		t.Fatalf("expected the latest 10 events and every name, got %d events and %d names", spans, names)
+	}
+	if last := tf.TraceEvents[len(tf.TraceEvents)-1]; last.Name != "sink" {
+		t.Fatalf("expected the last sink span to be kept, got %+v", last)
+	}
+}
@@ -0,0 +1,229 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ledger is a TxSink that keeps committed items and the keys they came with.
+type ledger struct {
+	mu        sync.Mutex
+	open      []int
+	committed []int
+	keys      []string
+	begins    int
+	commits   int
+	aborts    int
+
+	failWrite  int // fail the write of this item, if non-zero
+	failCommit int // fail this commit (1-based), if non-zero
+}
+
+func (l *ledger) Begin(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.open) != 0 {
+		return errors.New("transaction already open")
+	}
+	l.begins++
+	return nil
+}
+
+func (l *ledger) Write(ctx context.Context, n int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.failWrite != 0 && n == l.failWrite {
+		return errors.New("write failed")
+	}
+	key, _ := IdempotencyKey(ctx)
+	l.keys = append(l.keys, key)
+	l.open = append(l.open, n)
+	return nil
+}
+
+func (l *ledger) Commit(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.commits++
+	if l.commits == l.failCommit {
+		l.open = nil
+		return errors.New("commit failed")
+	}
+	l.committed = append(l.committed, l.open...)
+	l.open = nil
+	return nil
+}
+
+func (l *ledger) Abort(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.aborts++
+	l.open = nil
+	return nil
+}
+
+func TestTxCommitsBySize(t *testing.T) {
+	t.Parallel()
+
+	items := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	l := &ledger{}
+	r := New("tx", compileTimeSource(items)).
+		Then(func(ctx context.Context, n int) (int, error) { return n, nil }).
+		ToTx(l, TxPolicy{Size: 3})
+	if _, err := r.Run(context.Background()); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if l.begins != 4 || l.commits != 4 || l.aborts != 0 || len(l.committed) != 10 {
+		t.Fatalf("expected 4 transactions covering every item, got %+v", l)
+	}
+	if d := r.Describe(); d.Stages[1].Tx == nil || d.Stages[1].Tx.Size != 3 {
+		t.Fatalf("expected the sink to describe its tx policy, got %+v", d.Stages[1])
+	}
+	if st := r.Stats(); st.Sink.In != 10 || st.Sink.Out != 10 {
+		t.Fatalf("expected the sink to count committed items, got %+v", st.Sink)
+	}
+}
+
+func TestTxCommitsByMaxWait(t *testing.T) {
+	t.Parallel()
+
+	l := &ledger{}
+	release := make(chan struct{})
+	src := func(ctx context.Context) (<-chan int, error) {
+		ch := make(chan int)
+		go func() {
+			defer close(ch)
+			ch <- 1
+			<-release
+		}()
+		return ch, nil
+	}
+	h, err := New("wait", src).ToTx(l, TxPolicy{Size: 100, MaxWait: 10 * time.Millisecond}).Start(context.Background())
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for h.Stats().Sink.Out != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("transaction never committed: %+v", l)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	if _, err := h.Wait(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+}
+
+func TestTxWriteFailureAbortsAndNacks(t *testing.T) {
+	t.Parallel()
+
+	src, a := ackSource(10)
+	l := &ledger{failWrite: 7}
+	_, err := NewAcked("abort", src).ToTx(l, TxPolicy{Size: 5}).Run(context.Background())
+	if err == nil || err.Error() != "write failed" {
+		t.Fatalf("expected the write error, got %v", err)
+	}
+	a.wait(t, 10)
+	if l.aborts != 1 || len(l.committed) != 5 {
+		t.Fatalf("expected the first transaction committed and the second aborted, got %+v", l)
+	}
+	for i := 0; i < 10; i++ {
+		_, acked := a.acked[i]
+		if acked != (i < 5) {
+			t.Fatalf("item %d: acked %v, nacked %v", i, acked, a.nacked[i])
+		}
+	}
+}
+
+func TestTxCheckpointCoversCommittedOnly(t *testing.T) {
+	t.Parallel()
+
+	store := NewFileCheckpointStore(t.TempDir())
+	l := &ledger{failCommit: 2}
+	r := NewResumable("ledger", countingSource(10), WithCheckpoints(store, time.Hour)).
+		ToTx(l, TxPolicy{Size: 4})
+	h, err := r.Start(context.Background())
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	if _, err := h.Wait(); err == nil {
+		t.Fatalf("expected the failed commit to fail the run")
+	}
+	if cp, _ := h.Checkpoint(); cp.Position != "3" {
+		t.Fatalf("expected the checkpoint at the first commit, got %+v", cp)
+	}
+	for i, key := range l.keys[:4] {
+		if key != strconv.Itoa(i) {
+			t.Fatalf("expected positions as idempotency keys, got %v", l.keys)
+		}
+	}
+
+	// The resumed run starts after the last committed item.
+	l.failCommit = 0
+	if _, err := r.Run(context.Background()); err != nil {
+		t.Fatalf("resumed run: %v", err)
+	}
+	if len(l.committed) != 10 {
+		t.Fatalf("expected each item committed once, got %v", l.committed)
+	}
+}
+
+func TestIdempotencyKeys(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu   sync.Mutex
+		keys []string
+	)
+	sink := func(ctx context.Context, n int) error {
+		key, _ := IdempotencyKey(ctx)
+		mu.Lock()
+		keys = append(keys, key)
+		mu.Unlock()
+		return nil
+	}
+
+	_, err := New("keyed", compileTimeSource([]int{1, 2}), WithIdempotencyKey(func(n int) string { return "k" + strconv.Itoa(n) })).
+		Then(func(ctx context.Context, n int) (int, error) { return n, nil }).
+		To(sink).Run(context.Background())
+	if err != nil || len(keys) != 2 || keys[0] != "k1" || keys[1] != "k2" {
+		t.Fatalf("expected keys from the key function, got %v (%v)", keys, err)
+	}
+
+	keys = nil
+	batch := func() []string {
+		keys = nil
+		_, err := NewResumable("batched", countingSource(4)).
+			ThenBatch(func(ctx context.Context, in []int) ([]int, error) { return in, nil }, BatchPolicy{Size: 4}).
+			To(sink).Run(context.Background())
+		if err != nil {
+			t.Fatalf("run: %v", err)
+		}
+		return keys
+	}
+	first, second := batch(), batch()
+	for i := range first {
+		if first[i] == "" || first[i] != second[i] || (i > 0 && first[i] == first[i-1]) {
+			t.Fatalf("expected distinct keys stable across runs, got %v and %v", first, second)
+		}
+	}
+}
+
+func TestIdempotencyKeyPanicFailsRun(t *testing.T) {
+	t.Parallel()
+
+	res, err := New("key-panic", compileTimeSource([]int{1, 2}), WithIdempotencyKey(func(n int) string {
+		panic("no key")
+	})).
+		To(func(ctx context.Context, n int) error { return nil }).
+		Run(context.Background())
+	if res.State() != StateFailed || err == nil || !strings.Contains(err.Error(), "panic in idempotency key function: no key") {
+		t.Fatalf("expected a failed run with the key panic, got %v (%v)", res.State(), err)
+	}
+}
@@ -0,0 +1,80 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestTypedPipeline(t *testing.T) {
+	t.Parallel()
+
+	src := NewTyped("typed", compileTimeSource([]int{1, 2, 3, 4}))
+	doubled := Then(src, func(ctx context.Context, n int) (int, error) { return 2 * n, nil })
+	sums := ThenBatch(doubled, func(ctx context.Context, in []int) ([]int, error) {
+		return []int{in[0] + in[1]}, nil
+	}, BatchPolicy{Size: 2})
+	labels := Then(sums, func(ctx context.Context, n int) (string, error) { return "sum=" + itoa(n), nil })
+
+	sink := &stringSink{}
+	if _, err := To(labels, sink.write).Run(context.Background()); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if want := []string{"sum=6", "sum=14"}; !slices.Equal(sink.items(), want) {
+		t.Fatalf("expected %v, got %v", want, sink.items())
+	}
+}
+
+func TestTypedHandlerErrorsFailRun(t *testing.T) {
+	t.Parallel()
+
+	boom := errors.New("boom")
+	src := NewTyped("typed-error", compileTimeSource([]int{1, 2, 3}))
+	r := To(Then(src, func(ctx context.Context, n int) (int, error) {
+		if n == 2 {
+			return 0, boom
+		}
+		return n, nil
+	}), func(ctx context.Context, n int) error { return nil })
+	res, err := r.Run(context.Background())
+	if !errors.Is(err, boom) || res.State() != StateFailed {
+		t.Fatalf("expected failed with %v, got %v (%v)", boom, res.State(), err)
+	}
+}
+
+func TestTypedInteroperatesWithFluentBuilder(t *testing.T) {
+	t.Parallel()
+
+	p := New("mixed", compileTimeSource([]reading{{"a", 1}, {"a", 2}, {"b", 5}})).
+		Then(func(ctx context.Context, r reading) (reading, error) { return r, nil })
+	totals := StatefulThen(AsTyped[reading](p).Pipeline(), func(r reading) string { return r.Sensor },
+		func(ctx context.Context, st KeyState[string, int], r reading) (int, error) {
+			v, _ := st.Get()
+			st.Set(v + r.Value)
+			return v + r.Value, nil
+		})
+
+	l := &ledger{}
+	if _, err := ToTx(AsTyped[int](totals), l, TxPolicy{Size: 2}).Run(context.Background()); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	got := slices.Clone(l.committed)
+	slices.Sort(got)
+	if want := []int{1, 3, 5}; !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestAsTypedPanicsOnMismatch(t *testing.T) {
+	t.Parallel()
+
+	defer func() {
+		r := recover()
+		if r == nil || !strings.Contains(r.(string), "builder outputs int, not string") {
+			t.Fatalf("expected a type mismatch panic, got %v", r)
+		}
+	}()
+	AsTyped[string](New("mismatch", compileTimeSource([]int{1})))
+}
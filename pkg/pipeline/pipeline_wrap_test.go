@@ -0,0 +1,62 @@
+package pipeline
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestCallWrappersWrapEveryStageKind(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu    sync.Mutex
+		calls []string
+	)
+	tag := func(name string) StageOption {
+		return WithCallWrapper(func(next Call) Call {
+			return func(ctx context.Context, v any) (any, error) {
+				mu.Lock()
+				calls = append(calls, name)
+				mu.Unlock()
+				return next(ctx, v)
+			}
+		})
+	}
+
+	add := func(d int) StageOption {
+		return WithCallWrapper(func(next Call) Call {
+			return func(ctx context.Context, v any) (any, error) { return next(ctx, v.(int)+d) }
+		})
+	}
+	times := func(k int) StageOption {
+		return WithCallWrapper(func(next Call) Call {
+			return func(ctx context.Context, v any) (any, error) { return next(ctx, v.(int)*k) }
+		})
+	}
+
+	sink := &stringSink{}
+	r := StatefulThen(
+		New("wrap", compileTimeSource([]int{1, 2})).
+			Then(func(ctx context.Context, n int) (int, error) { return n, nil }, tag("single"), add(10), times(2)).
+			ThenBatch(func(ctx context.Context, in []int) ([]int, error) { return in, nil }, BatchPolicy{Size: 2}, tag("batch")),
+		func(n int) int { return n },
+		func(ctx context.Context, st KeyState[int, int], n int) (string, error) { return itoa(n), nil },
+		tag("keyed"),
+	).To(sink.write, tag("sink"))
+
+	if _, err := r.Run(context.Background()); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	slices.Sort(calls)
+	if want := []string{"batch", "keyed", "keyed", "single", "single", "sink", "sink"}; !slices.Equal(calls, want) {
+		t.Fatalf("expected %v, got %v", want, calls)
+	}
+	// The first wrapper given is the outermost: (n+10)*2.
+	got := sink.items()
+	slices.Sort(got)
+	if want := []string{"22", "24"}; !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
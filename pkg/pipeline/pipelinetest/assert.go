@@ -0,0 +1,68 @@
+package pipelinetest
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jpconstantineau/data-duct/pkg/pipeline"
+)
+
+// AssertState fails the test unless res is in state want.
+func AssertState(t testing.TB, res pipeline.Result, want pipeline.State) {
+	t.Helper()
+	if res == nil {
+		t.Fatalf("pipelinetest: expected %s, got no result", want)
+	}
+	if got := res.State(); got != want {
+		t.Fatalf("pipelinetest: expected %s, got %s (cause: %v)", want, got, res.Err())
+	}
+}
+
+// AssertCause fails the test unless the cause of res matches target with
+// errors.Is. A nil target asserts that res has no cause.
+func AssertCause(t testing.TB, res pipeline.Result, target error) {
+	t.Helper()
+	if res == nil {
+		t.Fatalf("pipelinetest: expected cause %v, got no result", target)
+	}
+	cause := res.Err()
+	if target == nil {
+		if cause != nil {
+			t.Fatalf("pipelinetest: expected no cause, got %v", cause)
+		}
+		return
+	}
+	if !errors.Is(cause, target) {
+		t.Fatalf("pipelinetest: expected cause %v, got %v", target, cause)
+	}
+}
+
+// AssertSucceeded fails the test unless a run, given the two results of Run,
+// succeeded.
+func AssertSucceeded(t testing.TB, res pipeline.Result, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("pipelinetest: expected success, got %v", err)
+	}
+	AssertState(t, res, pipeline.StateSucceeded)
+}
+
+// AssertFailed fails the test unless a run failed with an error matching
+// target, both in the returned error and in the result's cause.
+func AssertFailed(t testing.TB, res pipeline.Result, err, target error) {
+	t.Helper()
+	if !errors.Is(err, target) {
+		t.Fatalf("pipelinetest: expected error %v, got %v", target, err)
+	}
+	AssertState(t, res, pipeline.StateFailed)
+	AssertCause(t, res, target)
+}
+
+// AssertCancelled fails the test unless a run was cancelled.
+func AssertCancelled(t testing.TB, res pipeline.Result, err error) {
+	t.Helper()
+	AssertState(t, res, pipeline.StateCancelled)
+	if !errors.Is(err, res.Err()) {
+		t.Fatalf("pipelinetest: expected error %v, got %v", res.Err(), err)
+	}
+}
@@ -0,0 +1,208 @@
+// Package pipelinetest provides helpers for testing pipelines.
+package pipelinetest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jpconstantineau/data-duct/pkg/pipeline"
+)
+
+// FakeClock is a pipeline.Clock whose time only moves when Advance or Set is
+// called, firing due timers from those calls. It is safe for concurrent use.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	timers  []*fakeTimer
+	changed *sync.Cond
+}
+
+var _ pipeline.Clock = (*FakeClock)(nil)
+
+// NewFakeClock returns a clock set to start, or to an arbitrary fixed time if
+// start is zero.
+func NewFakeClock(start time.Time) *FakeClock {
+	if start.IsZero() {
+		start = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	c := &FakeClock{now: start}
+	c.changed = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now implements pipeline.Clock.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTimer implements pipeline.Clock.
+func (c *FakeClock) NewTimer(d time.Duration) pipeline.Timer {
+	return c.add(d, 0)
+}
+
+// NewTicker implements pipeline.Clock. It panics if d is not positive, like
+// time.NewTicker.
+func (c *FakeClock) NewTicker(d time.Duration) pipeline.Ticker {
+	if d <= 0 {
+		panic("pipelinetest: non-positive interval for NewTicker")
+	}
+	return fakeTicker{c.add(d, d)}
+}
+
+// Advance moves the clock forward by d and fires every timer and ticker that
+// comes due. A ticker that missed several ticks delivers one, like
+// time.Ticker.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(c.now.Add(d))
+}
+
+// Set moves the clock to t, which must not be before the current time.
+func (c *FakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t.Before(c.now) {
+		panic("pipelinetest: FakeClock cannot go back in time")
+	}
+	c.set(t)
+}
+
+func (c *FakeClock) set(t time.Time) {
+	c.now = t
+	live := c.timers[:0]
+	for _, tm := range c.timers {
+		tm.fire()
+		if tm.active {
+			live = append(live, tm)
+		}
+	}
+	clear(c.timers[len(live):])
+	c.timers = live
+	c.changed.Broadcast()
+}
+
+func (c *FakeClock) remove(t *fakeTimer) {
+	for i, tm := range c.timers {
+		if tm == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return
+		}
+	}
+}
+
+// Timers reports the number of timers and tickers waiting to fire.
+func (c *FakeClock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// BlockUntil blocks until at least n timers and tickers are waiting to fire.
+// Tests call it before Advance to be sure the code under test has armed the
+// timer it is expected to.
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.timers) < n {
+		c.changed.Wait()
+	}
+}
+
+// next returns the earliest deadline of the pending timers and tickers.
+func (c *FakeClock) next() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var at time.Time
+	for i, t := range c.timers {
+		if i == 0 || t.at.Before(at) {
+			at = t.at
+		}
+	}
+	return at, len(c.timers) > 0
+}
+
+func (c *FakeClock) add(d, period time.Duration) *fakeTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{c: c, ch: make(chan time.Time, 1), period: period}
+	t.arm(d)
+	return t
+}
+
+// fakeTimer is a timer or, with a period, a ticker. Its methods other than C
+// lock the clock; arm and fire expect it locked.
+type fakeTimer struct {
+	c      *FakeClock
+	ch     chan time.Time
+	at     time.Time
+	period time.Duration
+	active bool
+}
+
+// arm discards a pending tick, like time.Timer.Reset, and schedules the next.
+func (t *fakeTimer) arm(d time.Duration) {
+	t.drain()
+	if !t.active {
+		t.c.timers = append(t.c.timers, t)
+	}
+	t.at, t.active = t.c.now.Add(d), true
+	t.fire()
+	if !t.active {
+		t.c.remove(t)
+	}
+	t.c.changed.Broadcast()
+}
+
+func (t *fakeTimer) fire() {
+	if !t.active || t.at.After(t.c.now) {
+		return
+	}
+	select {
+	case t.ch <- t.c.now:
+	default:
+	}
+	if t.period <= 0 {
+		t.active = false
+		return
+	}
+	for !t.at.After(t.c.now) {
+		t.at = t.at.Add(t.period)
+	}
+}
+
+func (t *fakeTimer) drain() {
+	select {
+	case <-t.ch:
+	default:
+	}
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.ch }
+
+func (t *fakeTimer) Stop() bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	was := t.active
+	t.drain()
+	if was {
+		t.active = false
+		t.c.remove(t)
+		t.c.changed.Broadcast()
+	}
+	return was
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	was := t.active
+	t.arm(d)
+	return was
+}
+
+type fakeTicker struct{ *fakeTimer }
+
+func (t fakeTicker) Stop() { t.fakeTimer.Stop() }
@@ -0,0 +1,149 @@
+package pipelinetest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jpconstantineau/data-duct/pkg/pipeline"
+)
+
+func TestFakeClockTimer(t *testing.T) {
+	t.Parallel()
+
+	c := NewFakeClock(time.Time{})
+	start := c.Now()
+	tm := c.NewTimer(time.Second)
+	if c.Timers() != 1 {
+		t.Fatalf("expected one pending timer, got %d", c.Timers())
+	}
+
+	c.Advance(999 * time.Millisecond)
+	select {
+	case <-tm.C():
+		t.Fatalf("timer fired early")
+	default:
+	}
+	c.Advance(time.Millisecond)
+	select {
+	case at := <-tm.C():
+		if want := start.Add(time.Second); !at.Equal(want) {
+			t.Fatalf("expected tick at %v, got %v", want, at)
+		}
+	default:
+		t.Fatalf("timer did not fire")
+	}
+	if tm.Stop() {
+		t.Fatalf("Stop reported a fired timer as active")
+	}
+	if c.Timers() != 0 {
+		t.Fatalf("fired timer still pending")
+	}
+
+	// Reset discards an undelivered tick.
+	tm.Reset(time.Second)
+	c.Advance(time.Second)
+	if tm.Reset(time.Second) {
+		t.Fatalf("Reset reported a fired timer as active")
+	}
+	select {
+	case <-tm.C():
+		t.Fatalf("stale tick survived Reset")
+	default:
+	}
+	if !tm.Stop() {
+		t.Fatalf("Stop reported an armed timer as inactive")
+	}
+	c.Advance(time.Hour)
+	select {
+	case <-tm.C():
+		t.Fatalf("stopped timer fired")
+	default:
+	}
+}
+
+func TestFakeClockTicker(t *testing.T) {
+	t.Parallel()
+
+	c := NewFakeClock(time.Time{})
+	tk := c.NewTicker(time.Second)
+	for range 3 {
+		c.Advance(time.Second)
+		select {
+		case <-tk.C():
+		default:
+			t.Fatalf("ticker did not tick")
+		}
+	}
+
+	// Missed ticks collapse into one.
+	c.Advance(5 * time.Second)
+	<-tk.C()
+	select {
+	case <-tk.C():
+		t.Fatalf("ticker delivered missed ticks")
+	default:
+	}
+
+	tk.Stop()
+	if c.Timers() != 0 {
+		t.Fatalf("stopped ticker still pending")
+	}
+}
+
+func TestFakeClockDrivesBatchMaxWait(t *testing.T) {
+	t.Parallel()
+
+	src := func(ctx context.Context) (<-chan int, error) {
+		ch := make(chan int)
+		go func() {
+			defer close(ch)
+			for i := 1; i <= 3; i++ {
+				select {
+				case <-ctx.Done():
+					return
+				case ch <- i:
+				}
+			}
+			<-ctx.Done()
+		}()
+		return ch, nil
+	}
+	flushed := make(chan []int, 3)
+	handler := func(ctx context.Context, in []int) ([]int, error) {
+		flushed <- in
+		return in, nil
+	}
+
+	clock := NewFakeClock(time.Time{})
+	r := pipeline.New("max-wait", src, pipeline.WithClock(clock)).
+		ThenBatch(handler, pipeline.BatchPolicy{Size: 10, MaxWait: time.Minute}).
+		To(func(ctx context.Context, n int) error { return nil })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h, err := r.Start(ctx)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+
+	clock.BlockUntil(1)
+	clock.Advance(59 * time.Second)
+	select {
+	case got := <-flushed:
+		t.Fatalf("batch flushed before MaxWait: %v", got)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	clock.Advance(time.Second)
+	select {
+	case got := <-flushed:
+		if len(got) == 0 || len(got) > 3 {
+			t.Fatalf("unexpected batch %v", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("batch did not flush at MaxWait")
+	}
+
+	cancel()
+	_, _ = h.Wait()
+}
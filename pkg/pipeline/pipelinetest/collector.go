@@ -0,0 +1,72 @@
+package pipelinetest
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Collector is a sink that keeps every item written to it. The zero value is
+// ready to use and a Collector is safe for concurrent use.
+type Collector[T any] struct {
+	mu    sync.Mutex
+	items []T
+	// more is closed and replaced whenever an item arrives.
+	more chan struct{}
+}
+
+// Write appends v. It is the sink function passed to To.
+func (c *Collector[T]) Write(ctx context.Context, v T) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items = append(c.items, v)
+	if c.more != nil {
+		close(c.more)
+		c.more = nil
+	}
+	return nil
+}
+
+// Items returns a copy of the items written so far, in arrival order.
+func (c *Collector[T]) Items() []T {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.items)
+}
+
+// Len returns the number of items written so far.
+func (c *Collector[T]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.items)
+}
+
+// WaitForN waits until at least n items have been written and returns them.
+// It fails the test if that takes longer than timeout.
+func (c *Collector[T]) WaitForN(t testing.TB, n int, timeout time.Duration) []T {
+	t.Helper()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		c.mu.Lock()
+		if len(c.items) >= n {
+			items := slices.Clone(c.items)
+			c.mu.Unlock()
+			return items
+		}
+		if c.more == nil {
+			c.more = make(chan struct{})
+		}
+		more := c.more
+		c.mu.Unlock()
+
+		select {
+		case <-more:
+		case <-deadline.C:
+			t.Fatalf("pipelinetest: expected %d items within %v, got %d", n, timeout, c.Len())
+			return nil
+		}
+	}
+}
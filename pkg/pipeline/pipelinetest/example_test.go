@@ -0,0 +1,75 @@
+package pipelinetest_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jpconstantineau/data-duct/pkg/pipeline"
+	"github.com/jpconstantineau/data-duct/pkg/pipeline/pipelinetest"
+)
+
+func ExampleFakeClock() {
+	items := make(chan int, 1)
+	items <- 1
+	src := func(ctx context.Context) (<-chan int, error) { return items, nil }
+
+	flushed := make(chan []int)
+	clock := pipelinetest.NewFakeClock(time.Time{})
+	h, _ := pipeline.New("orders", src, pipeline.WithClock(clock)).
+		ThenBatch(func(ctx context.Context, in []int) ([]int, error) {
+			flushed <- in
+			return in, nil
+		}, pipeline.BatchPolicy{Size: 100, MaxWait: time.Minute}).
+		To(func(ctx context.Context, n int) error { return nil }).
+		Start(context.Background())
+
+	clock.BlockUntil(1)        // the MaxWait timer is armed
+	clock.Advance(time.Minute) // the partial batch flushes now
+	fmt.Println(<-flushed)
+
+	close(items)
+	_, _ = h.Wait()
+	// Output:
+	// [1]
+}
+
+func ExampleFailOnNth() {
+	boom := errors.New("boom")
+	enrich := func(ctx context.Context, n int) (string, error) { return fmt.Sprint(n), nil }
+
+	// In a test, run it with RunNoLeaks(t, ctx, r) and check the result with
+	// AssertFailed(t, res, err, boom).
+	var got pipelinetest.Collector[string]
+	res, err := pipeline.New("orders", pipelinetest.FromSlice([]int{1, 2, 3, 4})).
+		Then(pipelinetest.FailOnNth(3, boom, enrich)).
+		To(got.Write).
+		Run(context.Background())
+	fmt.Println(res.State(), err)
+	// Output:
+	// failed boom
+}
+
+func ExampleSimulation() {
+	sim := pipelinetest.NewSimulation(42)
+	var got pipelinetest.Collector[int]
+	r := pipeline.New("orders", pipelinetest.FromSlice([]int{1, 2, 3}), sim.Options()...).
+		Then(func(ctx context.Context, n int) (int, error) { return n * 10, nil }, pipeline.WithStageConcurrency(2)).
+		To(got.Write)
+
+	// In a test, Explore(t, 100, ...) runs this under seeds 1 to 100.
+	res, _ := sim.Run(context.Background(), r)
+	fmt.Println(res.State(), got.Items())
+	for _, step := range sim.Trace() {
+		fmt.Println(step)
+	}
+	// Output:
+	// succeeded [20 30 10]
+	// stage 0: 2
+	// sink: 20
+	// stage 0: 3
+	// sink: 30
+	// stage 0: 1
+	// sink: 10
+}
@@ -0,0 +1,47 @@
+package pipelinetest
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/jpconstantineau/data-duct/pkg/pipeline"
+)
+
+// FailOnNth wraps h so that its nth call, counting from 1 across all workers,
+// returns err instead of calling h. Every other call goes to h.
+func FailOnNth[In, Out any](n int64, err error, h pipeline.Handler[In, Out]) pipeline.Handler[In, Out] {
+	var calls atomic.Int64
+	return func(ctx context.Context, in In) (Out, error) {
+		if calls.Add(1) == n {
+			var zero Out
+			return zero, err
+		}
+		return h(ctx, in)
+	}
+}
+
+// FailBatchOnNth is FailOnNth for batch handlers: the nth batch fails.
+func FailBatchOnNth[In, Out any](n int64, err error, h pipeline.BatchHandler[In, Out]) pipeline.BatchHandler[In, Out] {
+	var calls atomic.Int64
+	return func(ctx context.Context, in []In) ([]Out, error) {
+		if calls.Add(1) == n {
+			return nil, err
+		}
+		return h(ctx, in)
+	}
+}
+
+// FailSinkOnNth is FailOnNth for sinks: the nth write fails. A nil sink
+// discards the other writes.
+func FailSinkOnNth[T any](n int64, err error, sink pipeline.EndHandler[T]) pipeline.EndHandler[T] {
+	var calls atomic.Int64
+	return func(ctx context.Context, in T) error {
+		if calls.Add(1) == n {
+			return err
+		}
+		if sink == nil {
+			return nil
+		}
+		return sink(ctx, in)
+	}
+}
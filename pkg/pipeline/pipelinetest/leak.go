@@ -0,0 +1,91 @@
+package pipelinetest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jpconstantineau/data-duct/pkg/pipeline"
+)
+
+// LeakTimeout is how long NoLeaks and RunNoLeaks give goroutines to exit
+// once the code under test has returned.
+const LeakTimeout = 2 * time.Second
+
+// RunNoLeaks runs r and fails the test if any goroutine the run started,
+// including those of its source and handlers, is still alive LeakTimeout
+// after Run returns.
+func RunNoLeaks(t testing.TB, ctx context.Context, r *pipeline.Runnable) (pipeline.Result, error) {
+	t.Helper()
+	var (
+		res pipeline.Result
+		err error
+	)
+	NoLeaks(t, ctx, func(ctx context.Context) {
+		res, err = r.Run(ctx)
+	})
+	return res, err
+}
+
+// NoLeaks calls fn and fails the test if any goroutine started by fn, directly
+// or not, is still alive LeakTimeout after fn returns. Goroutines started
+// elsewhere, such as by parallel tests, are not counted.
+func NoLeaks(t testing.TB, ctx context.Context, fn func(ctx context.Context)) {
+	t.Helper()
+	noLeaks(t, ctx, LeakTimeout, fn)
+}
+
+var leakRuns atomic.Int64
+
+// noLeaks tags the goroutines of fn with a profiler label, which the
+// goroutines they start inherit, and waits for the tagged ones to exit.
+func noLeaks(t testing.TB, ctx context.Context, timeout time.Duration, fn func(ctx context.Context)) {
+	t.Helper()
+	id := strconv.FormatInt(leakRuns.Add(1), 10)
+	pprof.Do(ctx, pprof.Labels("pipelinetest", id), fn)
+
+	label := `"pipelinetest":"` + id + `"`
+	deadline := time.Now().Add(timeout)
+	for {
+		n, stacks := labelled(label)
+		if n == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Errorf("pipelinetest: %d goroutine(s) leaked:\n%s", n, stacks)
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// labelled counts the live goroutines carrying label and returns their
+// stacks. The goroutine profile at debug level 1 groups goroutines with the
+// same stack and labels into blocks headed by their count.
+func labelled(label string) (int, string) {
+	var buf bytes.Buffer
+	_ = pprof.Lookup("goroutine").WriteTo(&buf, 1)
+	var (
+		n      int
+		stacks strings.Builder
+	)
+	for _, block := range strings.Split(buf.String(), "\n\n") {
+		if !strings.Contains(block, label) {
+			continue
+		}
+		var count int
+		if _, err := fmt.Sscanf(block, "%d @", &count); err != nil {
+			continue
+		}
+		n += count
+		stacks.WriteString(block)
+		stacks.WriteString("\n\n")
+	}
+	return n, stacks.String()
+}
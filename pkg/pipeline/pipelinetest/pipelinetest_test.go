@@ -0,0 +1,117 @@
+package pipelinetest
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jpconstantineau/data-duct/pkg/pipeline"
+)
+
+func double(ctx context.Context, n int) (int, error) { return 2 * n, nil }
+
+func TestFromSliceToCollector(t *testing.T) {
+	t.Parallel()
+
+	var got Collector[int]
+	r := pipeline.New("collect", FromSlice([]int{1, 2, 3})).Then(double).To(got.Write)
+	for run := 1; run <= 2; run++ {
+		res, err := RunNoLeaks(t, context.Background(), r)
+		AssertSucceeded(t, res, err)
+	}
+	if want := []int{2, 4, 6, 2, 4, 6}; !slices.Equal(got.Items(), want) {
+		t.Fatalf("expected %v, got %v", want, got.Items())
+	}
+}
+
+func TestFailOnNth(t *testing.T) {
+	t.Parallel()
+
+	boom := errors.New("boom")
+	ctx := context.Background()
+
+	var got Collector[int]
+	r := pipeline.New("single", FromSlice([]int{1, 2, 3})).Then(FailOnNth(2, boom, double)).To(got.Write)
+	res, err := RunNoLeaks(t, ctx, r)
+	AssertFailed(t, res, err, boom)
+	if items := got.Items(); slices.Contains(items, 4) {
+		t.Fatalf("the second item went through: %v", items)
+	}
+
+	batches := func(ctx context.Context, in []int) ([]int, error) { return in, nil }
+	r = pipeline.New("batch", FromSlice([]int{1, 2, 3, 4})).
+		ThenBatch(FailBatchOnNth(2, boom, batches), pipeline.BatchPolicy{Size: 2}).
+		To(func(ctx context.Context, n int) error { return nil })
+	res, err = RunNoLeaks(t, ctx, r)
+	AssertFailed(t, res, err, boom)
+
+	r = pipeline.New("sink", FromSlice([]int{1, 2, 3})).To(FailSinkOnNth[int](3, boom, nil))
+	res, err = RunNoLeaks(t, ctx, r)
+	AssertFailed(t, res, err, boom)
+}
+
+func TestCollectorWaitForN(t *testing.T) {
+	t.Parallel()
+
+	src := make(chan string)
+	var got Collector[string]
+	r := pipeline.New("wait", func(ctx context.Context) (<-chan string, error) { return src, nil }).To(got.Write)
+	ctx, cancel := context.WithCancel(context.Background())
+	h, err := r.Start(ctx)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	go func() {
+		src <- "a"
+		src <- "b"
+	}()
+	if items := got.WaitForN(t, 2, 5*time.Second); len(items) != 2 {
+		t.Fatalf("expected two items, got %v", items)
+	}
+	cancel()
+	res, err := h.Wait()
+	AssertCancelled(t, res, err)
+}
+
+// recorder is a testing.TB that records failures instead of reporting them.
+type recorder struct {
+	testing.TB
+	mu     sync.Mutex
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors = append(r.errors, format)
+}
+
+func TestNoLeaksReportsLeakedGoroutines(t *testing.T) {
+	t.Parallel()
+
+	rec := &recorder{TB: t}
+	release := make(chan struct{})
+	defer close(release)
+	noLeaks(rec, context.Background(), 50*time.Millisecond, func(ctx context.Context) {
+		go func() { <-release }()
+	})
+	if len(rec.errors) != 1 || !strings.Contains(rec.errors[0], "leaked") {
+		t.Fatalf("expected a leak report, got %v", rec.errors)
+	}
+
+	rec = &recorder{TB: t}
+	done := make(chan struct{})
+	noLeaks(rec, context.Background(), time.Second, func(ctx context.Context) {
+		go func() { close(done) }()
+		<-done
+	})
+	if len(rec.errors) != 0 {
+		t.Fatalf("unexpected leak report: %v", rec.errors)
+	}
+}
@@ -0,0 +1,314 @@
+package pipelinetest
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jpconstantineau/data-duct/pkg/pipeline"
+)
+
+// ErrStalled is the cause of a simulated run cancelled because it could not
+// make progress or exceeded Simulation.MaxSteps.
+var ErrStalled = errors.New("pipelinetest: simulation stalled")
+
+// defaultMaxSteps bounds a simulated run when Simulation.MaxSteps is zero.
+const defaultMaxSteps = 100000
+
+// Simulation runs a pipeline once under a seeded, deterministic schedule:
+// whenever the run is blocked, it lets one handler or sink call through, or
+// jumps its FakeClock to the next timer, choosing with its seed.
+//
+// A run is blocked once every goroutine it started waits on a channel or a
+// lock. Simulation finds out by polling and parsing runtime.Stack, which
+// stops the world each time, so it suits tests rather than benchmarks, and a
+// handler that blocks in a system call or sleeps is treated as busy.
+type Simulation struct {
+	// MaxSteps bounds the scheduling decisions of a run; zero means 100000.
+	MaxSteps int
+
+	seed  int64
+	rng   *rand.Rand
+	clock *FakeClock
+
+	mu      sync.Mutex
+	waiting []*simStep
+	trace   []string
+}
+
+var _ pipeline.Scheduler = (*Simulation)(nil)
+
+type simStep struct {
+	step pipeline.Step
+	// key and then Seq order the steps of a stage independently of arrival
+	// order.
+	key   string
+	grant chan struct{}
+}
+
+// NewSimulation returns a simulation seeded with seed.
+func NewSimulation(seed int64) *Simulation {
+	return &Simulation{
+		seed:  seed,
+		rng:   rand.New(rand.NewPCG(uint64(seed), 0)),
+		clock: NewFakeClock(time.Time{}),
+	}
+}
+
+// Seed returns the seed of the simulation.
+func (s *Simulation) Seed() int64 { return s.seed }
+
+// Clock returns the virtual clock of the simulation.
+func (s *Simulation) Clock() *FakeClock { return s.clock }
+
+// Options returns the pipeline options that put a pipeline under the
+// simulation: its clock and its scheduler.
+func (s *Simulation) Options() []pipeline.Option {
+	return []pipeline.Option{pipeline.WithClock(s.clock), pipeline.WithScheduler(s)}
+}
+
+// Trace returns the decisions taken so far, one per line: the calls let
+// through, as stage and value, and the clock jumps.
+func (s *Simulation) Trace() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.trace)
+}
+
+// Wait implements pipeline.Scheduler.
+func (s *Simulation) Wait(ctx context.Context, step pipeline.Step) error {
+	w := &simStep{step: step, key: fmt.Sprint(step.Value), grant: make(chan struct{})}
+	s.mu.Lock()
+	s.waiting = append(s.waiting, w)
+	s.mu.Unlock()
+
+	select {
+	case <-w.grant:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		if i := slices.Index(s.waiting, w); i >= 0 {
+			s.waiting = slices.Delete(s.waiting, i, i+1)
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Run runs r, which must have been built with the simulation's Options, and
+// returns its result. A run that stalls is cancelled with ErrStalled.
+func (s *Simulation) Run(ctx context.Context, r *pipeline.Runnable) (pipeline.Result, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	// Start from a fresh goroutine: the goroutines of the run are its
+	// descendants.
+	type started struct {
+		id  int64
+		h   *pipeline.Handle
+		err error
+	}
+	ch := make(chan started, 1)
+	go func() {
+		id := goid()
+		h, err := r.Start(ctx)
+		ch <- started{id: id, h: h, err: err}
+	}()
+	st := <-ch
+	if st.err != nil {
+		return pipeline.Failed{Cause: st.err}, st.err
+	}
+
+	limit := s.MaxSteps
+	if limit <= 0 {
+		limit = defaultMaxSteps
+	}
+	tree := &goroutineTree{known: map[int64]bool{st.id: true}}
+	for steps := 0; tree.settle(st.h.Done()); steps++ {
+		if steps >= limit || !s.step() {
+			s.record("stalled")
+			cancel(ErrStalled)
+			break
+		}
+	}
+	return st.h.Wait()
+}
+
+// step takes one scheduling decision and reports whether there was one to
+// take.
+func (s *Simulation) step() bool {
+	s.mu.Lock()
+	slices.SortStableFunc(s.waiting, func(a, b *simStep) int {
+		return cmp.Or(cmp.Compare(a.step.Stage, b.step.Stage), strings.Compare(a.key, b.key), cmp.Compare(a.step.Seq, b.step.Seq))
+	})
+	n := len(s.waiting)
+	at, pending := s.clock.next()
+	choices := n
+	if pending {
+		choices++
+	}
+	if choices == 0 {
+		s.mu.Unlock()
+		return false
+	}
+
+	i := s.rng.IntN(choices)
+	if i == n {
+		s.trace = append(s.trace, "clock +"+at.Sub(s.clock.Now()).String())
+		s.mu.Unlock()
+		s.clock.Set(at)
+		return true
+	}
+	w := s.waiting[i]
+	s.waiting = slices.Delete(s.waiting, i, i+1)
+	s.trace = append(s.trace, formatStep(w))
+	s.mu.Unlock()
+	close(w.grant)
+	return true
+}
+
+func (s *Simulation) record(line string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trace = append(s.trace, line)
+}
+
+func formatStep(w *simStep) string {
+	name := w.step.Name
+	if name == "" {
+		name = "stage " + strconv.Itoa(w.step.Stage)
+	}
+	return name + ": " + w.key
+}
+
+// Explore calls fn with simulations seeded from 1 to n, each in a subtest
+// named "seed=N". A failing seed replays alone with
+// go test -run 'TestName/seed=N', and its schedule is logged.
+func Explore(t *testing.T, n int, fn func(t *testing.T, sim *Simulation)) {
+	t.Helper()
+	for seed := int64(1); seed <= int64(n); seed++ {
+		t.Run("seed="+strconv.FormatInt(seed, 10), func(t *testing.T) {
+			sim := NewSimulation(seed)
+			t.Cleanup(func() {
+				if t.Failed() {
+					t.Logf("schedule of seed %d:\n%s", seed, strings.Join(sim.Trace(), "\n"))
+				}
+			})
+			fn(t, sim)
+		})
+	}
+}
+
+// goroutineTree tracks the goroutines descending from a root goroutine.
+type goroutineTree struct {
+	known map[int64]bool
+}
+
+// settle waits until every live goroutine of the tree is blocked, and
+// reports false instead if done is closed first.
+func (g *goroutineTree) settle(done <-chan struct{}) bool {
+	for {
+		select {
+		case <-done:
+			return false
+		default:
+		}
+		if g.blocked() {
+			return true
+		}
+		time.Sleep(20 * time.Microsecond)
+	}
+}
+
+// blocked reports whether every live goroutine of the tree waits on a
+// channel or a lock. A goroutine sleeping, in a system call or runnable is
+// still busy. The stack dump stops the world, so it is a consistent view.
+func (g *goroutineTree) blocked() bool {
+	type goroutine struct {
+		id, parent int64
+		blocked    bool
+	}
+	var all []goroutine
+	for _, block := range strings.Split(string(allStacks()), "\n\n") {
+		var gr goroutine
+		header, rest, _ := strings.Cut(block, "\n")
+		state, ok := parseHeader(header, &gr.id)
+		if !ok {
+			continue
+		}
+		gr.blocked = blockedState(state)
+		if i := strings.LastIndex(rest, " in goroutine "); i >= 0 {
+			id, _, _ := strings.Cut(rest[i+len(" in goroutine "):], "\n")
+			gr.parent, _ = strconv.ParseInt(id, 10, 64)
+		}
+		all = append(all, gr)
+	}
+
+	for grown := true; grown; {
+		grown = false
+		for _, gr := range all {
+			if !g.known[gr.id] && g.known[gr.parent] {
+				g.known[gr.id], grown = true, true
+			}
+		}
+	}
+	for _, gr := range all {
+		if g.known[gr.id] && !gr.blocked {
+			return false
+		}
+	}
+	return true
+}
+
+// parseHeader parses "goroutine 18 [chan receive, 2 minutes]:".
+func parseHeader(header string, id *int64) (string, bool) {
+	rest, ok := strings.CutPrefix(header, "goroutine ")
+	if !ok {
+		return "", false
+	}
+	num, state, ok := strings.Cut(rest, " [")
+	if !ok {
+		return "", false
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil {
+		return "", false
+	}
+	*id = n
+	state, _, _ = strings.Cut(state, "]")
+	state, _, _ = strings.Cut(state, ",")
+	return state, true
+}
+
+func blockedState(state string) bool {
+	return strings.HasPrefix(state, "chan ") || strings.HasPrefix(state, "select") ||
+		strings.HasPrefix(state, "sync.") || strings.HasPrefix(state, "semacquire")
+}
+
+func allStacks() []byte {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+func goid() int64 {
+	var buf [64]byte
+	var id int64
+	header, _, _ := strings.Cut(string(buf[:runtime.Stack(buf[:], false)]), "\n")
+	parseHeader(header, &id)
+	return id
+}
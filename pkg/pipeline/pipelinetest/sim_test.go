@@ -0,0 +1,166 @@
+package pipelinetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jpconstantineau/data-duct/pkg/pipeline"
+)
+
+// simulateOrder runs a two-stage pipeline with concurrent workers under seed
+// and returns the order the first stage saw its items in, the sink order and
+// the schedule.
+func simulateOrder(t *testing.T, seed int64) (calls, got []int, trace []string) {
+	t.Helper()
+	var mu sync.Mutex
+	record := func(ctx context.Context, n int) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, n)
+		return n, nil
+	}
+
+	sim := NewSimulation(seed)
+	var sink Collector[int]
+	r := pipeline.New("sim", FromSlice([]int{1, 2, 3, 4, 5, 6, 7, 8}), sim.Options()...).
+		Then(record, pipeline.WithStageConcurrency(4)).
+		Then(double, pipeline.WithStageConcurrency(2)).
+		To(sink.Write)
+	res, err := sim.Run(context.Background(), r)
+	AssertSucceeded(t, res, err)
+	return calls, sink.Items(), sim.Trace()
+}
+
+func TestSimulationReplaysSeed(t *testing.T) {
+	t.Parallel()
+
+	calls, got, trace := simulateOrder(t, 7)
+	for range 3 {
+		c, g, tr := simulateOrder(t, 7)
+		if !slices.Equal(c, calls) || !slices.Equal(g, got) || !slices.Equal(tr, trace) {
+			t.Fatalf("seed 7 did not replay:\n%v %v\n%v %v", calls, got, c, g)
+		}
+	}
+
+	orders := map[string]bool{}
+	for seed := int64(1); seed <= 10; seed++ {
+		_, g, _ := simulateOrder(t, seed)
+		if len(g) != 8 {
+			t.Fatalf("seed %d lost items: %v", seed, g)
+		}
+		orders[fmt.Sprint(g)] = true
+	}
+	if len(orders) < 2 {
+		t.Fatalf("ten seeds explored a single interleaving")
+	}
+}
+
+func TestSimulationFiresBatchTimers(t *testing.T) {
+	t.Parallel()
+
+	timers := false
+	Explore(t, 20, func(t *testing.T, sim *Simulation) {
+		batch := func(ctx context.Context, in []int) ([]int, error) { return in, nil }
+		var sink Collector[int]
+		r := pipeline.New("batches", FromSlice([]int{1, 2, 3, 4, 5, 6, 7}), sim.Options()...).
+			ThenBatch(batch, pipeline.BatchPolicy{Size: 3, MaxWait: time.Minute}).
+			To(sink.Write)
+
+		start := time.Now()
+		res, err := sim.Run(context.Background(), r)
+		AssertSucceeded(t, res, err)
+		if got := sink.Items(); !slices.Equal(got, []int{1, 2, 3, 4, 5, 6, 7}) {
+			t.Fatalf("expected every item in order, got %v", got)
+		}
+		if time.Since(start) > 10*time.Second {
+			t.Fatalf("simulated MaxWait took real time")
+		}
+		if slices.ContainsFunc(sim.Trace(), func(s string) bool { return strings.HasPrefix(s, "clock +") }) {
+			timers = true
+		}
+	})
+	if !timers {
+		t.Fatalf("no seed fired a MaxWait timer")
+	}
+}
+
+func TestSimulationStalls(t *testing.T) {
+	t.Parallel()
+
+	never := make(chan int)
+	sim := NewSimulation(1)
+	r := pipeline.New("stuck", func(ctx context.Context) (<-chan int, error) { return never, nil }, sim.Options()...).
+		To(func(ctx context.Context, n int) error { return nil })
+	res, err := sim.Run(context.Background(), r)
+	if !errors.Is(err, ErrStalled) {
+		t.Fatalf("expected ErrStalled, got %v (%v)", err, res)
+	}
+	if trace := sim.Trace(); len(trace) == 0 || trace[len(trace)-1] != "stalled" {
+		t.Fatalf("expected the schedule to record the stall, got %v", trace)
+	}
+}
+
+func TestSimulationStallAbandonsWaitingCalls(t *testing.T) {
+	t.Parallel()
+
+	sim := NewSimulation(1)
+	sim.MaxSteps = 1
+	r := pipeline.New("cut", FromSlice([]int{1, 2, 3, 4}), sim.Options()...).
+		Then(double, pipeline.WithStageConcurrency(2)).
+		To(func(ctx context.Context, n int) error { return nil })
+	if _, err := sim.Run(context.Background(), r); !errors.Is(err, ErrStalled) {
+		t.Fatalf("expected ErrStalled, got %v", err)
+	}
+	st := r.Stats()
+	if st.Stages[0].Errors != 0 || st.Sink.Errors != 0 {
+		t.Fatalf("expected calls cut off by the stall not to count as errors, got %+v", st)
+	}
+	if st.Abandoned == 0 {
+		t.Fatalf("expected the waiting calls to be abandoned, got %+v", st)
+	}
+}
+
+// opaque items all print the same.
+type opaque struct{ id int }
+
+func (opaque) String() string { return "opaque" }
+
+func TestSimulationOrdersEqualValuesBySeq(t *testing.T) {
+	t.Parallel()
+
+	run := func() []int {
+		var (
+			mu  sync.Mutex
+			ids []int
+		)
+		items := make([]opaque, 8)
+		for i := range items {
+			items[i] = opaque{id: i}
+		}
+		sim := NewSimulation(3)
+		r := pipeline.New("opaque", FromSlice(items), sim.Options()...).
+			Then(func(ctx context.Context, o opaque) (opaque, error) {
+				mu.Lock()
+				ids = append(ids, o.id)
+				mu.Unlock()
+				return o, nil
+			}, pipeline.WithStageConcurrency(4)).
+			To(func(ctx context.Context, o opaque) error { return nil })
+		res, err := sim.Run(context.Background(), r)
+		AssertSucceeded(t, res, err)
+		return ids
+	}
+
+	want := run()
+	for range 10 {
+		if got := run(); !slices.Equal(got, want) {
+			t.Fatalf("items that print the same were scheduled in arrival order: %v, then %v", want, got)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package pipelinetest
+
+import (
+	"context"
+
+	"github.com/jpconstantineau/data-duct/pkg/pipeline"
+)
+
+// FromSlice returns a source that emits items in order and then closes; each
+// run starts over.
+func FromSlice[T any](items []T) pipeline.SourceFunc[T] {
+	return func(ctx context.Context) (<-chan T, error) {
+		ch := make(chan T)
+		go func() {
+			defer close(ch)
+			for _, v := range items {
+				select {
+				case <-ctx.Done():
+					return
+				case ch <- v:
+				}
+			}
+		}()
+		return ch, nil
+	}
+}
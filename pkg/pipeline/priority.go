@@ -0,0 +1,41 @@
+package pipeline
+
+import (
+	"time"
+
+	"github.com/jpconstantineau/data-duct/internal/pipelineinternal"
+)
+
+// defaultPriorityAging is used when WithPriorityAging is not given.
+const defaultPriorityAging = time.Second
+
+// WithPriority orders every queue by fn, computed once per source item;
+// higher values go first. T must be the source item type.
+func WithPriority[T any](fn func(T) int) Option {
+	return func(o *pipelineOptions) {
+		o.priorityType = typeOf[T]()
+		o.priority = func(v any) int {
+			t, _ := v.(T)
+			return fn(t)
+		}
+	}
+}
+
+// WithPriorityAging sets how long an item waits before its priority is
+// raised by one; one second by default.
+func WithPriorityAging(d time.Duration) Option {
+	return func(o *pipelineOptions) {
+		o.priorityAging = d
+	}
+}
+
+func (o pipelineOptions) priorityConfig() *pipelineinternal.PriorityConfig {
+	if o.priority == nil {
+		return nil
+	}
+	aging := o.priorityAging
+	if aging <= 0 {
+		aging = defaultPriorityAging
+	}
+	return &pipelineinternal.PriorityConfig{Of: o.priority, Aging: aging}
+}
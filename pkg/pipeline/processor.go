@@ -0,0 +1,131 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jpconstantineau/data-duct/internal/pipelineinternal"
+)
+
+// Processor is a single-item handler that owns resources. If it implements
+// Opener or Closer, it is opened before the stage's first item and closed
+// once the stage is done, on every exit path.
+type Processor[In, Out any] interface {
+	Process(ctx context.Context, input In) (Out, error)
+}
+
+// BatchProcessor is the batch form of Processor.
+type BatchProcessor[In, Out any] interface {
+	ProcessBatch(ctx context.Context, inputs []In) ([]Out, error)
+}
+
+// Sink is the Processor form of a sink. A TxSink that implements Opener or
+// Closer is opened and closed in the same way.
+type Sink[T any] interface {
+	Write(ctx context.Context, item T) error
+}
+
+// Opener is implemented by processors and sinks that acquire resources
+// before their first item. An error fails the run, and the items meant for
+// the stage fail with it.
+type Opener interface {
+	Open(ctx context.Context) error
+}
+
+// Closer is implemented by processors and sinks that release resources. An
+// error fails the run unless it was cancelled.
+type Closer interface {
+	Close(ctx context.Context) error
+}
+
+// ThenProcessor adds a single-item stage run by p. Its workers share p,
+// which is opened once per run.
+func ThenProcessor[In, Out any](t *Typed[In], p Processor[In, Out], opts ...StageOption) *Typed[Out] {
+	if p == nil {
+		return Then[In, Out](t, nil, opts...)
+	}
+	next := Then(t, p.Process, opts...)
+	next.p.lastStage().life = lifecycleOf(p)
+	return next
+}
+
+// ThenPerWorker adds a single-item stage whose workers each open and close
+// their own processor, created by newProcessor.
+func ThenPerWorker[In, Out any](t *Typed[In], newProcessor func() Processor[In, Out], opts ...StageOption) *Typed[Out] {
+	if newProcessor == nil {
+		return Then[In, Out](t, nil, opts...)
+	}
+	next := Then(t, func(ctx context.Context, input In) (Out, error) {
+		return pipelineinternal.Resource(ctx).(Processor[In, Out]).Process(ctx, input)
+	}, opts...)
+	next.p.lastStage().life = &pipelineinternal.Lifecycle{
+		PerWorker: true,
+		Open: func(ctx context.Context) (any, error) {
+			p := newProcessor()
+			if p == nil {
+				return nil, errors.New("processor factory returned nil")
+			}
+			if o, ok := p.(Opener); ok {
+				if err := o.Open(ctx); err != nil {
+					return nil, err
+				}
+			}
+			return p, nil
+		},
+		Close: func(ctx context.Context, p any) error {
+			if c, ok := p.(Closer); ok {
+				return c.Close(ctx)
+			}
+			return nil
+		},
+	}
+	return next
+}
+
+// ThenBatchProcessor adds a batch stage run by p.
+func ThenBatchProcessor[In, Out any](t *Typed[In], p BatchProcessor[In, Out], batch BatchPolicy, opts ...StageOption) *Typed[Out] {
+	if p == nil {
+		return ThenBatch[In, Out](t, nil, batch, opts...)
+	}
+	next := ThenBatch(t, p.ProcessBatch, batch, opts...)
+	next.p.lastStage().life = lifecycleOf(p)
+	return next
+}
+
+// ToSink finalizes the pipeline with s.
+func ToSink[T any](t *Typed[T], s Sink[T], opts ...StageOption) *Runnable {
+	if s == nil {
+		return To[T](t, nil, opts...)
+	}
+	r := To(t, s.Write, opts...)
+	r.def.sinkLife = lifecycleOf(s)
+	return r
+}
+
+// lastStage returns the stage added last.
+func (p *Pipeline) lastStage() *stageDef {
+	return &p.def.stages[len(p.def.stages)-1]
+}
+
+// lifecycleOf opens and closes v if it implements Opener or Closer.
+func lifecycleOf(v any) *pipelineinternal.Lifecycle {
+	o, _ := v.(Opener)
+	c, _ := v.(Closer)
+	if o == nil && c == nil {
+		return nil
+	}
+	l := &pipelineinternal.Lifecycle{
+		Open: func(ctx context.Context) (any, error) {
+			if o != nil {
+				if err := o.Open(ctx); err != nil {
+					return nil, err
+				}
+			}
+			return v, nil
+		},
+	}
+	if c != nil {
+		l.Close = func(ctx context.Context, _ any) error { return c.Close(ctx) }
+	}
+	return l
+}
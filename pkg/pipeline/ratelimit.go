@@ -0,0 +1,82 @@
+package pipeline
+
+import (
+	"context"
+
+	"github.com/jpconstantineau/data-duct/internal/pipelineinternal"
+)
+
+// RateLimiter is a token bucket that caps how often handlers are called; each
+// call takes one token. It may be shared by stages of several pipelines.
+type RateLimiter struct {
+	l     *pipelineinternal.RateLimiter
+	rate  float64
+	burst int
+}
+
+// NewRateLimiter creates a full bucket allowing rate calls per second with
+// bursts of up to burst calls. A non-positive rate does not limit.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{l: pipelineinternal.NewRateLimiter(rate, burst), rate: rate, burst: burst}
+}
+
+// Rate returns the refill rate in tokens per second.
+func (r *RateLimiter) Rate() float64 {
+	return r.rate
+}
+
+// Burst returns the bucket size.
+func (r *RateLimiter) Burst() int {
+	return r.burst
+}
+
+// Wait takes a token, blocking until one is available or ctx is done. It lets
+// code outside a pipeline draw from the same budget, on the system clock.
+func (r *RateLimiter) Wait(ctx context.Context) error {
+	return r.l.Wait(ctx, pipelineinternal.RealClock{})
+}
+
+// WithRateLimit limits a stage to rate handler calls per second with bursts of
+// up to burst calls.
+func WithRateLimit(rate float64, burst int) StageOption {
+	l := NewRateLimiter(rate, burst)
+	return func(o *stageOptions) {
+		o.rate = l
+	}
+}
+
+// WithSharedRateLimit makes a stage draw from l, which other stages may share.
+func WithSharedRateLimit(l *RateLimiter) StageOption {
+	return func(o *stageOptions) {
+		o.rate = l
+	}
+}
+
+// Throttle adds a stage named "throttle" that passes items through at no more
+// than rate items per second, with bursts of up to burst items.
+func (p *Pipeline) Throttle(rate float64, burst int, opts ...StageOption) *Pipeline {
+	if p == nil || p.def == nil {
+		panic("pipeline: builder must not be nil")
+	}
+
+	so := p.stageOptions(append([]StageOption{WithStageName("throttle")}, opts...))
+	p.checkSpill(so, p.def.currentType)
+	if so.rate == nil {
+		so.rate = NewRateLimiter(rate, burst)
+	}
+
+	p.def.stages = append(p.def.stages, stageDef{
+		kind:        stageSingle,
+		name:        so.name,
+		buffer:      so.buffer,
+		overflow:    so.overflow,
+		concurrency: so.concurrency,
+		rate:        so.rate,
+		spill:       so.spill,
+		single:      func(ctx context.Context, input any) (any, error) { return input, nil },
+	})
+	return p
+}
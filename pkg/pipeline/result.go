@@ -6,6 +6,7 @@ const (
 	StateSucceeded State = "succeeded"
 	StateCancelled State = "cancelled"
 	StateFailed    State = "failed"
+	StateStopped   State = "stopped"
 )
 
 type Result interface {
@@ -27,3 +28,14 @@ type Failed struct{ Cause error }
 
 func (f Failed) State() State { return StateFailed }
 func (f Failed) Err() error   { return f.Cause }
+
+// Stopped is the result of a graceful stop. Completed counts the items
+// written after intake stopped, Abandoned those cut off by the drain deadline.
+type Stopped struct {
+	Cause     error
+	Completed int64
+	Abandoned int64
+}
+
+func (s Stopped) State() State { return StateStopped }
+func (s Stopped) Err() error   { return s.Cause }
@@ -2,50 +2,97 @@ package pipeline
 
 import (
 	"context"
+	"sync/atomic"
+	"time"
 
 	"github.com/jpconstantineau/data-duct/internal/pipelineinternal"
 )
 
 type Runnable struct {
 	def *definition
+
+	// stats points at the counters of the most recent run.
+	stats  atomic.Pointer[pipelineinternal.Stats]
+	active atomic.Int32
 }
 
+// Run executes the pipeline and blocks until it finishes. It is equivalent to
+// Start followed by Wait.
 func (r *Runnable) Run(ctx context.Context) (Result, error) {
-	if r == nil || r.def == nil {
-		return Failed{Cause: pipelineinternal.ErrInvalidConfig}, pipelineinternal.ErrInvalidConfig
-	}
-	if r.def.source == nil || r.def.sink == nil {
-		return Failed{Cause: pipelineinternal.ErrInvalidConfig}, pipelineinternal.ErrInvalidConfig
+	h, err := r.Start(ctx)
+	if err != nil {
+		return Failed{Cause: err}, err
 	}
+	return h.Wait()
+}
 
-	state, cause := pipelineinternal.Run(
-		ctx,
-		r.def.name,
-		r.def.source,
-		toInternalStages(r.def.stages),
-		r.def.sink,
-		pipelineinternal.Config{DefaultBuffer: r.def.buffer, Logger: r.def.logger},
-	)
+// RunGraceful runs the pipeline until it finishes or stop is closed, which
+// drains it as Handle.Stop does.
+func (r *Runnable) RunGraceful(ctx context.Context, stop <-chan struct{}, drainTimeout time.Duration) (Result, error) {
+	h, err := r.Start(ctx)
+	if err != nil {
+		return Failed{Cause: err}, err
+	}
+	select {
+	case <-stop:
+		h.Stop(drainTimeout)
+	case <-h.Done():
+	}
+	return h.Wait()
+}
 
+func toResult(state pipelineinternal.RunState, cause error) (Result, error) {
 	switch state {
 	case pipelineinternal.StateSucceeded:
 		return Succeeded{}, nil
 	case pipelineinternal.StateCancelled:
 		return Cancelled{Cause: cause}, cause
+	case pipelineinternal.StateStopped:
+		return Stopped{Cause: cause}, cause
 	default:
 		return Failed{Cause: cause}, cause
 	}
 }
 
-func toInternalStages(stages []stageDef) []pipelineinternal.Stage {
+// workers returns the number of workers the stage starts with.
+func (s stageDef) workers() int {
+	if s.kind == stageBatch {
+		return 1
+	}
+	n := max(s.concurrency, 1)
+	if s.limiter != nil {
+		n = max(n, s.limiter.Max)
+	}
+	if a := s.autoscale; a != nil && s.kind == stageSingle {
+		n = min(max(n, a.Min), a.Max)
+	}
+	return n
+}
+
+// toInternalStages converts the stages of one run; states holds the state of
+// its stateful stages, indexed like stages.
+func toInternalStages(stages []stageDef, states []stateRun) []pipelineinternal.Stage {
 	out := make([]pipelineinternal.Stage, 0, len(stages))
-	for _, s := range stages {
-		cfg := pipelineinternal.StageConfig{Buffer: s.buffer, Concurrency: s.concurrency, Name: s.name}
+	for i, s := range stages {
+		cfg := pipelineinternal.StageConfig{Buffer: s.buffer, Overflow: s.overflow.internal(), Concurrency: s.workers(), Name: s.name}
+		if s.autoscale != nil {
+			cfg.MinConcurrency, cfg.MaxConcurrency = s.autoscale.Min, s.autoscale.Max
+		}
+		cfg.Spill = s.spill.internal()
+		if s.rate != nil {
+			cfg.Rate = s.rate.l
+		}
+		if s.limiter != nil {
+			cfg.Limiter = toLimiterConfig(s.limiter)
+		}
 		switch s.kind {
 		case stageBatch:
-			out = append(out, pipelineinternal.Stage{Kind: pipelineinternal.StageBatch, Batch: s.batch, BatchPolicy: s.batchPolicy, Config: cfg})
+			out = append(out, pipelineinternal.Stage{Kind: pipelineinternal.StageBatch, Batch: s.batch, BatchPolicy: s.batchPolicy, Lifecycle: s.life, Config: cfg})
+		case stageKeyed:
+			st := states[i]
+			out = append(out, pipelineinternal.Stage{Kind: pipelineinternal.StageKeyed, Single: wrapSingleCalls(st.handle, s.wrap), Partition: st.partition, Timers: st.timers(), Config: cfg})
 		default:
-			out = append(out, pipelineinternal.Stage{Kind: pipelineinternal.StageSingle, Single: s.single, Config: cfg})
+			out = append(out, pipelineinternal.Stage{Kind: pipelineinternal.StageSingle, Single: s.single, Lifecycle: s.life, Config: cfg})
 		}
 	}
 	return out
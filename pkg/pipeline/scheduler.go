@@ -0,0 +1,51 @@
+package pipeline
+
+import (
+	"context"
+
+	"github.com/jpconstantineau/data-duct/internal/pipelineinternal"
+)
+
+// Step is a handler or sink call waiting for a Scheduler.
+type Step struct {
+	// Stage is the position of the stage, from 0; the sink comes after the
+	// last stage.
+	Stage int
+	// Name is the stage name, or "sink".
+	Name string
+	// Value is the item, or the []any batch of a batch stage.
+	Value any
+	// Seq tells apart items whose values are equal: the admission order of
+	// a source item, or the emission order of a batch or timer output.
+	Seq uint64
+}
+
+// Scheduler decides when the handler and sink calls of a run happen.
+// pipelinetest.Simulation is a Scheduler that runs them one at a time in a
+// seeded order; most pipelines never need one.
+type Scheduler interface {
+	// Wait blocks until step may run. It returns an error only when ctx is
+	// done, which abandons the item.
+	Wait(ctx context.Context, step Step) error
+}
+
+// WithScheduler makes every handler and sink call of the pipeline wait for
+// scheduler first.
+func WithScheduler(scheduler Scheduler) Option {
+	return func(o *pipelineOptions) {
+		o.scheduler = scheduler
+	}
+}
+
+func internalScheduler(s Scheduler) pipelineinternal.Scheduler {
+	if s == nil {
+		return nil
+	}
+	return schedulerAdapter{s}
+}
+
+type schedulerAdapter struct{ s Scheduler }
+
+func (a schedulerAdapter) Wait(ctx context.Context, step pipelineinternal.Step) error {
+	return a.s.Wait(ctx, Step(step))
+}
@@ -0,0 +1,80 @@
+package pipeline
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// SignalPolicy configures RunWithSignals.
+type SignalPolicy struct {
+	// Signals drain on first delivery and cancel on the second. Defaults to
+	// SIGINT and SIGTERM.
+	Signals []os.Signal
+	// DrainTimeout bounds the drain; zero waits without a deadline.
+	DrainTimeout time.Duration
+	// FlushOnHangup flushes partial batches in every batch stage on SIGHUP.
+	FlushOnHangup bool
+}
+
+// Flush asks every batch stage to flush its partial batch immediately,
+// regardless of Size and MaxWait.
+func (h *Handle) Flush() {
+	h.exec.Flush()
+}
+
+// RunWithSignals runs r until it finishes: the first stop signal drains the
+// run as Handle.Stop does and the second cancels it.
+func RunWithSignals(ctx context.Context, r *Runnable, policy SignalPolicy) (Result, error) {
+	stopSignals := policy.Signals
+	if len(stopSignals) == 0 {
+		stopSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	notify := append([]os.Signal(nil), stopSignals...)
+	if policy.FlushOnHangup {
+		notify = append(notify, syscall.SIGHUP)
+	}
+
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, notify...)
+	defer signal.Stop(sigs)
+
+	return runWithSignals(ctx, r, policy, stopSignals, sigs)
+}
+
+func runWithSignals(ctx context.Context, r *Runnable, policy SignalPolicy, stopSignals []os.Signal, sigs <-chan os.Signal) (Result, error) {
+	h, err := r.Start(ctx)
+	if err != nil {
+		return Failed{Cause: err}, err
+	}
+
+	stopping := false
+	for {
+		select {
+		case <-h.Done():
+			return h.Wait()
+		case sig := <-sigs:
+			switch {
+			case policy.FlushOnHangup && sig == syscall.SIGHUP:
+				h.Flush()
+			case !isOneOf(sig, stopSignals):
+			case !stopping:
+				stopping = true
+				h.Stop(policy.DrainTimeout)
+			default:
+				h.Cancel()
+			}
+		}
+	}
+}
+
+func isOneOf(sig os.Signal, set []os.Signal) bool {
+	for _, s := range set {
+		if s == sig {
+			return true
+		}
+	}
+	return false
+}
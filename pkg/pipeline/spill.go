@@ -0,0 +1,111 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/jpconstantineau/data-duct/internal/pipelineinternal"
+)
+
+// Codec encodes the items of a spilled queue to bytes and back.
+type Codec[T any] interface {
+	Encode(v T) ([]byte, error)
+	Decode(b []byte) (T, error)
+}
+
+// JSONCodec encodes items with encoding/json.
+func JSONCodec[T any]() Codec[T] {
+	return jsonCodec[T]{}
+}
+
+type jsonCodec[T any] struct{}
+
+func (jsonCodec[T]) Encode(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec[T]) Decode(b []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(b, &v)
+	return v, err
+}
+
+// GobCodec encodes items with encoding/gob. Each item is encoded on its own,
+// so type information is repeated per item.
+func GobCodec[T any]() Codec[T] {
+	return gobCodec[T]{}
+}
+
+type gobCodec[T any] struct{}
+
+func (gobCodec[T]) Encode(v T) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (gobCodec[T]) Decode(b []byte) (T, error) {
+	var v T
+	err := gob.NewDecoder(bytes.NewReader(b)).Decode(&v)
+	return v, err
+}
+
+// SpillPolicy configures a disk-backed queue.
+type SpillPolicy struct {
+	// Dir is where spill files go. Each run uses a fresh subdirectory.
+	Dir string
+	// SegmentBytes is the size of one segment file; 16 MiB if zero.
+	SegmentBytes int64
+	// MaxBytes caps the data on disk; once reached, the stage blocks as with
+	// an in-memory buffer. Zero means no cap.
+	MaxBytes int64
+}
+
+type spillDef struct {
+	policy SpillPolicy
+	typ    reflect.Type
+	encode func(any) ([]byte, error)
+	decode func([]byte) (any, error)
+}
+
+// WithStageSpill appends the items that do not fit in the stage's output
+// buffer to an on-disk log, encoded with codec, and replays them in order.
+// T must be the stage's output type. It replaces the overflow policy.
+func WithStageSpill[T any](policy SpillPolicy, codec Codec[T]) StageOption {
+	def := &spillDef{
+		policy: policy,
+		typ:    typeOf[T](),
+		encode: func(v any) ([]byte, error) {
+			t, _ := v.(T) // nil interface values encode as the zero T
+			return codec.Encode(t)
+		},
+		decode: func(b []byte) (any, error) { return codec.Decode(b) },
+	}
+	return func(o *stageOptions) {
+		o.spill = def
+	}
+}
+
+// checkSpill checks the spill codec of the stage being added against its
+// output type, unless that is unknown.
+func (p *Pipeline) checkSpill(so stageOptions, out reflect.Type) {
+	if s := so.spill; s != nil && out != nil && s.typ != out {
+		p.def.failStage(so.name, fmt.Errorf("spill codec type %v does not match stage output %v", s.typ, out))
+	}
+}
+
+func (s *spillDef) internal() *pipelineinternal.SpillConfig {
+	if s == nil {
+		return nil
+	}
+	return &pipelineinternal.SpillConfig{
+		Dir:          s.policy.Dir,
+		SegmentBytes: s.policy.SegmentBytes,
+		MaxBytes:     s.policy.MaxBytes,
+		Encode:       s.encode,
+		Decode:       s.decode,
+	}
+}
@@ -0,0 +1,547 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jpconstantineau/data-duct/internal/pipelineinternal"
+)
+
+// KeyState is the state kept by a stateful stage for one key. It is only
+// valid during the handler or timer call it was passed to.
+type KeyState[K comparable, V any] interface {
+	Key() K
+	Get() (V, bool)
+	Set(v V)
+	// Delete removes the key's value. Its timers are kept.
+	Delete()
+
+	// Now returns the time of the pipeline's clock (see WithClock).
+	Now() time.Time
+	// Watermark returns the stage's event-time watermark (see
+	// WithEventTime), or the zero time before the first item.
+	Watermark() time.Time
+	// SetTimer sets the key's timer called name to fire at the given time,
+	// replacing any timer of that name. It panics without WithTimers, or for
+	// an EventTime timer without WithEventTime.
+	SetTimer(name string, domain TimeDomain, at time.Time)
+	// DeleteTimer removes the key's timer called name, if any.
+	DeleteTimer(name string)
+}
+
+// StatefulThen adds a stage whose handler receives the state of each input's
+// key. Every item of a key goes to the same worker, in order, so handlers need
+// no locking. Name the stage: snapshots are stored under its name.
+func StatefulThen[K comparable, V, In, Out any](p *Pipeline, key func(In) K, handler func(ctx context.Context, st KeyState[K, V], in In) (Out, error), opts ...StageOption) *Pipeline {
+	if p == nil || p.def == nil {
+		panic("pipeline: builder must not be nil")
+	}
+	so := p.stageOptions(opts)
+	fail := func(err error) { p.def.failStage(so.name, err) }
+	if key == nil || handler == nil {
+		fail(errors.New("handler must not be nil"))
+	}
+	if so.autoscale != nil || so.limiter != nil {
+		fail(errors.New("stateful stages have a fixed worker count; autoscaling and limiters do not apply"))
+	}
+	inType, outType := typeOf[In](), typeOf[Out]()
+	if p.def.currentType != nil && !p.def.currentType.AssignableTo(inType) && !p.def.currentType.ConvertibleTo(inType) {
+		fail(fmt.Errorf("handler input type %s is not compatible with previous stage output %s", inType, p.def.currentType))
+	}
+	p.checkSpill(so, outType)
+
+	codec := Codec[V](JSONCodec[V]())
+	if so.stateCodec != nil {
+		if c, ok := so.stateCodec.(Codec[V]); ok {
+			codec = c
+		} else {
+			fail(fmt.Errorf("state codec %T does not match state type %v", so.stateCodec, typeOf[V]()))
+		}
+	}
+	var onTimer func(context.Context, KeyState[K, V], KeyTimer) ([]Out, error)
+	if so.onTimer != nil {
+		if fn, ok := so.onTimer.(func(context.Context, KeyState[K, V], KeyTimer) ([]Out, error)); ok {
+			onTimer = fn
+		} else {
+			fail(fmt.Errorf("timer callback %T does not match stage types %v, %v and %v", so.onTimer, typeOf[K](), typeOf[V](), outType))
+		}
+	}
+	var eventTime func(In) time.Time
+	if so.eventTime != nil {
+		if fn, ok := so.eventTime.(func(In) time.Time); ok {
+			eventTime = fn
+		} else {
+			fail(fmt.Errorf("event time function %T does not match stage input type %v", so.eventTime, inType))
+		}
+	}
+
+	input := func(v any) (In, error) {
+		if in, ok := v.(In); ok {
+			return in, nil
+		}
+		rv, err := adaptValue(v, inType)
+		if err != nil {
+			var zero In
+			return zero, err
+		}
+		in, _ := rv.Interface().(In)
+		return in, nil
+	}
+	def := &stateDef{newRun: func(parts int, clock pipelineinternal.Clock) stateRun {
+		s := &keyedStates[K, V, In]{
+			seed:  maphash.MakeSeed(),
+			parts: newStateParts[K, V](parts),
+			codec: codec,
+			key:   key,
+			input: input,
+			fn: func(ctx context.Context, st KeyState[K, V], in In) (any, error) {
+				return handler(ctx, st, in)
+			},
+			eventTime: eventTime,
+			time:      newStateTime(clock, onTimer != nil, eventTime != nil, so.lateness),
+		}
+		if onTimer != nil {
+			s.onTimer = func(ctx context.Context, st KeyState[K, V], t KeyTimer) ([]any, error) {
+				outs, err := onTimer(ctx, st, t)
+				if err != nil {
+					return nil, err
+				}
+				items := make([]any, len(outs))
+				for i, o := range outs {
+					items[i] = o
+				}
+				return items, nil
+			}
+		}
+		return s
+	}}
+
+	p.def.stages = append(p.def.stages, stageDef{
+		kind:        stageKeyed,
+		name:        so.name,
+		buffer:      so.buffer,
+		overflow:    so.overflow,
+		concurrency: so.concurrency,
+		rate:        so.rate,
+		spill:       so.spill,
+		state:       def,
+		wrap:        so.wrap,
+	})
+	p.def.currentType = outType
+	return p
+}
+
+// WithStateCodec sets the codec used to snapshot the values of a stateful
+// stage. V must be the stage's state type; StatefulThen panics otherwise.
+func WithStateCodec[V any](codec Codec[V]) StageOption {
+	return func(o *stageOptions) {
+		o.stateCodec = codec
+	}
+}
+
+// stateDef creates the state of a stateful stage for each run.
+type stateDef struct {
+	newRun func(parts int, clock pipelineinternal.Clock) stateRun
+}
+
+// stateRun is the state of a stateful stage during one run.
+type stateRun interface {
+	handle(ctx context.Context, input any) (any, error)
+	partition(input any) int
+	// timers returns the stage's timers, or nil without WithTimers.
+	timers() *pipelineinternal.KeyedTimers
+	// bind reports timer activity to checkpoints: emitted registers an item
+	// emitted by a timer and returns its outcome callback, and changed is
+	// called when timers change the state outside of any item.
+	bind(emitted func() func(error), changed func())
+	snapshot() (map[string][]byte, []TimerSnapshot, error)
+	restore(entries map[string][]byte, timers []TimerSnapshot) error
+}
+
+type statePart[K comparable, V any] struct {
+	mu sync.Mutex
+	m  map[K]V
+
+	timers map[timerID[K]]timerEntry
+	queues [2]timerQueue[K]
+	seq    uint64
+	wake   chan struct{}
+}
+
+func newStateParts[K comparable, V any](n int) []*statePart[K, V] {
+	parts := make([]*statePart[K, V], max(1, n))
+	for i := range parts {
+		parts[i] = &statePart[K, V]{m: make(map[K]V), timers: make(map[timerID[K]]timerEntry), wake: make(chan struct{}, 1)}
+	}
+	return parts
+}
+
+type keyedStates[K comparable, V, In any] struct {
+	seed      maphash.Seed
+	parts     []*statePart[K, V]
+	codec     Codec[V]
+	key       func(In) K
+	input     func(any) (In, error)
+	fn        func(ctx context.Context, st KeyState[K, V], in In) (any, error)
+	onTimer   func(ctx context.Context, st KeyState[K, V], t KeyTimer) ([]any, error)
+	eventTime func(In) time.Time
+	time      *stateTime
+
+	emitted func() func(error)
+	changed func()
+}
+
+func (s *keyedStates[K, V, In]) index(k K) int {
+	return int(maphash.Comparable(s.seed, k) % uint64(len(s.parts)))
+}
+
+func (s *keyedStates[K, V, In]) partition(v any) int {
+	in, err := s.input(v)
+	if err != nil {
+		return 0 // handle reports the error
+	}
+	return s.index(s.key(in))
+}
+
+func (s *keyedStates[K, V, In]) handle(ctx context.Context, v any) (any, error) {
+	in, err := s.input(v)
+	if err != nil {
+		return nil, err
+	}
+	if s.eventTime != nil && s.time.observe(s.eventTime(in)) && s.onTimer != nil {
+		for _, part := range s.parts {
+			part.poke()
+		}
+	}
+	k := s.key(in)
+	part := s.parts[s.index(k)]
+	// Only the partition's worker calls handle; the lock orders it against
+	// snapshots.
+	part.mu.Lock()
+	defer part.mu.Unlock()
+	return s.fn(ctx, stateHandle[K, V]{part: part, key: k, time: s.time}, in)
+}
+
+func (s *keyedStates[K, V, In]) bind(emitted func() func(error), changed func()) {
+	s.emitted, s.changed = emitted, changed
+}
+
+func (s *keyedStates[K, V, In]) timers() *pipelineinternal.KeyedTimers {
+	if s.onTimer == nil {
+		return nil
+	}
+	return &pipelineinternal.KeyedTimers{
+		Next: func(partition int) (time.Time, bool) {
+			part := s.parts[partition]
+			part.mu.Lock()
+			defer part.mu.Unlock()
+			t, ok := part.peek(ProcessingTime)
+			return t.at, ok
+		},
+		Wake: func(partition int) <-chan struct{} { return s.parts[partition].wake },
+		Fire: s.fire,
+	}
+}
+
+// fire runs the due timers of a partition in deadline order.
+func (s *keyedStates[K, V, In]) fire(ctx context.Context, partition int, now time.Time) ([]pipelineinternal.Tracked, error) {
+	part := s.parts[partition]
+	part.mu.Lock()
+	defer part.mu.Unlock()
+	due := part.due(ProcessingTime, now, nil)
+	if wm, ok := s.time.watermark(); ok {
+		due = part.due(EventTime, wm, due)
+	}
+	if len(due) == 0 {
+		return nil, nil
+	}
+	// Called under the lock, so a snapshot either precedes these timers or
+	// sees the change.
+	if s.changed != nil {
+		s.changed()
+	}
+	slices.SortStableFunc(due, func(a, b queuedTimer[K]) int { return a.at.Compare(b.at) })
+
+	var (
+		outs  []pipelineinternal.Tracked
+		dones []func(error)
+	)
+	for _, t := range due {
+		items, err := s.onTimer(ctx, stateHandle[K, V]{part: part, key: t.id.key, time: s.time}, KeyTimer{Name: t.id.name, Domain: t.domain, At: t.at})
+		if err != nil {
+			for _, done := range dones {
+				done(err)
+			}
+			return nil, err
+		}
+		for _, item := range items {
+			o := pipelineinternal.Tracked{Value: item, Key: timerKey(t.id.key, t.id.name, t.at)}
+			if s.emitted != nil {
+				// Registered under the lock, like changed.
+				done := s.emitted()
+				dones = append(dones, done)
+				o.Admit = func() func(error) { return done }
+			}
+			outs = append(outs, o)
+		}
+	}
+	return outs, nil
+}
+
+func (s *keyedStates[K, V, In]) snapshot() (map[string][]byte, []TimerSnapshot, error) {
+	entries := make(map[string][]byte)
+	var timers []TimerSnapshot
+	for _, part := range s.parts {
+		part.mu.Lock()
+		err := func() error {
+			for k, v := range part.m {
+				kb, err := json.Marshal(k)
+				if err != nil {
+					return err
+				}
+				vb, err := s.codec.Encode(v)
+				if err != nil {
+					return err
+				}
+				entries[string(kb)] = vb
+			}
+			for id, e := range part.timers {
+				kb, err := json.Marshal(id.key)
+				if err != nil {
+					return err
+				}
+				timers = append(timers, TimerSnapshot{Key: string(kb), Name: id.name, Domain: e.domain, At: e.at})
+			}
+			return nil
+		}()
+		part.mu.Unlock()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return entries, timers, nil
+}
+
+func (s *keyedStates[K, V, In]) restore(entries map[string][]byte, timers []TimerSnapshot) error {
+	for kb, vb := range entries {
+		var k K
+		if err := json.Unmarshal([]byte(kb), &k); err != nil {
+			return err
+		}
+		v, err := s.codec.Decode(vb)
+		if err != nil {
+			return err
+		}
+		part := s.parts[s.index(k)]
+		part.mu.Lock()
+		part.m[k] = v
+		part.mu.Unlock()
+	}
+	for _, t := range timers {
+		var k K
+		if err := json.Unmarshal([]byte(t.Key), &k); err != nil {
+			return err
+		}
+		part := s.parts[s.index(k)]
+		part.mu.Lock()
+		part.setTimer(timerID[K]{key: k, name: t.Name}, t.Domain, t.At)
+		part.mu.Unlock()
+	}
+	return nil
+}
+
+// timerKey is the idempotency key of an item emitted by a timer, which is
+// the same when the timer fires again after a restore.
+func timerKey[K comparable](k K, name string, at time.Time) string {
+	kb, _ := json.Marshal(k)
+	return "timer:" + string(kb) + ":" + name + ":" + strconv.FormatInt(at.UnixNano(), 10)
+}
+
+type stateHandle[K comparable, V any] struct {
+	part *statePart[K, V]
+	key  K
+	time *stateTime
+}
+
+func (h stateHandle[K, V]) Key() K { return h.key }
+
+func (h stateHandle[K, V]) Get() (V, bool) {
+	v, ok := h.part.m[h.key]
+	return v, ok
+}
+
+func (h stateHandle[K, V]) Set(v V) { h.part.m[h.key] = v }
+
+func (h stateHandle[K, V]) Delete() { delete(h.part.m, h.key) }
+
+func (h stateHandle[K, V]) Now() time.Time { return h.time.clock.Now() }
+
+func (h stateHandle[K, V]) Watermark() time.Time {
+	wm, _ := h.time.watermark()
+	return wm
+}
+
+func (h stateHandle[K, V]) SetTimer(name string, domain TimeDomain, at time.Time) {
+	switch {
+	case !h.time.timers:
+		panic("pipeline: SetTimer requires WithTimers")
+	case domain == EventTime && !h.time.eventTime:
+		panic("pipeline: event-time timers require WithEventTime")
+	case domain != ProcessingTime && domain != EventTime:
+		panic(fmt.Sprintf("pipeline: unknown time domain %d", domain))
+	}
+	h.part.setTimer(timerID[K]{key: h.key, name: name}, domain, at)
+}
+
+func (h stateHandle[K, V]) DeleteTimer(name string) {
+	delete(h.part.timers, timerID[K]{key: h.key, name: name})
+}
+
+// stateLabel is the name under which a stateful stage's snapshots are kept.
+func stateLabel(i int, s stageDef) string {
+	if s.name != "" {
+		return s.name
+	}
+	return strconv.Itoa(i)
+}
+
+// newStates creates the state of every stateful stage for one run, indexed
+// like the stages.
+func (d *definition) newStates() []stateRun {
+	var states []stateRun
+	for i, s := range d.stages {
+		if s.state == nil {
+			continue
+		}
+		if states == nil {
+			states = make([]stateRun, len(d.stages))
+		}
+		states[i] = s.state.newRun(max(1, s.concurrency), d.clock)
+	}
+	return states
+}
+
+// StateSnapshot is the state of every stateful stage of a pipeline at a
+// checkpoint position.
+type StateSnapshot struct {
+	Pipeline string `json:"pipeline"`
+	Position string `json:"position"`
+	// Stages maps each stateful stage to its encoded keys and values, and
+	// Timers to its pending timers.
+	Stages map[string]map[string][]byte `json:"stages"`
+	Timers map[string][]TimerSnapshot   `json:"timers,omitempty"`
+	Time   time.Time                    `json:"time"`
+}
+
+// StateStore persists the state snapshots of stateful stages.
+// Implementations must be safe for concurrent use by several pipelines.
+type StateStore interface {
+	// Load returns the last snapshot saved for the pipeline, reporting false
+	// if there is none.
+	Load(ctx context.Context, pipeline string) (StateSnapshot, bool, error)
+	Save(ctx context.Context, snap StateSnapshot) error
+}
+
+// WithStateStore snapshots the state of stateful stages into store at each
+// checkpoint, after draining the pipeline. It requires WithCheckpoints.
+func WithStateStore(store StateStore) Option {
+	return func(o *pipelineOptions) {
+		o.stateStore = store
+	}
+}
+
+// MemoryStateStore keeps snapshots in memory, so state survives between runs
+// of a process but not a restart.
+type MemoryStateStore struct {
+	mu    sync.Mutex
+	snaps map[string]StateSnapshot
+}
+
+// NewMemoryStateStore returns an empty in-memory store.
+func NewMemoryStateStore() *MemoryStateStore {
+	return &MemoryStateStore{snaps: make(map[string]StateSnapshot)}
+}
+
+// Load implements StateStore.
+func (s *MemoryStateStore) Load(_ context.Context, pipeline string) (StateSnapshot, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap, ok := s.snaps[pipeline]
+	return snap, ok, nil
+}
+
+// Save implements StateStore.
+func (s *MemoryStateStore) Save(_ context.Context, snap StateSnapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snaps[snap.Pipeline] = snap
+	return nil
+}
+
+// FileStateStore keeps one JSON file per pipeline in a directory. Files are
+// replaced atomically.
+type FileStateStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileStateStore returns a store writing to dir, which is created on the
+// first save if needed.
+func NewFileStateStore(dir string) *FileStateStore {
+	return &FileStateStore{dir: dir}
+}
+
+func (s *FileStateStore) path(pipeline string) string {
+	return filepath.Join(s.dir, storeFileName(pipeline, ".state.json"))
+}
+
+// Load implements StateStore.
+func (s *FileStateStore) Load(_ context.Context, pipeline string) (StateSnapshot, bool, error) {
+	b, err := os.ReadFile(s.path(pipeline))
+	if errors.Is(err, os.ErrNotExist) {
+		return StateSnapshot{}, false, nil
+	}
+	if err != nil {
+		return StateSnapshot{}, false, err
+	}
+	var snap StateSnapshot
+	if err := json.Unmarshal(b, &snap); err != nil {
+		return StateSnapshot{}, false, fmt.Errorf("pipeline: state snapshot %s: %w", s.path(pipeline), err)
+	}
+	return snap, true, nil
+}
+
+// Save implements StateStore.
+func (s *FileStateStore) Save(_ context.Context, snap StateSnapshot) error {
+	b, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeFileAtomic(s.dir, s.path(snap.Pipeline), b)
+}
+
+// checkState reports a problem if the pipeline's stateful stages cannot be
+// checkpointed.
+func (p *Pipeline) checkState() {
+	d := p.def
+	if d.checkpoints == nil || d.stateStore != nil {
+		return
+	}
+	for _, s := range d.stages {
+		if s.state != nil {
+			d.failSink(errors.New("stateful stages with checkpoints need WithStateStore"))
+			return
+		}
+	}
+}
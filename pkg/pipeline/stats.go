@@ -0,0 +1,200 @@
+package pipeline
+
+import (
+	"time"
+
+	"github.com/jpconstantineau/data-duct/internal/pipelineinternal"
+)
+
+// StageKind identifies how a stage processes items.
+type StageKind string
+
+const (
+	StageKindSingle StageKind = "single"
+	StageKindBatch  StageKind = "batch"
+	StageKindKeyed  StageKind = "keyed"
+	StageKindSink   StageKind = "sink"
+)
+
+// Description is a static view of a pipeline's stage graph.
+type Description struct {
+	Name   string
+	Buffer int
+	// Overflow is the policy of the queue between the source and the first stage.
+	Overflow OverflowPolicy
+	// MaxInFlight and MaxInFlightBytes are the pipeline-wide in-flight
+	// bounds; zero means unbounded.
+	MaxInFlight      int64
+	MaxInFlightBytes int64
+	// Prioritized is set when queues are ordered by WithPriority, and
+	// PriorityAging is the effective aging interval.
+	Prioritized   bool
+	PriorityAging time.Duration
+	// Stages lists the processing stages in order, followed by the sink.
+	Stages []StageInfo
+}
+
+// StageInfo describes one stage as configured at build time.
+type StageInfo struct {
+	Index int
+	Name  string
+	Kind  StageKind
+	// Concurrency is the number of workers the stage starts with, after
+	// limiter and autoscale adjustments.
+	Concurrency int
+	// Buffer is the capacity of the queue feeding the next stage and Overflow
+	// what happens when it is full.
+	Buffer   int
+	Overflow OverflowPolicy
+	// Batch is set for batch stages only.
+	Batch *BatchPolicy
+	// Autoscale is set for autoscaled stages only.
+	Autoscale *AutoscalePolicy
+	// Limiter is set for stages with an adaptive limiter only.
+	Limiter *Limiter
+	// Spill is set for stages whose output queue spills to disk.
+	Spill *SpillPolicy
+	// Tx is set for a transactional sink only.
+	Tx *TxPolicy
+	// RateLimit is the stage's token bucket, if any. Stages sharing a bucket
+	// report the same pointer.
+	RateLimit *RateLimiter
+}
+
+// Stats is a point-in-time snapshot of a run's counters.
+type Stats struct {
+	// Running reports whether any run of the pipeline is in progress.
+	Running bool
+	// Admitted counts the items accepted from the source.
+	Admitted int64
+	// Abandoned counts items discarded because the run was cancelled.
+	Abandoned int64
+	// Dropped counts items discarded by overflow policies, including items
+	// from the source.
+	Dropped int64
+	// InFlight and InFlightBytes are the source items, and their size, held
+	// by the in-flight bounds.
+	InFlight      int64
+	InFlightBytes int64
+	Stages        []StageStats
+	Sink          StageStats
+}
+
+// StageStats counts the items seen by one stage.
+type StageStats struct {
+	Name string
+	// In counts items taken from the stage's input queue.
+	In int64
+	// Out counts items emitted downstream (or written, for the sink).
+	Out int64
+	// Errors counts handler failures, including recovered panics.
+	Errors int64
+	// Dropped counts emitted items discarded by the overflow policy of the
+	// queue to the next stage.
+	Dropped int64
+	// Queued is the number of items waiting in the stage's input queue.
+	Queued   int
+	QueueCap int
+	// OnDisk is the number of items waiting in the input queue's spill log
+	// and Spilled the number that have gone through it.
+	OnDisk  int
+	Spilled int64
+	// Workers is the current worker count of a single-item stage and Busy the
+	// number of those workers running the handler. Both are zero for other
+	// stages and once the stage has finished.
+	Workers int
+	Busy    int
+	// Limit is the current adaptive concurrency limit and InFlight the handler
+	// calls it has admitted. Both are zero for stages without a limiter.
+	Limit    int
+	InFlight int
+}
+
+// Name returns the pipeline name.
+func (r *Runnable) Name() string {
+	if r == nil || r.def == nil {
+		return ""
+	}
+	return r.def.name
+}
+
+// Describe returns the configured stage graph.
+func (r *Runnable) Describe() Description {
+	if r == nil || r.def == nil {
+		return Description{}
+	}
+	d := Description{Name: r.def.name, Buffer: r.def.buffer, Overflow: r.def.overflow,
+		MaxInFlight: r.def.maxInFlight, MaxInFlightBytes: r.def.maxInFlightBytes}
+	if p := r.def.priority; p != nil {
+		d.Prioritized, d.PriorityAging = true, p.Aging
+	}
+	for i, s := range r.def.stages {
+		info := StageInfo{Index: i, Name: s.name, Kind: StageKindSingle, Concurrency: s.workers(), Buffer: s.buffer, Overflow: s.overflow}
+		switch {
+		case s.kind == stageBatch:
+			info.Kind = StageKindBatch
+			info.Batch = &BatchPolicy{Size: s.batchPolicy.Size, MaxWait: s.batchPolicy.MaxWait}
+		case s.kind == stageKeyed:
+			info.Kind = StageKindKeyed
+		case s.autoscale != nil:
+			a := *s.autoscale
+			info.Autoscale = &a
+		}
+		if s.limiter != nil {
+			l := *s.limiter
+			info.Limiter = &l
+		}
+		info.RateLimit = s.rate
+		if s.spill != nil {
+			sp := s.spill.policy
+			info.Spill = &sp
+		}
+		d.Stages = append(d.Stages, info)
+	}
+	sink := StageInfo{Index: len(r.def.stages), Name: "sink", Kind: StageKindSink, Concurrency: 1}
+	if r.def.txPolicy != nil {
+		tx := *r.def.txPolicy
+		sink.Tx = &tx
+	}
+	d.Stages = append(d.Stages, sink)
+	return d
+}
+
+// Stats returns the counters of the most recent run, which may still be in
+// progress. Before the first run all counters are zero.
+func (r *Runnable) Stats() Stats {
+	if r == nil || r.def == nil {
+		return Stats{}
+	}
+	return snapshotStats(r.def, r.stats.Load(), r.active.Load() > 0)
+}
+
+func snapshotStats(def *definition, s *pipelineinternal.Stats, running bool) Stats {
+	out := Stats{Running: running}
+	for i, st := range def.stages {
+		out.Stages = append(out.Stages, StageStats{Name: st.name})
+		if s != nil {
+			fillStageStats(&out.Stages[i], s.Stages[i])
+		}
+	}
+	out.Sink.Name = "sink"
+	if s != nil {
+		out.Admitted = s.Admitted.Load()
+		out.Abandoned = s.Abandoned.Load()
+		out.Dropped = s.Dropped.Load()
+		out.InFlight, out.InFlightBytes = s.InFlight()
+		fillStageStats(&out.Sink, s.Sink)
+	}
+	return out
+}
+
+func fillStageStats(dst *StageStats, src *pipelineinternal.StageStats) {
+	dst.In = src.In.Load()
+	dst.Out = src.Out.Load()
+	dst.Errors = src.Errors.Load()
+	dst.Dropped = src.Dropped.Load()
+	dst.Queued, dst.QueueCap = src.Queued()
+	dst.OnDisk, dst.Spilled = src.OnDisk(), src.Spilled.Load()
+	dst.Workers, dst.Busy = src.Workers()
+	dst.Limit, dst.InFlight = src.Limit()
+}
@@ -0,0 +1,198 @@
+package pipeline
+
+import (
+	"container/heap"
+	"context"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/jpconstantineau/data-duct/internal/pipelineinternal"
+)
+
+// TimeDomain is the notion of time a timer follows.
+type TimeDomain int
+
+const (
+	// ProcessingTime timers fire when the pipeline's clock (see WithClock)
+	// reaches them.
+	ProcessingTime TimeDomain = iota
+	// EventTime timers fire when the stage's event-time watermark (see
+	// WithEventTime) reaches them.
+	EventTime
+)
+
+func (d TimeDomain) String() string {
+	if d == EventTime {
+		return "event-time"
+	}
+	return "processing-time"
+}
+
+// KeyTimer is a timer of one key of a stateful stage, as passed to the
+// callback of WithTimers when it fires.
+type KeyTimer struct {
+	Name   string
+	Domain TimeDomain
+	At     time.Time
+}
+
+// TimerSnapshot is a pending timer in a StateSnapshot. Key is the
+// JSON-encoded key.
+type TimerSnapshot struct {
+	Key    string     `json:"key"`
+	Name   string     `json:"name"`
+	Domain TimeDomain `json:"domain"`
+	At     time.Time  `json:"at"`
+}
+
+// WithTimers lets the handlers of a stateful stage set timers per key. A due
+// timer runs onTimer on its key's worker, between items, and the items it
+// returns continue like handler outputs.
+func WithTimers[K comparable, V, Out any](onTimer func(ctx context.Context, st KeyState[K, V], t KeyTimer) ([]Out, error)) StageOption {
+	return func(o *stageOptions) {
+		o.onTimer = onTimer
+	}
+}
+
+// WithEventTime drives a stateful stage's event-time timers by a watermark:
+// the latest event time seen minus lateness.
+func WithEventTime[In any](of func(In) time.Time, lateness time.Duration) StageOption {
+	return func(o *stageOptions) {
+		o.eventTime = of
+		o.lateness = lateness
+	}
+}
+
+// stateTime is the time of a stateful stage: its clock and event-time
+// watermark.
+type stateTime struct {
+	clock     pipelineinternal.Clock
+	timers    bool
+	eventTime bool
+	lateness  time.Duration
+	// latest is the latest event time in Unix nanoseconds, or noEventTime.
+	latest atomic.Int64
+}
+
+const noEventTime = math.MinInt64
+
+func newStateTime(clock pipelineinternal.Clock, timers, eventTime bool, lateness time.Duration) *stateTime {
+	t := &stateTime{clock: clock, timers: timers, eventTime: eventTime, lateness: lateness}
+	t.latest.Store(noEventTime)
+	return t
+}
+
+// observe records an event time and reports whether the watermark moved.
+func (t *stateTime) observe(at time.Time) bool {
+	n := at.UnixNano()
+	for {
+		cur := t.latest.Load()
+		if cur != noEventTime && n <= cur {
+			return false
+		}
+		if t.latest.CompareAndSwap(cur, n) {
+			return true
+		}
+	}
+}
+
+func (t *stateTime) watermark() (time.Time, bool) {
+	n := t.latest.Load()
+	if n == noEventTime {
+		return time.Time{}, false
+	}
+	return time.Unix(0, n).Add(-t.lateness), true
+}
+
+type timerID[K comparable] struct {
+	key  K
+	name string
+}
+
+type timerEntry struct {
+	domain TimeDomain
+	at     time.Time
+	seq    uint64
+}
+
+type queuedTimer[K comparable] struct {
+	id     timerID[K]
+	domain TimeDomain
+	at     time.Time
+	seq    uint64
+}
+
+// timerQueue orders the timers of one domain by deadline. Replaced and
+// deleted timers stay queued until they surface or the queue is compacted.
+type timerQueue[K comparable] []queuedTimer[K]
+
+func (q timerQueue[K]) Len() int           { return len(q) }
+func (q timerQueue[K]) Less(i, j int) bool { return q[i].at.Before(q[j].at) }
+func (q timerQueue[K]) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *timerQueue[K]) Push(x any)        { *q = append(*q, x.(queuedTimer[K])) }
+func (q *timerQueue[K]) Pop() any {
+	old := *q
+	x := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return x
+}
+
+func (p *statePart[K, V]) setTimer(id timerID[K], domain TimeDomain, at time.Time) {
+	p.seq++
+	p.timers[id] = timerEntry{domain: domain, at: at, seq: p.seq}
+	q := &p.queues[domain]
+	heap.Push(q, queuedTimer[K]{id: id, domain: domain, at: at, seq: p.seq})
+	if len(*q) > 64 && len(*q) > 2*len(p.timers) {
+		p.compact(domain)
+	}
+}
+
+// compact drops the stale entries of a queue.
+func (p *statePart[K, V]) compact(domain TimeDomain) {
+	q := p.queues[domain][:0]
+	for _, t := range p.queues[domain] {
+		if p.current(t) {
+			q = append(q, t)
+		}
+	}
+	heap.Init(&q)
+	p.queues[domain] = q
+}
+
+func (p *statePart[K, V]) current(t queuedTimer[K]) bool {
+	e, ok := p.timers[t.id]
+	return ok && e.seq == t.seq
+}
+
+// peek returns the earliest live timer of a domain.
+func (p *statePart[K, V]) peek(domain TimeDomain) (queuedTimer[K], bool) {
+	q := &p.queues[domain]
+	for q.Len() > 0 {
+		if t := (*q)[0]; p.current(t) {
+			return t, true
+		}
+		heap.Pop(q)
+	}
+	return queuedTimer[K]{}, false
+}
+
+// due removes and returns the timers of a domain at or before until.
+func (p *statePart[K, V]) due(domain TimeDomain, until time.Time, out []queuedTimer[K]) []queuedTimer[K] {
+	for {
+		t, ok := p.peek(domain)
+		if !ok || t.at.After(until) {
+			return out
+		}
+		heap.Pop(&p.queues[domain])
+		delete(p.timers, t.id)
+		out = append(out, t)
+	}
+}
+
+func (p *statePart[K, V]) poke() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,35 @@
+package pipeline
+
+import (
+	"io"
+
+	"github.com/jpconstantineau/data-duct/internal/pipelineinternal"
+)
+
+// TraceRecorder collects sampled per-item spans (queue waits, handler time,
+// batch waits and flushes) in the Chrome Trace Event JSON format. Each run
+// appears as its own process.
+type TraceRecorder struct {
+	t *pipelineinternal.Tracer
+}
+
+// DefaultTraceEvents is the number of events NewTraceRecorder keeps.
+const DefaultTraceEvents = 1 << 20
+
+// NewTraceRecorder creates an empty recorder that keeps the latest
+// DefaultTraceEvents events. Attach it with WithTrace.
+func NewTraceRecorder() *TraceRecorder {
+	return NewTraceRecorderSize(DefaultTraceEvents)
+}
+
+// NewTraceRecorderSize creates an empty recorder that keeps the latest n
+// events, so a long-running pipeline traces in bounded memory.
+func NewTraceRecorderSize(n int) *TraceRecorder {
+	return &TraceRecorder{t: pipelineinternal.NewTracer(n)}
+}
+
+// WriteTo writes the events recorded so far, up to the recorder's limit, as a
+// Chrome Trace Event JSON object.
+func (r *TraceRecorder) WriteTo(w io.Writer) (int64, error) {
+	return r.t.WriteJSON(w)
+}
@@ -0,0 +1,106 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/jpconstantineau/data-duct/internal/pipelineinternal"
+)
+
+// TxSink is a sink that writes items inside transactions, for example a
+// database writer. The pipeline calls Begin before the first item of each
+// transaction, Write for every item, and then either Commit or Abort.
+type TxSink[T any] interface {
+	Begin(ctx context.Context) error
+	Write(ctx context.Context, item T) error
+	Commit(ctx context.Context) error
+	Abort(ctx context.Context) error
+}
+
+// TxPolicy commits a transaction after Size items, after MaxWait and at the
+// end of the input. Zero values disable a trigger.
+type TxPolicy struct {
+	Size    int
+	MaxWait time.Duration
+}
+
+type txControl interface {
+	Begin(ctx context.Context) error
+	Commit(ctx context.Context) error
+	Abort(ctx context.Context) error
+}
+
+// ToTx finalizes the pipeline with sink, a TxSink for the previous stage's
+// output type. Items count as delivered only once their transaction commits.
+func (p *Pipeline) ToTx(sink any, policy TxPolicy, opts ...StageOption) *Runnable {
+	if p == nil || p.def == nil {
+		panic("pipeline: builder must not be nil")
+	}
+	so := p.stageOptions(opts)
+	if sink == nil {
+		p.def.failSink(errors.New("sink must not be nil"))
+		return p.to(nil, so)
+	}
+	var write reflect.Value
+	ctl, ok := sink.(txControl)
+	if ok {
+		write = reflect.ValueOf(sink).MethodByName("Write")
+	}
+	if !write.IsValid() {
+		p.def.failSink(fmt.Errorf("transactional sink must implement TxSink, got %T", sink))
+		return p.to(nil, so)
+	}
+	wrapped, err := wrapSink(write.Interface(), p.def.currentType)
+	if err != nil {
+		p.def.failSink(err)
+	}
+	return p.toTx(ctl, wrapped, policy, so)
+}
+
+// toTx finalizes the pipeline with a transactional sink controlled by ctl
+// that writes with write.
+func (p *Pipeline) toTx(ctl txControl, write pipelineinternal.Sink, policy TxPolicy, so stageOptions) *Runnable {
+	if policy.Size < 0 {
+		policy.Size = 0
+	}
+	if policy.MaxWait <= 0 && p.def.checkpoints != nil {
+		policy.MaxWait = p.def.checkpoints.interval
+	}
+
+	p.checkState()
+	p.checkInFlight(&policy)
+	wrapped := wrapSinkCalls(write, so.wrap)
+	p.def.sink = wrapped
+	p.def.tx = &pipelineinternal.TxSink{
+		Begin:   ctl.Begin,
+		Write:   wrapped,
+		Commit:  ctl.Commit,
+		Abort:   ctl.Abort,
+		Size:    policy.Size,
+		MaxWait: policy.MaxWait,
+	}
+	p.def.txPolicy = &policy
+	p.def.sinkLife = lifecycleOf(ctl)
+	return &Runnable{def: p.def}
+}
+
+// IdempotencyKey returns the key of the item a sink is writing: its source
+// position or WithIdempotencyKey, derived per output by batch stages.
+func IdempotencyKey(ctx context.Context) (string, bool) {
+	return pipelineinternal.ItemKey(ctx)
+}
+
+// WithIdempotencyKey keys each source item with fn instead of its position.
+// T must be the source item type.
+func WithIdempotencyKey[T any](fn func(T) string) Option {
+	return func(o *pipelineOptions) {
+		o.keyType = typeOf[T]()
+		o.key = func(v any) string {
+			t, _ := v.(T)
+			return fn(t)
+		}
+	}
+}
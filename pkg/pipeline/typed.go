@@ -0,0 +1,134 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Typed is a pipeline builder whose item type T is checked by the compiler.
+// Stages are added with the generic functions Then, ThenBatch, To and ToTx.
+type Typed[T any] struct {
+	p *Pipeline
+}
+
+// NewTyped creates a typed pipeline builder; see New.
+func NewTyped[T any](name string, source SourceFunc[T], opts ...Option) *Typed[T] {
+	return &Typed[T]{p: New(name, source, opts...)}
+}
+
+// AsTyped returns a typed view of p, whose last stage must output exactly T.
+// It panics otherwise, unless p was created with WithBuildErrors.
+func AsTyped[T any](p *Pipeline) *Typed[T] {
+	if p == nil || p.def == nil {
+		panic("pipeline: builder must not be nil")
+	}
+	if want := typeOf[T](); p.def.currentType != want {
+		p.def.failStage("", fmt.Errorf("builder outputs %v, not %v", p.def.currentType, want))
+	}
+	return &Typed[T]{p: p}
+}
+
+// Pipeline returns the fluent builder of t.
+func (t *Typed[T]) Pipeline() *Pipeline {
+	if t == nil {
+		return nil
+	}
+	return t.p
+}
+
+// Then adds a single-item stage; see Pipeline.Then.
+func Then[In, Out any](t *Typed[In], handler Handler[In, Out], opts ...StageOption) *Typed[Out] {
+	p := t.builder()
+	so := p.stageOptions(opts)
+	if handler == nil {
+		p.def.failStage(so.name, errors.New("handler must not be nil"))
+	}
+	wrapped := func(ctx context.Context, input any) (any, error) {
+		in, err := typedValue[In](input)
+		if err != nil {
+			return nil, err
+		}
+		out, err := handler(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+	return &Typed[Out]{p: p.then(wrapped, typeOf[Out](), so)}
+}
+
+// ThenBatch adds a batch stage; see Pipeline.ThenBatch.
+func ThenBatch[In, Out any](t *Typed[In], handler BatchHandler[In, Out], batch BatchPolicy, opts ...StageOption) *Typed[Out] {
+	p := t.builder()
+	so := p.stageOptions(opts)
+	if handler == nil {
+		p.def.failStage(so.name, errors.New("batch handler must not be nil"))
+	}
+	wrapped := func(ctx context.Context, inputs []any) ([]any, error) {
+		in := make([]In, len(inputs))
+		for i, v := range inputs {
+			var err error
+			if in[i], err = typedValue[In](v); err != nil {
+				return nil, err
+			}
+		}
+		outs, err := handler(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+		anyOut := make([]any, len(outs))
+		for i, o := range outs {
+			anyOut[i] = o
+		}
+		return anyOut, nil
+	}
+	return &Typed[Out]{p: p.thenBatch(wrapped, typeOf[Out](), batch, so)}
+}
+
+// To finalizes the pipeline with a sink; see Pipeline.To.
+func To[T any](t *Typed[T], sink EndHandler[T], opts ...StageOption) *Runnable {
+	p := t.builder()
+	so := p.stageOptions(opts)
+	if sink == nil {
+		p.def.failSink(errors.New("sink must not be nil"))
+	}
+	return p.to(typedSink(sink), so)
+}
+
+// ToTx finalizes the pipeline with a transactional sink; see Pipeline.ToTx.
+func ToTx[T any](t *Typed[T], sink TxSink[T], policy TxPolicy, opts ...StageOption) *Runnable {
+	p := t.builder()
+	so := p.stageOptions(opts)
+	if sink == nil {
+		p.def.failSink(errors.New("sink must not be nil"))
+		return p.to(nil, so)
+	}
+	return p.toTx(sink, typedSink(sink.Write), policy, so)
+}
+
+func (t *Typed[T]) builder() *Pipeline {
+	if t == nil || t.p == nil || t.p.def == nil {
+		panic("pipeline: builder must not be nil")
+	}
+	return t.p
+}
+
+func typedSink[T any](sink EndHandler[T]) func(ctx context.Context, input any) error {
+	return func(ctx context.Context, input any) error {
+		in, err := typedValue[T](input)
+		if err != nil {
+			return err
+		}
+		return sink(ctx, in)
+	}
+}
+
+// typedValue unboxes an item; a nil item is the zero value of T.
+func typedValue[T any](v any) (T, error) {
+	t, ok := v.(T)
+	if !ok && v != nil {
+		return t, fmt.Errorf("pipeline: cannot use %T as %v", v, typeOf[T]())
+	}
+	return t, nil
+}
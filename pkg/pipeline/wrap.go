@@ -0,0 +1,62 @@
+package pipeline
+
+import (
+	"context"
+
+	"github.com/jpconstantineau/data-duct/internal/pipelineinternal"
+)
+
+// Call is a stage's handler, batch handler or sink adapted from its typed
+// function; a batch stage gets a []any value.
+type Call func(ctx context.Context, value any) (any, error)
+
+// WithCallWrapper wraps every call of the stage's handler, or of the sink
+// when passed to To or ToTx, with wrap; the first wrapper is the outermost.
+func WithCallWrapper(wrap func(next Call) Call) StageOption {
+	return func(o *stageOptions) {
+		if wrap != nil {
+			o.wrap = append(o.wrap, wrap)
+		}
+	}
+}
+
+func wrapCall(c Call, wraps []func(Call) Call) Call {
+	for i := len(wraps) - 1; i >= 0; i-- {
+		c = wraps[i](c)
+	}
+	return c
+}
+
+func wrapSingleCalls(h pipelineinternal.SingleHandler, wraps []func(Call) Call) pipelineinternal.SingleHandler {
+	if len(wraps) == 0 {
+		return h
+	}
+	return pipelineinternal.SingleHandler(wrapCall(Call(h), wraps))
+}
+
+func wrapBatchCalls(h pipelineinternal.BatchHandler, wraps []func(Call) Call) pipelineinternal.BatchHandler {
+	if len(wraps) == 0 {
+		return h
+	}
+	c := wrapCall(func(ctx context.Context, value any) (any, error) {
+		return h(ctx, value.([]any))
+	}, wraps)
+	return func(ctx context.Context, inputs []any) ([]any, error) {
+		out, err := c(ctx, inputs)
+		outs, _ := out.([]any)
+		return outs, err
+	}
+}
+
+func wrapSinkCalls(s pipelineinternal.Sink, wraps []func(Call) Call) pipelineinternal.Sink {
+	if len(wraps) == 0 {
+		return s
+	}
+	c := wrapCall(func(ctx context.Context, value any) (any, error) {
+		return nil, s(ctx, value)
+	}, wraps)
+	return func(ctx context.Context, input any) error {
+		_, err := c(ctx, input)
+		return err
+	}
+}